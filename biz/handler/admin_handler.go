@@ -0,0 +1,153 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/model"
+    "github.com/sweekar/biz/service"
+)
+
+type AdminHandler struct {
+    scheduler *service.EmotionScheduler
+}
+
+func NewAdminHandler(scheduler *service.EmotionScheduler) *AdminHandler {
+    return &AdminHandler{scheduler: scheduler}
+}
+
+// BackfillRequest 补生成报告请求
+type BackfillRequest struct {
+    UserID    uint64             `json:"user_id" binding:"required"`
+    Period    model.ReportPeriod `json:"period"`
+    StartDate string             `json:"start_date" binding:"required"`
+    EndDate   string             `json:"end_date" binding:"required"`
+    PushFrom  string             `json:"push_from"` // 周期在该日期之前结束的报告不自动推送，默认为今天
+}
+
+// 为孩子补生成一段日期内的情绪报告
+func (h *AdminHandler) Backfill(c *gin.Context) {
+    var req BackfillRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+    if req.Period == "" {
+        req.Period = model.ReportDaily
+    }
+
+    results, err := h.scheduler.Backfill(c.Request.Context(), req.UserID, req.Period, req.StartDate, req.EndDate, req.PushFrom)
+    if err != nil {
+        if errors.Is(err, service.ErrInvalidBackfill) {
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "补生成完成", Data: results})
+}
+
+// 重新推送一份情绪报告
+func (h *AdminHandler) RepushReport(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "报告ID无效"})
+        return
+    }
+
+    if err := h.scheduler.RepushReport(c.Request.Context(), id); err != nil {
+        if errors.Is(err, service.ErrReportNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "已加入推送队列"})
+}
+
+// 获取定时任务及其是否暂停
+func (h *AdminHandler) ListJobs(c *gin.Context) {
+    jobs, err := h.scheduler.Jobs(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: jobs})
+}
+
+// 暂停定时任务
+func (h *AdminHandler) PauseJob(c *gin.Context) {
+    h.setPaused(c, true)
+}
+
+// 恢复暂停的定时任务
+func (h *AdminHandler) ResumeJob(c *gin.Context) {
+    h.setPaused(c, false)
+}
+
+func (h *AdminHandler) setPaused(c *gin.Context, paused bool) {
+    operatorID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    ctx, job := c.Request.Context(), c.Param("job")
+    if paused {
+        err = h.scheduler.PauseJob(ctx, job, operatorID)
+    } else {
+        err = h.scheduler.ResumeJob(ctx, job, operatorID)
+    }
+    if err != nil {
+        if errors.Is(err, service.ErrJobNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功"})
+}
+
+// 获取定时任务的执行记录，可按任务和状态过滤
+func (h *AdminHandler) ListJobRuns(c *gin.Context) {
+    page, pageSize := pagination(c)
+
+    runs, total, err := h.scheduler.ListJobRuns(c.Request.Context(), c.Query("job"), model.JobStatus(c.Query("status")), page, pageSize)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: gin.H{
+        "total": total,
+        "items": runs,
+    }})
+}
+
+// 重试已达到最大执行次数的失败任务
+func (h *AdminHandler) RetryJobRun(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "任务ID无效"})
+        return
+    }
+
+    if err := h.scheduler.RetryJobRun(c.Request.Context(), id); err != nil {
+        if errors.Is(err, service.ErrJobRunNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "将在下一次调度时重试"})
+}
\ No newline at end of file
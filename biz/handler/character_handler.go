@@ -0,0 +1,131 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/model"
+    "github.com/sweekar/biz/service"
+)
+
+type CharacterHandler struct {
+    characterService *service.CharacterService
+}
+
+func NewCharacterHandler(characterService *service.CharacterService) *CharacterHandler {
+    return &CharacterHandler{characterService: characterService}
+}
+
+type CharacterRequest struct {
+    Name         string   `json:"name" binding:"required,max=64"`
+    Description  string   `json:"description"`
+    SystemPrompt string   `json:"system_prompt" binding:"required"`
+    VoiceID      string   `json:"voice_id" binding:"required"`
+    StyleRules   []string `json:"style_rules"`
+    IsDefault    bool     `json:"is_default"`
+}
+
+func (r *CharacterRequest) toModel() *model.Character {
+    return &model.Character{
+        Name:         r.Name,
+        Description:  r.Description,
+        SystemPrompt: r.SystemPrompt,
+        VoiceID:      r.VoiceID,
+        StyleRules:   r.StyleRules,
+        IsDefault:    r.IsDefault,
+    }
+}
+
+// 获取所有系统角色
+func (h *CharacterHandler) ListCharacters(c *gin.Context) {
+    characters, err := h.characterService.ListCharacters(c.Request.Context())
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: characters})
+}
+
+// 获取指定系统角色
+func (h *CharacterHandler) GetCharacter(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "角色ID无效"})
+        return
+    }
+
+    character, err := h.characterService.GetCharacter(c.Request.Context(), id)
+    if err != nil {
+        h.writeError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: character})
+}
+
+// 创建系统角色
+func (h *CharacterHandler) CreateCharacter(c *gin.Context) {
+    var req CharacterRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    character := req.toModel()
+    if err := h.characterService.CreateCharacter(c.Request.Context(), character); err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "创建成功", Data: character})
+}
+
+// 更新系统角色
+func (h *CharacterHandler) UpdateCharacter(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "角色ID无效"})
+        return
+    }
+
+    var req CharacterRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    character := req.toModel()
+    character.ID = id
+    if err := h.characterService.UpdateCharacter(c.Request.Context(), character); err != nil {
+        h.writeError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功", Data: character})
+}
+
+// 删除系统角色
+func (h *CharacterHandler) DeleteCharacter(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "角色ID无效"})
+        return
+    }
+
+    if err := h.characterService.DeleteCharacter(c.Request.Context(), id); err != nil {
+        h.writeError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "删除成功"})
+}
+
+func (h *CharacterHandler) writeError(c *gin.Context, err error) {
+    if errors.Is(err, service.ErrCharacterNotFound) {
+        c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+        return
+    }
+    c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+}
@@ -1,52 +1,82 @@
 package handler
 
 import (
+    "errors"
     "net/http"
+    "strconv"
     "github.com/gin-gonic/gin"
     "github.com/sweekar/pkg/websocket"
+    "github.com/sweekar/biz/model"
     "github.com/sweekar/biz/service"
 )
 
 type ChatHandler struct {
-    chatService *service.ChatService
-    wsHandler  *websocket.Handler
+    chatService   *service.ChatService
+    userService   *service.UserService
+    familyService *service.FamilyService
+    wsHandler     *websocket.Handler
 }
 
-func NewChatHandler(chatService *service.ChatService, wsHandler *websocket.Handler) *ChatHandler {
+func NewChatHandler(chatService *service.ChatService, userService *service.UserService, familyService *service.FamilyService, wsHandler *websocket.Handler) *ChatHandler {
     return &ChatHandler{
-        chatService: chatService,
-        wsHandler:  wsHandler,
+        chatService:   chatService,
+        userService:   userService,
+        familyService: familyService,
+        wsHandler:     wsHandler,
     }
 }
 
 // WebSocket连接处理
 func (h *ChatHandler) HandleWebSocket(c *gin.Context) {
-    userID := c.GetString("user_id")
-    if userID == "" {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
         c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
         return
     }
 
-    // 升级HTTP连接为WebSocket连接
-    if err := h.wsHandler.HandleConnection(c.Writer, c.Request, userID); err != nil {
+    // 孩子的连接记录所有监护人和偏好的角色，家长的连接记录其监护的孩子，用于推送报告和提醒
+    user, err := h.userService.GetUser(c.Request.Context(), userID)
+    if err != nil {
+        if errors.Is(err, service.ErrUserNotFound) {
+            c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+    client := &websocket.Client{UserID: userID}
+    if user.Profile != nil {
+        client.CharacterID = user.Profile.CharacterID
+    }
+    switch user.Role {
+    case model.UserRoleChild:
+        client.GuardianIDs, err = h.familyService.GuardianIDs(c.Request.Context(), userID)
+    case model.UserRoleParent:
+        client.ChildIDs, err = h.familyService.GuardedChildIDs(c.Request.Context(), userID)
+    }
+    if err != nil {
         c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
-}
 
-// 获取用户的聊天历史记录
-func (h *ChatHandler) GetChatHistory(c *gin.Context) {
-    userID := c.GetString("user_id")
-    if userID == "" {
-        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+    // 升级HTTP连接为WebSocket连接，连接关闭前不会返回
+    if err := h.wsHandler.ServeWS(c.Writer, c.Request, client); err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
+}
 
-    history, err := h.chatService.GetChatHistory(c.Request.Context(), userID)
+// 家长分页获取孩子的聊天记录，孩子由child_id指定
+func (h *ChatHandler) GetChatHistory(c *gin.Context) {
+    page, pageSize := pagination(c)
+    history, total, err := h.chatService.GetChatHistory(c.Request.Context(), c.GetUint64("child_id"), page, pageSize)
     if err != nil {
         c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
 
-    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: history})
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: gin.H{
+        "total": total,
+        "items": history,
+    }})
 }
\ No newline at end of file
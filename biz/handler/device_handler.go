@@ -0,0 +1,129 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/service"
+)
+
+type DeviceHandler struct {
+    deviceService *service.DeviceService
+}
+
+func NewDeviceHandler(deviceService *service.DeviceService) *DeviceHandler {
+    return &DeviceHandler{deviceService: deviceService}
+}
+
+type PairDeviceRequest struct {
+    Code     string `json:"code" binding:"required"`
+    DeviceID string `json:"device_id" binding:"required"`
+    Name     string `json:"name"`
+}
+
+// 家长为孩子生成设备配对码
+func (h *DeviceHandler) CreatePairingCode(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    childID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "孩子ID无效"})
+        return
+    }
+
+    code, err := h.deviceService.CreatePairingCode(c.Request.Context(), parentID, childID)
+    if err != nil {
+        h.deviceError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "生成成功", Data: code})
+}
+
+// 孩子的设备用配对码换取设备令牌
+func (h *DeviceHandler) Pair(c *gin.Context) {
+    var req PairDeviceRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    token, err := h.deviceService.Pair(c.Request.Context(), req.Code, req.DeviceID, req.Name, c.ClientIP())
+    if err != nil {
+        h.deviceError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "配对成功", Data: token})
+}
+
+// 获取孩子已配对的设备，可以用child_id筛选
+func (h *DeviceHandler) ListDevices(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var childID uint64
+    if value := c.Query("child_id"); value != "" {
+        childID, err = strconv.ParseUint(value, 10, 64)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "孩子ID无效"})
+            return
+        }
+    }
+
+    devices, err := h.deviceService.ListDevices(c.Request.Context(), parentID, childID)
+    if err != nil {
+        h.deviceError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: devices})
+}
+
+// 解除设备配对
+func (h *DeviceHandler) RevokeDevice(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "设备ID无效"})
+        return
+    }
+
+    if err := h.deviceService.RevokeDevice(c.Request.Context(), parentID, id); err != nil {
+        h.deviceError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "解除成功"})
+}
+
+// deviceError 将设备配对的错误转换为响应
+func (h *DeviceHandler) deviceError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, service.ErrInvalidDevice):
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+    case errors.Is(err, service.ErrInvalidPairingCode):
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: err.Error()})
+    case errors.Is(err, service.ErrReadOnlyGuardian):
+        c.JSON(http.StatusForbidden, Response{Code: 403, Message: err.Error()})
+    case errors.Is(err, service.ErrTooManyPairingAttempts):
+        c.JSON(http.StatusTooManyRequests, Response{Code: 429, Message: err.Error()})
+    case errors.Is(err, service.ErrChildNotFound), errors.Is(err, service.ErrDeviceNotFound):
+        c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+    default:
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+    }
+}
\ No newline at end of file
@@ -1,7 +1,10 @@
 package handler
 
 import (
+    "errors"
     "net/http"
+    "strconv"
+    "time"
     "github.com/gin-gonic/gin"
     "github.com/sweekar/biz/model"
     "github.com/sweekar/biz/service"
@@ -9,41 +12,93 @@ import (
 
 type EmotionHandler struct {
     emotionProcessor *service.EmotionProcessor
+    familyService    *service.FamilyService
 }
 
-func NewEmotionHandler(emotionProcessor *service.EmotionProcessor) *EmotionHandler {
-    return &EmotionHandler{emotionProcessor: emotionProcessor}
+func NewEmotionHandler(emotionProcessor *service.EmotionProcessor, familyService *service.FamilyService) *EmotionHandler {
+    return &EmotionHandler{emotionProcessor: emotionProcessor, familyService: familyService}
 }
 
-// 获取用户的情绪分析报告
+// 获取孩子最新的每日情绪报告，孩子由child_id指定
 func (h *EmotionHandler) GetEmotionReport(c *gin.Context) {
-    userID := c.GetString("user_id")
-    if userID == "" {
-        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+    childID := strconv.FormatUint(c.GetUint64("child_id"), 10)
+
+    report, err := h.emotionProcessor.GetEmotionReport(c.Request.Context(), childID)
+    if err != nil {
+        if errors.Is(err, service.ErrReportNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: report})
+}
+
+// 获取孩子的日报、周报或月报，带date时返回该日期所在周期的报告，否则分页返回最近的报告
+func (h *EmotionHandler) GetEmotionReports(c *gin.Context) {
+    childID := c.GetUint64("child_id")
+
+    period := model.ReportPeriod(c.DefaultQuery("period", string(model.ReportWeekly)))
+    if !period.Valid() {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "报告周期无效，可选day、week、month"})
+        return
+    }
+
+    if date := c.Query("date"); date != "" {
+        day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "日期格式无效，应为YYYY-MM-DD"})
+            return
+        }
+
+        report, err := h.emotionProcessor.GetPeriodReport(c.Request.Context(), childID, period, day)
+        if err != nil {
+            if errors.Is(err, service.ErrReportNotFound) {
+                c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+                return
+            }
+            c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+            return
+        }
+
+        c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: report})
         return
     }
 
-    report, err := h.emotionProcessor.GetEmotionReport(c.Request.Context(), userID)
+    page, pageSize := pagination(c)
+    reports, total, err := h.emotionProcessor.ListReports(c.Request.Context(), childID, period, page, pageSize)
     if err != nil {
         c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
 
-    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: report})
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: gin.H{
+        "total": total,
+        "items": reports,
+    }})
 }
 
-// 获取用户的情绪趋势分析
+// 获取孩子的情绪趋势分析，支持按小时、天或周统计，未指定时区时按孩子所在家庭的时区统计
 func (h *EmotionHandler) GetEmotionTrend(c *gin.Context) {
-    userID := c.GetString("user_id")
-    if userID == "" {
-        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+    childID := c.GetUint64("child_id")
+    family, err := h.familyService.GetFamilyByUser(c.Request.Context(), childID)
+    if errors.Is(err, service.ErrFamilyNotFound) {
+        family, err = model.DefaultFamily(), nil
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
 
-    startTime := c.Query("start_time")
-    endTime := c.Query("end_time")
+    query, err := service.ParseTrendQuery(c.Query("start_time"), c.Query("end_time"), c.Query("granularity"), c.Query("timezone"), family.Location(), time.Now())
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
 
-    trend, err := h.emotionProcessor.GetEmotionTrend(c.Request.Context(), userID, startTime, endTime)
+    trend, err := h.emotionProcessor.GetEmotionTrend(c.Request.Context(), childID, query)
     if err != nil {
         c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
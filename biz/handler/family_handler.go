@@ -0,0 +1,69 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/service"
+)
+
+type FamilyHandler struct {
+    familyService *service.FamilyService
+}
+
+func NewFamilyHandler(familyService *service.FamilyService) *FamilyHandler {
+    return &FamilyHandler{familyService: familyService}
+}
+
+// 获取家庭的时区、报告生成和推送时间设置
+func (h *FamilyHandler) GetSettings(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    family, err := h.familyService.GetFamilyByUser(c.Request.Context(), userID)
+    if err != nil {
+        if errors.Is(err, service.ErrFamilyNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: family})
+}
+
+// 更新家庭的时区、报告生成和推送时间以及免打扰时段
+func (h *FamilyHandler) UpdateSettings(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var settings service.FamilySettings
+    if err := c.ShouldBindJSON(&settings); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    family, err := h.familyService.UpdateSettings(c.Request.Context(), userID, &settings)
+    if err != nil {
+        if errors.Is(err, service.ErrInvalidFamilySettings) {
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+            return
+        }
+        if errors.Is(err, service.ErrReadOnlyGuardian) {
+            c.JSON(http.StatusForbidden, Response{Code: 403, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功", Data: family})
+}
\ No newline at end of file
@@ -0,0 +1,194 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/model"
+    "github.com/sweekar/biz/service"
+)
+
+type GuardianHandler struct {
+    guardianService *service.GuardianService
+}
+
+func NewGuardianHandler(guardianService *service.GuardianService) *GuardianHandler {
+    return &GuardianHandler{guardianService: guardianService}
+}
+
+type AcceptInvitationRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+type UpdateGuardianRequest struct {
+    Permission model.GuardianPermission `json:"permission" binding:"required"`
+}
+
+// 获取家庭中的所有监护人
+func (h *GuardianHandler) ListGuardians(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    guardians, err := h.guardianService.ListGuardians(c.Request.Context(), userID)
+    if err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: guardians})
+}
+
+// 修改监护人的权限级别
+func (h *GuardianHandler) UpdateGuardian(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    guardianID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "监护人ID无效"})
+        return
+    }
+
+    var req UpdateGuardianRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    if err := h.guardianService.UpdateGuardian(c.Request.Context(), userID, guardianID, req.Permission); err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功"})
+}
+
+// 将监护人移出家庭，移除自己即退出家庭
+func (h *GuardianHandler) RemoveGuardian(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    guardianID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "监护人ID无效"})
+        return
+    }
+
+    if err := h.guardianService.RemoveGuardian(c.Request.Context(), userID, guardianID); err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "移除成功"})
+}
+
+// 邀请其他家长作为监护人，填写邮箱时将邀请链接发送到邮箱
+func (h *GuardianHandler) Invite(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var input service.InvitationInput
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    result, err := h.guardianService.Invite(c.Request.Context(), userID, &input)
+    if err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "邀请成功", Data: result})
+}
+
+// 获取还未接受的邀请
+func (h *GuardianHandler) ListInvitations(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    invitations, err := h.guardianService.ListInvitations(c.Request.Context(), userID)
+    if err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: invitations})
+}
+
+// 撤销邀请
+func (h *GuardianHandler) RevokeInvitation(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "邀请ID无效"})
+        return
+    }
+
+    if err := h.guardianService.RevokeInvitation(c.Request.Context(), userID, id); err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "撤销成功"})
+}
+
+// 接受邀请，成为家庭的监护人
+func (h *GuardianHandler) AcceptInvitation(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var req AcceptInvitationRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    family, err := h.guardianService.AcceptInvitation(c.Request.Context(), userID, req.Token)
+    if err != nil {
+        h.guardianError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "已加入家庭", Data: family})
+}
+
+// guardianError 将监护人管理的错误转换为响应
+func (h *GuardianHandler) guardianError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, service.ErrInvalidInvitation):
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+    case errors.Is(err, service.ErrReadOnlyGuardian), errors.Is(err, service.ErrNotParent):
+        c.JSON(http.StatusForbidden, Response{Code: 403, Message: err.Error()})
+    case errors.Is(err, service.ErrFamilyNotFound), errors.Is(err, service.ErrGuardianNotFound), errors.Is(err, service.ErrInvitationNotFound):
+        c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+    case errors.Is(err, service.ErrAlreadyGuardian), errors.Is(err, service.ErrInOtherFamily), errors.Is(err, service.ErrLastFamilyManager):
+        c.JSON(http.StatusConflict, Response{Code: 409, Message: err.Error()})
+    default:
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+    }
+}
\ No newline at end of file
@@ -0,0 +1,87 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/service"
+)
+
+type NotificationHandler struct {
+    notificationService *service.NotificationService
+}
+
+func NewNotificationHandler(notificationService *service.NotificationService) *NotificationHandler {
+    return &NotificationHandler{notificationService: notificationService}
+}
+
+// 获取家庭的通知渠道设置
+func (h *NotificationHandler) GetSettings(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    settings, err := h.notificationService.GetSettings(c.Request.Context(), userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: settings})
+}
+
+// 更新家庭的通知渠道、备用渠道以及邮箱和Webhook地址
+func (h *NotificationHandler) UpdateSettings(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var input service.NotificationSettingsInput
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    settings, err := h.notificationService.UpdateSettings(c.Request.Context(), userID, &input)
+    if err != nil {
+        if errors.Is(err, service.ErrInvalidNotificationSettings) {
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+            return
+        }
+        if errors.Is(err, service.ErrReadOnlyGuardian) {
+            c.JSON(http.StatusForbidden, Response{Code: 403, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功", Data: settings})
+}
+
+// 获取通知记录及各渠道的送达情况
+func (h *NotificationHandler) ListNotifications(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    page, pageSize := pagination(c)
+
+    notifications, total, err := h.notificationService.ListNotifications(c.Request.Context(), userID, page, pageSize)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: gin.H{
+        "total": total,
+        "items": notifications,
+    }})
+}
\ No newline at end of file
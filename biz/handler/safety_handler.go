@@ -0,0 +1,116 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/service"
+)
+
+type SafetyHandler struct {
+    safetyFilter *service.SafetyFilter
+    alertService *service.AlertService
+}
+
+func NewSafetyHandler(safetyFilter *service.SafetyFilter, alertService *service.AlertService) *SafetyHandler {
+    return &SafetyHandler{
+        safetyFilter: safetyFilter,
+        alertService: alertService,
+    }
+}
+
+// 获取孩子的安全干预记录，供家长查看，孩子由child_id指定
+func (h *SafetyHandler) ListInterventions(c *gin.Context) {
+    childID := c.GetUint64("child_id")
+
+    page, pageSize := pagination(c)
+    onlyUnreviewed := c.Query("unreviewed") == "true"
+
+    interventions, total, err := h.safetyFilter.ListInterventions(c.Request.Context(), childID, onlyUnreviewed, page, pageSize)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: gin.H{
+        "total": total,
+        "items": interventions,
+    }})
+}
+
+// 将安全干预记录标记为已查看
+func (h *SafetyHandler) ReviewIntervention(c *gin.Context) {
+    childID := c.GetUint64("child_id")
+
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "记录ID无效"})
+        return
+    }
+
+    if err := h.safetyFilter.MarkReviewed(c.Request.Context(), childID, id); err != nil {
+        if errors.Is(err, service.ErrInterventionNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功"})
+}
+
+// 获取孩子的家长提醒，孩子由child_id指定
+func (h *SafetyHandler) ListAlerts(c *gin.Context) {
+    childID := c.GetUint64("child_id")
+
+    page, pageSize := pagination(c)
+    onlyUnacknowledged := c.Query("unacknowledged") == "true"
+
+    alerts, total, err := h.alertService.ListAlerts(c.Request.Context(), childID, onlyUnacknowledged, page, pageSize)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: gin.H{
+        "total": total,
+        "items": alerts,
+    }})
+}
+
+// 家长确认提醒
+func (h *SafetyHandler) AcknowledgeAlert(c *gin.Context) {
+    childID := c.GetUint64("child_id")
+
+    id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "提醒ID无效"})
+        return
+    }
+
+    if err := h.alertService.AcknowledgeAlert(c.Request.Context(), childID, id); err != nil {
+        if errors.Is(err, service.ErrAlertNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "确认成功"})
+}
+
+// pagination 解析分页参数
+func pagination(c *gin.Context) (int, int) {
+    page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+    pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+    if page < 1 {
+        page = 1
+    }
+    if pageSize < 1 || pageSize > 100 {
+        pageSize = 20
+    }
+    return page, pageSize
+}
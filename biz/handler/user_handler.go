@@ -1,8 +1,9 @@
 package handler
 
 import (
-    "encoding/json"
+    "errors"
     "net/http"
+    "strconv"
     "github.com/gin-gonic/gin"
     "github.com/sweekar/biz/model"
     "github.com/sweekar/biz/service"
@@ -10,10 +11,14 @@ import (
 
 type UserHandler struct {
     userService *service.UserService
+    authService *service.AuthService
 }
 
-func NewUserHandler(userService *service.UserService) *UserHandler {
-    return &UserHandler{userService: userService}
+func NewUserHandler(userService *service.UserService, authService *service.AuthService) *UserHandler {
+    return &UserHandler{
+        userService: userService,
+        authService: authService,
+    }
 }
 
 type RegisterRequest struct {
@@ -27,6 +32,19 @@ type LoginRequest struct {
     Password string `json:"password" binding:"required"`
 }
 
+type RefreshRequest struct {
+    RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+    OldPassword string `json:"old_password" binding:"required"`
+    NewPassword string `json:"new_password" binding:"required"`
+}
+
+type UpdateUserRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
 type Response struct {
     Code    int         `json:"code"`
     Message string      `json:"message"`
@@ -41,12 +59,20 @@ func (h *UserHandler) Register(c *gin.Context) {
     }
 
     user := &model.User{
-        Username: req.Username,
+        Username: &req.Username,
         Password: req.Password,
         Email:    req.Email,
     }
 
     if err := h.userService.Register(c.Request.Context(), user); err != nil {
+        if errors.Is(err, service.ErrUsernameTaken) {
+            c.JSON(http.StatusConflict, Response{Code: 409, Message: err.Error()})
+            return
+        }
+        if errors.Is(err, service.ErrInvalidPassword) {
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+            return
+        }
         c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
@@ -61,11 +87,260 @@ func (h *UserHandler) Login(c *gin.Context) {
         return
     }
 
-    token, err := h.userService.Login(c.Request.Context(), req.Username, req.Password)
+    user, err := h.userService.Authenticate(c.Request.Context(), req.Username, req.Password)
+    if err != nil {
+        if errors.Is(err, service.ErrInvalidCredentials) {
+            c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    tokens, err := h.authService.Login(c.Request.Context(), user)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "登录成功", Data: gin.H{
+        "user":   user,
+        "tokens": tokens,
+    }})
+}
+
+// 用刷新令牌换取新的令牌，旧的刷新令牌随即失效
+func (h *UserHandler) Refresh(c *gin.Context) {
+    var req RefreshRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    tokens, err := h.authService.Refresh(c.Request.Context(), req.RefreshToken)
+    if err != nil {
+        if errors.Is(err, service.ErrInvalidToken) {
+            c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "刷新成功", Data: tokens})
+}
+
+// 退出登录，当前会话的令牌全部失效
+func (h *UserHandler) Logout(c *gin.Context) {
+    if err := h.authService.Logout(c.Request.Context(), c.GetString("session_id")); err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "已退出登录"})
+}
+
+// 修改密码，所有设备需要重新登录
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var req ChangePasswordRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    if err := h.userService.ChangePassword(c.Request.Context(), userID, req.OldPassword, req.NewPassword); err != nil {
+        switch {
+        case errors.Is(err, service.ErrInvalidCredentials), errors.Is(err, service.ErrInvalidPassword):
+            c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        case errors.Is(err, service.ErrUserNotFound):
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+        default:
+            c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        }
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "密码已修改，请重新登录"})
+}
+
+// 获取当前用户的信息
+func (h *UserHandler) GetProfile(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
     if err != nil {
-        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: err.Error()})
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    user, err := h.userService.GetUser(c.Request.Context(), userID)
+    if err != nil {
+        if errors.Is(err, service.ErrUserNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
         return
     }
 
-    c.JSON(http.StatusOK, Response{Code: 200, Message: "登录成功", Data: gin.H{"token": token}})
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: user})
+}
+
+// 修改当前用户的信息
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+    userID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var req UpdateUserRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    user, err := h.userService.UpdateUser(c.Request.Context(), userID, req.Email)
+    if err != nil {
+        if errors.Is(err, service.ErrUserNotFound) {
+            c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功", Data: user})
+}
+
+// 获取家长创建的孩子
+func (h *UserHandler) ListChildren(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    children, err := h.userService.ListChildren(c.Request.Context(), parentID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: children})
+}
+
+// 家长创建孩子账号和档案
+func (h *UserHandler) CreateChild(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    var input service.ChildInput
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    child, err := h.userService.CreateChild(c.Request.Context(), parentID, &input)
+    if err != nil {
+        h.childError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "创建成功", Data: child})
+}
+
+// 获取孩子档案
+func (h *UserHandler) GetChild(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    childID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "孩子ID无效"})
+        return
+    }
+
+    child, err := h.userService.GetChild(c.Request.Context(), parentID, childID)
+    if err != nil {
+        h.childError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "获取成功", Data: child})
+}
+
+// 更新孩子档案
+func (h *UserHandler) UpdateChild(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    childID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "孩子ID无效"})
+        return
+    }
+
+    var input service.ChildInput
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+        return
+    }
+
+    child, err := h.userService.UpdateChild(c.Request.Context(), parentID, childID, &input)
+    if err != nil {
+        h.childError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "更新成功", Data: child})
+}
+
+// 删除孩子账号
+func (h *UserHandler) DeleteChild(c *gin.Context) {
+    parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusUnauthorized, Response{Code: 401, Message: "未授权"})
+        return
+    }
+
+    childID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: "孩子ID无效"})
+        return
+    }
+
+    if err := h.userService.DeleteChild(c.Request.Context(), parentID, childID); err != nil {
+        h.childError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, Response{Code: 200, Message: "删除成功"})
+}
+
+// childError 将孩子管理的错误转换为响应
+func (h *UserHandler) childError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, service.ErrInvalidChildProfile):
+        c.JSON(http.StatusBadRequest, Response{Code: 400, Message: err.Error()})
+    case errors.Is(err, service.ErrNotParent), errors.Is(err, service.ErrReadOnlyGuardian):
+        c.JSON(http.StatusForbidden, Response{Code: 403, Message: err.Error()})
+    case errors.Is(err, service.ErrChildNotFound), errors.Is(err, service.ErrUserNotFound):
+        c.JSON(http.StatusNotFound, Response{Code: 404, Message: err.Error()})
+    default:
+        c.JSON(http.StatusInternalServerError, Response{Code: 500, Message: err.Error()})
+    }
 }
\ No newline at end of file
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+)
+
+// AuthSession 一次登录产生的会话，访问令牌和刷新令牌都属于某个会话，会话作废后其中的令牌全部失效
+type AuthSession struct {
+	ID              string     `json:"id" gorm:"primaryKey;size:32"` // 会话ID，写入访问令牌
+	UserID          uint64     `json:"user_id" gorm:"index"`         // 用户ID
+	ExpiresAt       time.Time  `json:"expires_at"`                   // 最新的刷新令牌过期时间，之后会话不再可用
+	RevokedAt       *time.Time `json:"revoked_at"`                   // 作废时间，退出登录、修改密码或发现刷新令牌被重复使用时设置
+	CreatedAt       time.Time  `json:"created_at"`                   // 登录时间
+	LastRefreshedAt time.Time  `json:"last_refreshed_at"`            // 最近一次刷新令牌的时间
+}
+
+// RefreshToken 刷新令牌，每次使用后换发新的令牌，只保存令牌的哈希
+type RefreshToken struct {
+	ID        uint64     `json:"id" gorm:"primaryKey"`
+	SessionID string     `json:"session_id" gorm:"size:32;index"` // 所属会话
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex"`    // 令牌的SHA-256
+	ExpiresAt time.Time  `json:"expires_at"`                      // 过期时间
+	UsedAt    *time.Time `json:"used_at"`                         // 换取新令牌的时间，再次使用说明令牌泄露，整个会话作废
+	CreatedAt time.Time  `json:"created_at"`                      // 签发时间
+}
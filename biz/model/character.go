@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+// Character 系统角色
+type Character struct {
+	ID           uint64    `json:"id" gorm:"primaryKey"`
+	Name         string    `json:"name" gorm:"uniqueIndex;size:64"`    // 角色名称，如小兔子、小熊
+	Description  string    `json:"description"`                        // 角色简介，供家长选择时展示
+	SystemPrompt string    `json:"system_prompt" gorm:"type:text"`     // 角色设定，作为大模型的系统提示词
+	VoiceID      string    `json:"voice_id"`                           // TTS音色ID
+	StyleRules   []string  `json:"style_rules" gorm:"serializer:json"` // 适龄表达规则
+	IsDefault    bool      `json:"is_default"`                         // 会话未选择角色时使用的默认角色
+	CreatedAt    time.Time `json:"created_at"`                         // 创建时间
+	UpdatedAt    time.Time `json:"updated_at"`                         // 更新时间
+}
@@ -15,11 +15,21 @@ const (
 	EmotionMessage MessageType = "emotion"  // 情绪消息
 )
 
+// MessageRole 消息发送方角色
+type MessageRole string
+
+const (
+	RoleChild     MessageRole = "child"     // 孩子
+	RoleCharacter MessageRole = "character" // 系统角色
+)
+
 // ChatMessage 聊天消息记录
 type ChatMessage struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	UserID    uint64            `bson:"user_id" json:"user_id"`         // 发送者ID
-	ParentID  uint64            `bson:"parent_id" json:"parent_id"`     // 家长ID
+	SessionID string            `bson:"session_id" json:"session_id"`   // 会话ID
+	MessageID string            `bson:"message_id,omitempty" json:"message_id,omitempty"` // 对应的语音消息ID
+	Role      MessageRole       `bson:"role" json:"role"`               // 发送方角色
 	Type      MessageType       `bson:"type" json:"type"`               // 消息类型
 	Content   string            `bson:"content" json:"content"`         // 消息内容
 	Emotion   *EmotionData     `bson:"emotion,omitempty" json:"emotion"` // 情绪数据
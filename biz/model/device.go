@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+)
+
+// PairingCode 家长为孩子生成的设备配对码，短时间内有效且只能使用一次，只保存配对码的哈希
+type PairingCode struct {
+	ID        uint64     `json:"id" gorm:"primaryKey"`
+	CodeHash  string     `json:"-" gorm:"size:64;index"` // 配对码的SHA-256
+	ChildID   uint64     `json:"child_id" gorm:"index"`  // 配对的孩子
+	ParentID  uint64     `json:"parent_id"`              // 生成配对码的家长
+	ExpiresAt time.Time  `json:"expires_at"`             // 过期时间
+	UsedAt    *time.Time `json:"used_at"`                // 使用时间，未使用时为空
+	CreatedAt time.Time  `json:"created_at"`             // 生成时间
+}
+
+// Device 孩子已配对的设备，设备令牌只能用于语音聊天，解除配对后令牌随会话作废
+type Device struct {
+	ID        uint64     `json:"id" gorm:"primaryKey"`
+	ChildID   uint64     `json:"child_id" gorm:"index"`        // 使用设备的孩子
+	ParentID  uint64     `json:"parent_id"`                    // 完成配对的家长
+	DeviceID  string     `json:"device_id" gorm:"size:128"`    // 设备自己生成的标识，写入设备令牌
+	Name      string     `json:"name" gorm:"size:64"`          // 设备名称
+	SessionID string     `json:"-" gorm:"size:32;uniqueIndex"` // 设备令牌所属的会话
+	RevokedAt *time.Time `json:"revoked_at"`                   // 解除配对的时间
+	CreatedAt time.Time  `json:"created_at"`                   // 配对时间
+}
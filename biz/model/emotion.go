@@ -2,6 +2,8 @@ package model
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // EmotionType 情绪类型
@@ -12,26 +14,245 @@ const (
 	EmotionSad     EmotionType = "sad"     // 难过
 	EmotionAngry   EmotionType = "angry"   // 生气
 	EmotionNeutral EmotionType = "neutral" // 平静
+
+	EmotionFear        EmotionType = "fear"        // 害怕
+	EmotionAnxiety     EmotionType = "anxiety"     // 担心、紧张
+	EmotionLoneliness  EmotionType = "loneliness"  // 孤单、想念
+	EmotionExcitement  EmotionType = "excitement"  // 兴奋、期待
+	EmotionBoredom     EmotionType = "boredom"     // 无聊
+	EmotionFrustration EmotionType = "frustration" // 受挫、沮丧
 )
 
+// EmotionTypes 所有情绪类型
+var EmotionTypes = []EmotionType{
+	EmotionHappy, EmotionExcitement, EmotionNeutral, EmotionBoredom,
+	EmotionSad, EmotionLoneliness, EmotionFear, EmotionAnxiety,
+	EmotionAngry, EmotionFrustration,
+}
+
+// BasicEmotionTypes 早期版本使用的四种基础情绪，旧数据和旧客户端只包含这四种
+var BasicEmotionTypes = []EmotionType{EmotionHappy, EmotionSad, EmotionAngry, EmotionNeutral}
+
+// basicEmotions 扩展情绪到基础情绪的对应关系
+var basicEmotions = map[EmotionType]EmotionType{
+	EmotionExcitement:  EmotionHappy,
+	EmotionBoredom:     EmotionNeutral,
+	EmotionLoneliness:  EmotionSad,
+	EmotionFear:        EmotionSad,
+	EmotionAnxiety:     EmotionSad,
+	EmotionFrustration: EmotionAngry,
+}
+
+// emotionLabels 情绪的中文名称，用于报告文案
+var emotionLabels = map[EmotionType]string{
+	EmotionHappy:       "开心",
+	EmotionSad:         "难过",
+	EmotionAngry:       "生气",
+	EmotionNeutral:     "平静",
+	EmotionFear:        "害怕",
+	EmotionAnxiety:     "担心",
+	EmotionLoneliness:  "孤单",
+	EmotionExcitement:  "兴奋",
+	EmotionBoredom:     "无聊",
+	EmotionFrustration: "沮丧",
+}
+
+// Label 情绪的中文名称
+func (e EmotionType) Label() string {
+	if label, ok := emotionLabels[e]; ok {
+		return label
+	}
+	return string(e)
+}
+
+// Basic 返回情绪对应的基础情绪，用于兼容只认识四种情绪的报告和客户端
+func (e EmotionType) Basic() EmotionType {
+	if basic, ok := basicEmotions[e]; ok {
+		return basic
+	}
+	return e
+}
+
+// IsNegative 是否为需要家长关注的负面情绪
+func (e EmotionType) IsNegative() bool {
+	basic := e.Basic()
+	return basic == EmotionSad || basic == EmotionAngry
+}
+
+// EmotionStats 各情绪出现的次数
+type EmotionStats map[EmotionType]int
+
+// Basic 将统计合并为四种基础情绪
+func (s EmotionStats) Basic() EmotionStats {
+	basic := make(EmotionStats, len(BasicEmotionTypes))
+	for emotion, count := range s {
+		basic[emotion.Basic()] += count
+	}
+	return basic
+}
+
+// EmotionData 一次情绪分析的结果
+type EmotionData struct {
+	Emotion    EmotionType             `json:"emotion" bson:"emotion"`                         // 概率最高的情绪
+	Confidence float64                 `json:"confidence" bson:"confidence"`                   // 该情绪的概率
+	Intensity  float64                 `json:"intensity" bson:"intensity"`                     // 情绪强度，0到1
+	Secondary  EmotionType             `json:"secondary,omitempty" bson:"secondary,omitempty"` // 同时表现出的次要情绪
+	Scores     map[EmotionType]float64 `json:"scores" bson:"scores"`                           // 各情绪的概率分布，总和为1
+}
+
 // EmotionRecord 情绪记录
 type EmotionRecord struct {
 	ID        uint64      `json:"id" gorm:"primaryKey"`
-	UserID    uint64      `json:"user_id" gorm:"index"`              // 用户ID
+	UserID    uint64      `json:"user_id" gorm:"index;uniqueIndex:idx_emotion_user_message"` // 用户ID
 	ChatID    uint64      `json:"chat_id" gorm:"index"`              // 聊天记录ID
+	SessionID string      `json:"session_id" gorm:"size:64"`         // 语音会话ID
+	MessageID *string     `json:"message_id,omitempty" gorm:"size:128;uniqueIndex:idx_emotion_user_message"` // 语音消息ID，同一条消息只记录一次；文字聊天的记录为空
 	Emotion   EmotionType `json:"emotion"`                            // 情绪类型
 	Confidence float64     `json:"confidence"`                         // 情绪判断的置信度
+	Intensity float64     `json:"intensity"`                          // 情绪强度，0到1，旧数据为0
+	SecondaryEmotion EmotionType `json:"secondary_emotion,omitempty"` // 次要情绪，没有时为空
+	Scores    map[EmotionType]float64 `json:"scores" gorm:"serializer:json"` // 各情绪的概率分布，语音消息为文本和声学融合后的结果
+	TextScores     map[EmotionType]float64 `json:"text_scores,omitempty" gorm:"serializer:json"`     // 文本情绪分布
+	AcousticScores map[EmotionType]float64 `json:"acoustic_scores,omitempty" gorm:"serializer:json"` // 声学情绪分布，没有可用语音时为空
+	Prosody        *ProsodyFeatures        `json:"prosody,omitempty" gorm:"serializer:json"`         // 语音的韵律特征
 	CreatedAt time.Time   `json:"created_at" gorm:"index"`           // 创建时间
 }
 
+// EmotionShift 情绪变化，报告时间线中的一个节点
+type EmotionShift struct {
+	Time time.Time   `json:"time"`
+	From EmotionType `json:"from"`
+	To   EmotionType `json:"to"`
+}
+
+// EmotionQuote 孩子说过的有代表性的话
+type EmotionQuote struct {
+	Time      time.Time   `json:"time"`
+	Text      string      `json:"text"`
+	Emotion   EmotionType `json:"emotion"`
+	Intensity float64     `json:"intensity"`
+}
+
+// ReportPeriod 报告周期
+type ReportPeriod string
+
+const (
+	ReportDaily   ReportPeriod = "day"   // 日报
+	ReportWeekly  ReportPeriod = "week"  // 周报，周一到周日
+	ReportMonthly ReportPeriod = "month" // 月报
+)
+
+// reportPeriodLabels 报告周期和上一周期在文案中的说法
+var reportPeriodLabels = map[ReportPeriod][2]string{
+	ReportDaily:   {"今天", "昨天"},
+	ReportWeekly:  {"这周", "上周"},
+	ReportMonthly: {"这个月", "上个月"},
+}
+
+// Valid 是否为支持的报告周期
+func (p ReportPeriod) Valid() bool {
+	_, ok := reportPeriodLabels[p]
+	return ok
+}
+
+// Label 报告周期在文案中的说法
+func (p ReportPeriod) Label() string {
+	if labels, ok := reportPeriodLabels[p]; ok {
+		return labels[0]
+	}
+	return reportPeriodLabels[ReportDaily][0]
+}
+
+// PreviousLabel 上一周期在文案中的说法
+func (p ReportPeriod) PreviousLabel() string {
+	if labels, ok := reportPeriodLabels[p]; ok {
+		return labels[1]
+	}
+	return reportPeriodLabels[ReportDaily][1]
+}
+
+// EmotionChange 某种情绪的占比相对上一周期的变化
+type EmotionChange struct {
+	Emotion  EmotionType `json:"emotion"`
+	Share    float64     `json:"share"`    // 本周期占比
+	Previous float64     `json:"previous"` // 上一周期占比
+	Change   float64     `json:"change"`   // 占比变化，正数表示增加
+}
+
+// PeriodComparison 与上一周期的对比
+type PeriodComparison struct {
+	PreviousChatCount     int             `json:"previous_chat_count"`     // 上一周期聊天次数
+	PreviousStats         EmotionStats    `json:"previous_stats"`          // 上一周期情绪统计
+	NegativeShare         float64         `json:"negative_share"`          // 本周期负面情绪占比
+	PreviousNegativeShare float64         `json:"previous_negative_share"` // 上一周期负面情绪占比
+	Changes               []EmotionChange `json:"changes"`                 // 各情绪占比变化，按变化幅度从大到小排列
+}
+
+// NegativeDay 负面情绪明显多于周期内平常水平的一天
+type NegativeDay struct {
+	Date          time.Time   `json:"date"`
+	ChatCount     int         `json:"chat_count"`
+	NegativeShare float64     `json:"negative_share"` // 当天负面情绪占比
+	TopEmotion    EmotionType `json:"top_emotion"`    // 当天最多的负面情绪
+}
+
 // EmotionReport 情绪报告
 type EmotionReport struct {
 	ID           uint64    `json:"id" gorm:"primaryKey"`
-	UserID       uint64    `json:"user_id" gorm:"index"`                     // 用户ID
-	Date         time.Time `json:"date" gorm:"index;type:date"`              // 报告日期
-	ChatCount    int       `json:"chat_count"`                                // 当天聊天次数
-	EmotionStats map[EmotionType]int `json:"emotion_stats" gorm:"type:json"` // 情绪统计
+	UserID       uint64    `json:"user_id" gorm:"uniqueIndex:idx_report_user_period_date"` // 用户ID
+	Period       ReportPeriod `json:"period" gorm:"size:8;default:day;uniqueIndex:idx_report_user_period_date"` // 报告周期
+	Date         time.Time `json:"date" gorm:"type:date;uniqueIndex:idx_report_user_period_date"` // 报告日期，周报和月报为周期的第一天；同一用户同一周期同一天只有一份报告
+	EndDate      time.Time `json:"end_date" gorm:"type:date"`                // 报告覆盖的最后一天
+	ChatCount    int       `json:"chat_count"`                                // 周期内聊天次数
+	EmotionStats EmotionStats `json:"emotion_stats" gorm:"serializer:json"` // 情绪统计，旧报告只包含四种基础情绪
+	BasicEmotionStats EmotionStats `json:"basic_emotion_stats" gorm:"-"` // 合并为四种基础情绪的统计，兼容旧客户端
 	Summary      string    `json:"summary"`                                   // 情绪总结
+	Timeline     []EmotionShift `json:"timeline" gorm:"serializer:json"`     // 情绪变化时间线
+	Topics       []string       `json:"topics" gorm:"serializer:json"`       // 聊得最多的话题
+	Quotes       []EmotionQuote `json:"quotes" gorm:"serializer:json"`       // 孩子说过的有代表性的话
+	TalkingPoints []string      `json:"talking_points" gorm:"serializer:json"` // 给家长的沟通建议
+	Comparison   *PeriodComparison `json:"comparison,omitempty" gorm:"serializer:json"` // 与上一周期的对比，仅周报和月报
+	NegativeDays []NegativeDay     `json:"negative_days,omitempty" gorm:"serializer:json"` // 负面情绪异常偏多的日子，仅周报和月报
 	CreatedAt    time.Time `json:"created_at"`                                // 创建时间
-	PushedAt     time.Time `json:"pushed_at"`                                 // 推送时间
-}
\ No newline at end of file
+	UpdatedAt    time.Time `json:"updated_at"`                                // 最近一次重新生成的时间
+	PushedAt     *time.Time `json:"pushed_at"`                                // 推送时间，未推送时为空
+}
+
+// AfterFind 读取报告后补充基础情绪统计
+func (r *EmotionReport) AfterFind(tx *gorm.DB) error {
+	r.BasicEmotionStats = r.EmotionStats.Basic()
+	return nil
+}
+
+// TrendGranularity 情绪趋势的统计粒度
+type TrendGranularity string
+
+const (
+	TrendHourly TrendGranularity = "hour"
+	TrendDaily  TrendGranularity = "day"
+	TrendWeekly TrendGranularity = "week" // 周一到周日
+)
+
+// EmotionTrendBucket 情绪趋势中一个时间段的统计
+type EmotionTrendBucket struct {
+	Start             time.Time               `json:"start"`
+	End               time.Time               `json:"end"`
+	Count             int                     `json:"count"`              // 情绪记录数
+	Stats             EmotionStats            `json:"stats"`              // 各情绪出现的次数
+	BasicStats        EmotionStats            `json:"basic_stats"`        // 合并为四种基础情绪的统计，兼容旧客户端
+	Distribution      map[EmotionType]float64 `json:"distribution"`       // 按置信度加权的情绪分布，总和为1
+	BasicDistribution map[EmotionType]float64 `json:"basic_distribution"` // 合并为四种基础情绪的分布
+	Dominant          EmotionType             `json:"dominant,omitempty"` // 分布中占比最高的情绪，没有记录时为空
+	Confidence        float64                 `json:"confidence"`         // 平均置信度
+	Intensity         float64                 `json:"intensity"`          // 按置信度加权的平均情绪强度
+	NegativeShare     float64                 `json:"negative_share"`     // 按置信度加权的负面情绪占比
+}
+
+// EmotionTrend 情绪趋势
+type EmotionTrend struct {
+	Start       time.Time            `json:"start"`
+	End         time.Time            `json:"end"`
+	Granularity TrendGranularity     `json:"granularity"`
+	Timezone    string               `json:"timezone"`
+	Buckets     []EmotionTrendBucket `json:"buckets"` // 按时间顺序排列，没有记录的时间段也会返回
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"time"
+)
+
+// FamilyRole 家庭成员的角色
+type FamilyRole string
+
+const (
+	FamilyRoleParent FamilyRole = "parent" // 家长
+	FamilyRoleChild  FamilyRole = "child"  // 孩子
+)
+
+// GuardianPermission 家长成员（监护人）的权限级别
+type GuardianPermission string
+
+const (
+	GuardianViewReports GuardianPermission = "view_reports" // 只能查看孩子的报告、聊天记录和提醒
+	GuardianManage      GuardianPermission = "manage"       // 完全管理：孩子档案、设备、家庭和通知设置以及邀请监护人
+)
+
+// Valid 权限级别是否有效
+func (p GuardianPermission) Valid() bool {
+	return p == GuardianViewReports || p == GuardianManage
+}
+
+// 家庭未设置时使用的默认值
+const (
+	DefaultFamilyTimezone   = "Asia/Shanghai"
+	DefaultFamilyReportTime = "19:00"
+	DefaultFamilyPushTime   = "20:00"
+)
+
+// Family 家庭，情绪报告按家庭的时区在家庭设置的时间生成和推送
+type Family struct {
+	ID         uint64    `json:"id" gorm:"primaryKey"`
+	Name       string    `json:"name" gorm:"size:64"`                           // 家庭名称
+	Timezone   string    `json:"timezone" gorm:"size:64;default:Asia/Shanghai"` // IANA时区，如Asia/Shanghai
+	ReportTime string    `json:"report_time" gorm:"size:5;default:19:00"`       // 每天生成情绪报告的时间，HH:MM
+	PushTime   string    `json:"push_time" gorm:"size:5;default:20:00"`         // 每天推送情绪报告的时间，HH:MM
+	QuietStart string    `json:"quiet_start" gorm:"size:5"`                     // 免打扰开始时间，HH:MM，为空表示不设置
+	QuietEnd   string    `json:"quiet_end" gorm:"size:5"`                       // 免打扰结束时间，HH:MM，可以跨过午夜
+	CreatedAt  time.Time `json:"created_at"`                                    // 创建时间
+	UpdatedAt  time.Time `json:"updated_at"`                                    // 更新时间
+}
+
+// DefaultFamily 还没有加入家庭的用户使用的默认设置
+func DefaultFamily() *Family {
+	return &Family{
+		Timezone:   DefaultFamilyTimezone,
+		ReportTime: DefaultFamilyReportTime,
+		PushTime:   DefaultFamilyPushTime,
+	}
+}
+
+// Location 家庭所在时区，时区无效时使用默认时区
+func (f *Family) Location() *time.Location {
+	if loc, err := time.LoadLocation(f.Timezone); err == nil {
+		return loc
+	}
+	if loc, err := time.LoadLocation(DefaultFamilyTimezone); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+// FamilyMember 家庭成员，家庭中的家长都是孩子的监护人，都能收到报告推送和提醒
+type FamilyMember struct {
+	ID         uint64             `json:"id" gorm:"primaryKey"`
+	FamilyID   uint64             `json:"family_id" gorm:"uniqueIndex:idx_family_user"`     // 家庭ID
+	UserID     uint64             `json:"user_id" gorm:"uniqueIndex:idx_family_user;index"` // 用户ID
+	Role       FamilyRole         `json:"role" gorm:"size:16"`                              // 成员角色
+	Permission GuardianPermission `json:"permission" gorm:"size:16;default:manage"`         // 家长成员的权限级别，孩子成员忽略
+	CreatedAt  time.Time          `json:"created_at"`                                       // 加入时间
+}
+
+// FamilyInvitation 邀请其他家长作为监护人加入家庭，通过邮件或链接发送，只保存邀请令牌的哈希
+type FamilyInvitation struct {
+	ID         uint64             `json:"id" gorm:"primaryKey"`
+	FamilyID   uint64             `json:"family_id" gorm:"index"`       // 家庭ID
+	InviterID  uint64             `json:"inviter_id"`                   // 发出邀请的家长
+	Email      string             `json:"email" gorm:"size:128"`        // 被邀请人的邮箱，为空表示只生成链接；不为空时只有该邮箱的账号可以接受
+	Permission GuardianPermission `json:"permission" gorm:"size:16"`    // 接受后获得的权限级别
+	TokenHash  string             `json:"-" gorm:"size:64;uniqueIndex"` // 邀请令牌的SHA-256
+	ExpiresAt  time.Time          `json:"expires_at"`                   // 过期时间
+	AcceptedBy uint64             `json:"accepted_by,omitempty"`        // 接受邀请的用户
+	AcceptedAt *time.Time         `json:"accepted_at"`                  // 接受时间
+	RevokedAt  *time.Time         `json:"revoked_at"`                   // 撤销时间
+	CreatedAt  time.Time          `json:"created_at"`                   // 邀请时间
+}
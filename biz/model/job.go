@@ -0,0 +1,84 @@
+package model
+
+import (
+	"time"
+)
+
+// JobStatus 定时任务的执行状态
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"   // 等待执行
+	JobRunning   JobStatus = "running"   // 执行中
+	JobSucceeded JobStatus = "succeeded" // 执行成功
+	JobFailed    JobStatus = "failed"    // 执行失败，会在下次检查时从失败的用户继续
+)
+
+// JobRun 定时任务的一次执行，同一任务在同一范围和日期只执行一次，中断后从未完成的用户继续
+type JobRun struct {
+	ID          uint64     `json:"id" gorm:"primaryKey"`
+	Job         string     `json:"job" gorm:"size:64;uniqueIndex:idx_job_run"`      // 任务名，如generate_reports
+	Scope       string     `json:"scope" gorm:"size:64;uniqueIndex:idx_job_run"`    // 任务范围，如family:12
+	RunDate     string     `json:"run_date" gorm:"size:10;uniqueIndex:idx_job_run"` // 任务所属的当地日期，YYYY-MM-DD
+	Timezone    string     `json:"timezone" gorm:"size:64"`                         // 任务范围所在时区
+	Status      JobStatus  `json:"status" gorm:"size:16;index"`                     // 执行状态
+	Attempts    int        `json:"attempts"`                                        // 已执行次数
+	Total       int        `json:"total"`                                           // 用户总数
+	Succeeded   int        `json:"succeeded"`                                       // 已成功的用户数
+	Failed      int        `json:"failed"`                                          // 失败的用户数
+	Error       string     `json:"error" gorm:"type:text"`                          // 最近一次失败的原因
+	StartedAt   time.Time  `json:"started_at"`                                      // 开始时间
+	HeartbeatAt time.Time  `json:"heartbeat_at" gorm:"index"`                       // 最近一次处理进度的时间，长时间未更新说明执行中断
+	FinishedAt  *time.Time `json:"finished_at"`                                     // 结束时间
+}
+
+// JobRunItem 任务中单个用户的执行进度
+type JobRunItem struct {
+	ID        uint64    `json:"id" gorm:"primaryKey"`
+	JobRunID  uint64    `json:"job_run_id" gorm:"uniqueIndex:idx_job_run_user"` // 任务执行ID
+	UserID    uint64    `json:"user_id" gorm:"uniqueIndex:idx_job_run_user"`    // 用户ID
+	Status    JobStatus `json:"status" gorm:"size:16"`                          // 执行状态
+	Attempts  int       `json:"attempts"`                                       // 已执行次数
+	Error     string    `json:"error" gorm:"type:text"`                         // 失败原因
+	UpdatedAt time.Time `json:"updated_at"`                                     // 更新时间
+}
+
+// OutboxStatus 待发送消息的状态
+type OutboxStatus string
+
+const (
+	OutboxPending OutboxStatus = "pending" // 等待发送
+	OutboxSent    OutboxStatus = "sent"    // 已发送
+	OutboxFailed  OutboxStatus = "failed"  // 多次发送失败后不再重试，需要人工处理
+)
+
+// OutboxMessage 待发送到消息队列的消息，与业务数据在同一事务中写入，由后台任务发送，保证数据更新和消息发送一致
+type OutboxMessage struct {
+	ID        uint64       `json:"id" gorm:"primaryKey"`
+	Topic     string       `json:"topic" gorm:"size:64"`        // 消息主题
+	Key       string       `json:"key" gorm:"size:128"`         // 消息Key
+	Body      []byte       `json:"body"`                        // 消息内容
+	Status    OutboxStatus `json:"status" gorm:"size:16;index"` // 发送状态
+	Attempts  int          `json:"attempts"`                    // 已尝试发送次数
+	LastError string       `json:"last_error" gorm:"type:text"` // 最近一次发送失败的原因
+	RetryAt   *time.Time   `json:"retry_at"`                    // 发送失败后下次重试的时间
+	CreatedAt time.Time    `json:"created_at"`                  // 创建时间
+	SentAt    *time.Time   `json:"sent_at"`                     // 发送时间
+}
+
+// LeaderLease 选主租约，持有未过期租约的实例负责执行定时任务
+type LeaderLease struct {
+	Name      string    `json:"name" gorm:"primaryKey;size:64"` // 租约名，如emotion_scheduler
+	Holder    string    `json:"holder" gorm:"size:128"`         // 当前持有者
+	Epoch     uint64    `json:"epoch" gorm:"default:1"`         // 任期，每次由其他实例接手时加一，作为领导者写入时的防护令牌
+	ExpiresAt time.Time `json:"expires_at"`                     // 过期时间，持有者需在过期前续约
+	UpdatedAt time.Time `json:"updated_at"`                     // 最近一次获取或续约的时间
+}
+
+// JobControl 定时任务的运行控制，暂停的任务不会开始新的执行，也不会继续中断的执行
+type JobControl struct {
+	Job       string    `json:"job" gorm:"primaryKey;size:64"` // 任务名
+	Paused    bool      `json:"paused"`                        // 是否暂停
+	UpdatedBy uint64    `json:"updated_by"`                    // 最近一次操作的管理员
+	UpdatedAt time.Time `json:"updated_at"`                    // 最近一次操作的时间
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"time"
+)
+
+// NotificationChannel 通知家长的渠道
+type NotificationChannel string
+
+const (
+	ChannelWebSocket NotificationChannel = "websocket" // 家长端的WebSocket连接
+	ChannelEmail     NotificationChannel = "email"     // 邮件
+	ChannelWebhook   NotificationChannel = "webhook"   // 家长配置的Webhook地址
+)
+
+// DeliveryStatus 通知在一个渠道上的送达状态
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending" // 等待发送或等待重试
+	DeliverySent    DeliveryStatus = "sent"    // 已送达
+	DeliveryFailed  DeliveryStatus = "failed"  // 重试次数用完或渠道未配置，不再重试
+)
+
+// NotificationSettings 家庭的通知渠道设置，未设置时只通过WebSocket通知
+type NotificationSettings struct {
+	FamilyID      uint64                `json:"family_id" gorm:"primaryKey;autoIncrement:false"` // 家庭ID
+	Channels      []NotificationChannel `json:"channels" gorm:"serializer:json"`                 // 同时使用的渠道
+	Fallbacks     []NotificationChannel `json:"fallbacks" gorm:"serializer:json"`                // 所有渠道都失败后依次尝试的备用渠道
+	Email         string                `json:"email" gorm:"size:128"`                           // 接收通知的邮箱
+	WebhookURL    string                `json:"webhook_url" gorm:"size:512"`                     // 接收通知的Webhook地址
+	WebhookSecret string                `json:"-" gorm:"size:128"`                               // Webhook签名密钥，为空时不签名
+	UpdatedAt     time.Time             `json:"updated_at"`                                      // 更新时间
+}
+
+// DefaultNotificationSettings 家庭未设置通知渠道时使用的默认设置
+func DefaultNotificationSettings(familyID uint64) *NotificationSettings {
+	return &NotificationSettings{
+		FamilyID: familyID,
+		Channels: []NotificationChannel{ChannelWebSocket},
+	}
+}
+
+// Notification 发给家长的一条通知，每个渠道的送达情况记录在Deliveries中
+type Notification struct {
+	ID         uint64                 `json:"id" gorm:"primaryKey"`
+	Key        string                 `json:"-" gorm:"size:128;uniqueIndex"`               // 去重键，同一条消息重复投递时只通知一次
+	UserID     uint64                 `json:"user_id" gorm:"index"`                        // 孩子的用户ID
+	FamilyID   uint64                 `json:"family_id" gorm:"index"`                      // 家庭ID，还没有加入家庭时为0
+	Kind       string                 `json:"kind" gorm:"size:32"`                         // 通知类型，如emotion_report
+	Title      string                 `json:"title" gorm:"size:128"`                       // 标题
+	Content    string                 `json:"content" gorm:"type:text"`                    // 正文
+	Payload    []byte                 `json:"-"`                                           // 原始消息内容，重试时重新发送
+	Fallbacks  []NotificationChannel  `json:"fallbacks" gorm:"serializer:json"`            // 创建通知时家庭设置的备用渠道
+	CreatedAt  time.Time              `json:"created_at" gorm:"index"`                     // 创建时间
+	Deliveries []NotificationDelivery `json:"deliveries" gorm:"foreignKey:NotificationID"` // 各渠道的送达记录
+}
+
+// NotificationDelivery 通知在一个渠道上的送达记录
+type NotificationDelivery struct {
+	ID             uint64              `json:"id" gorm:"primaryKey"`
+	NotificationID uint64              `json:"notification_id" gorm:"uniqueIndex:idx_notification_channel"` // 通知ID
+	Channel        NotificationChannel `json:"channel" gorm:"size:16;uniqueIndex:idx_notification_channel"` // 渠道
+	Fallback       bool                `json:"fallback"`                                                    // 是否为备用渠道
+	Status         DeliveryStatus      `json:"status" gorm:"size:16;index:idx_delivery_due"`                // 送达状态
+	Attempts       int                 `json:"attempts"`                                                    // 已发送次数
+	LastError      string              `json:"last_error" gorm:"type:text"`                                 // 最近一次失败的原因
+	NextAttemptAt  time.Time           `json:"next_attempt_at" gorm:"index:idx_delivery_due"`               // 下一次发送的时间
+	DeliveredAt    *time.Time          `json:"delivered_at"`                                                // 送达时间
+	CreatedAt      time.Time           `json:"created_at"`                                                  // 创建时间
+	UpdatedAt      time.Time           `json:"updated_at"`                                                  // 更新时间
+}
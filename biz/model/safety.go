@@ -0,0 +1,103 @@
+package model
+
+import (
+	"time"
+)
+
+// SafetyStage 安全检查所在的环节
+type SafetyStage string
+
+const (
+	SafetyStageInput  SafetyStage = "input"  // 孩子说的话(ASR识别结果)
+	SafetyStageOutput SafetyStage = "output" // 角色的回复(LLM生成结果)
+)
+
+// SafetyCategory 安全问题类别
+type SafetyCategory string
+
+const (
+	SafetyDistress     SafetyCategory = "distress"      // 孩子表达害怕、求助、被伤害
+	SafetySelfHarm     SafetyCategory = "self_harm"     // 自伤相关
+	SafetyPersonalInfo SafetyCategory = "personal_info" // 住址、电话等个人信息
+	SafetyViolence     SafetyCategory = "violence"      // 暴力、恐怖内容
+	SafetyAdult        SafetyCategory = "adult"         // 色情、成人话题
+	SafetyProfanity    SafetyCategory = "profanity"     // 脏话、辱骂
+	SafetyDangerous    SafetyCategory = "dangerous"     // 诱导危险行为
+	SafetyUnverified   SafetyCategory = "unverified"    // 分类器出错，无法确认内容是否安全
+)
+
+// SafetyAction 对不安全内容采取的处理
+type SafetyAction string
+
+const (
+	SafetyActionFlagged     SafetyAction = "flagged"     // 仅标记，内容照常处理
+	SafetyActionRedacted    SafetyAction = "redacted"    // 隐去敏感片段后继续处理
+	SafetyActionRegenerated SafetyAction = "regenerated" // 丢弃回复并重新生成
+	SafetyActionReplaced    SafetyAction = "replaced"    // 替换为安全的兜底回复
+)
+
+// AlertSeverity 家长提醒的严重程度
+type AlertSeverity string
+
+const (
+	AlertSeverityLow    AlertSeverity = "low"    // 孤单、情绪低落等，家长有空时关注
+	AlertSeverityMedium AlertSeverity = "medium" // 害怕、被欺负等，需要家长尽快了解
+	AlertSeverityHigh   AlertSeverity = "high"   // 受到伤害、自伤等，需要家长立即处理
+)
+
+// Level 严重程度的数值，用于比较
+func (s AlertSeverity) Level() int {
+	switch s {
+	case AlertSeverityLow:
+		return 1
+	case AlertSeverityMedium:
+		return 2
+	case AlertSeverityHigh:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// SafetyVerdict 安全检查结果
+type SafetyVerdict struct {
+	Safe     bool           `json:"safe"`
+	Category SafetyCategory `json:"category,omitempty"`
+	Severity AlertSeverity  `json:"severity,omitempty"` // 需要提醒家长时的严重程度
+	Reason   string         `json:"reason,omitempty"`   // 命中的规则或分类器给出的原因
+	Matched  []string       `json:"matched,omitempty"`  // 命中的文本片段
+}
+
+// SafetyIntervention 安全干预记录，供家长查看
+type SafetyIntervention struct {
+	ID          uint64         `json:"id" gorm:"primaryKey"`
+	UserID      uint64         `json:"user_id" gorm:"index"`            // 孩子的用户ID
+	SessionID   string         `json:"session_id" gorm:"index;size:64"` // 会话ID
+	MessageID   string         `json:"message_id" gorm:"size:128"`      // 语音消息ID
+	Stage       SafetyStage    `json:"stage" gorm:"size:16"`            // 检查环节
+	Category    SafetyCategory `json:"category" gorm:"index;size:32"`   // 问题类别
+	Action      SafetyAction   `json:"action" gorm:"size:16"`           // 处理方式
+	Content     string         `json:"content" gorm:"type:text"`        // 原始内容
+	Replacement string         `json:"replacement" gorm:"type:text"`    // 处理后的内容
+	Reason      string         `json:"reason"`                          // 干预原因
+	Reviewed    bool           `json:"reviewed" gorm:"index"`           // 家长是否已查看
+	CreatedAt   time.Time      `json:"created_at" gorm:"index"`         // 创建时间
+}
+
+// SafetyAlert 孩子在对话中表达求助、受伤害等情况时发给家长的实时提醒，
+// 去重窗口内同一孩子同类提醒合并为一条
+type SafetyAlert struct {
+	ID           uint64         `json:"id" gorm:"primaryKey"`
+	UserID       uint64         `json:"user_id" gorm:"index:idx_alert_user_category"`          // 孩子的用户ID
+	SessionID    string         `json:"session_id" gorm:"size:64"`                             // 会话ID
+	MessageID    string         `json:"message_id" gorm:"size:128"`                            // 语音消息ID
+	Category     SafetyCategory `json:"category" gorm:"index:idx_alert_user_category;size:32"` // 问题类别
+	Severity     AlertSeverity  `json:"severity" gorm:"size:16"`                               // 严重程度
+	Text         string         `json:"text" gorm:"type:text"`                                 // 孩子说的话
+	Reason       string         `json:"reason"`                                                // 触发原因
+	Occurrences  int            `json:"occurrences"`                                           // 去重窗口内出现的次数
+	Acknowledged bool           `json:"acknowledged" gorm:"index"`                             // 家长是否已确认
+	CreatedAt    time.Time      `json:"created_at" gorm:"index:idx_alert_user_category"`       // 首次出现时间
+	LastSeenAt   time.Time      `json:"last_seen_at"`                                          // 最近一次出现时间
+	PushedAt     *time.Time     `json:"pushed_at"`                                             // 推送给家长的时间，家长不在线时为空
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserRole 账号角色
+type UserRole string
+
+const (
+	UserRoleParent UserRole = "parent" // 家长
+	UserRoleChild  UserRole = "child"  // 孩子
+	UserRoleAdmin  UserRole = "admin"  // 运维管理员
+)
+
+// Permission 接口权限，由账号角色决定
+type Permission string
+
+const (
+	PermissionViewChildren   Permission = "view_children"   // 查看自己监护的孩子的聊天记录、情绪报告和安全提醒
+	PermissionManageChildren Permission = "manage_children" // 管理孩子档案、设备配对、家庭和通知设置
+	PermissionViewCharacters Permission = "view_characters" // 查看系统角色
+	PermissionOperate        Permission = "operate"         // 运维操作：管理系统角色、补生成和重推报告、控制定时任务
+)
+
+// rolePermissions 各角色拥有的权限；孩子没有接口权限，只能建立WebSocket连接进行语音聊天
+var rolePermissions = map[UserRole][]Permission{
+	UserRoleParent: {PermissionViewChildren, PermissionManageChildren, PermissionViewCharacters},
+	UserRoleAdmin:  {PermissionViewCharacters, PermissionOperate},
+}
+
+// Can 角色是否拥有权限
+func (r UserRole) Can(permission Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// User 账号，家长用用户名和密码登录；孩子的账号由家长创建，没有用户名和密码
+type User struct {
+	ID        uint64         `json:"id" gorm:"primaryKey"`
+	Username  *string        `json:"username,omitempty" gorm:"size:64;uniqueIndex"` // 登录用户名，孩子为空
+	Password  string         `json:"-" gorm:"size:255"`                             // 登录密码
+	Email     string         `json:"email,omitempty" gorm:"size:128;index"`         // 邮箱
+	Role      UserRole       `json:"role" gorm:"size:16;index"`                     // 账号角色
+	ParentID  uint64         `json:"parent_id,omitempty" gorm:"index"`              // 创建孩子账号的家长ID，孩子的所有监护人见家庭成员
+	Profile   *ChildProfile  `json:"profile,omitempty" gorm:"foreignKey:UserID"`    // 孩子档案
+	CreatedAt time.Time      `json:"created_at"`                                    // 创建时间
+	UpdatedAt time.Time      `json:"updated_at"`                                    // 更新时间
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`                                // 删除时间，删除的孩子不再生成报告
+}
+
+// ChildProfile 孩子档案
+type ChildProfile struct {
+	UserID      uint64     `json:"user_id" gorm:"primaryKey;autoIncrement:false"` // 孩子的用户ID
+	Name        string     `json:"name" gorm:"size:32"`                           // 名字或昵称
+	Birthday    *time.Time `json:"birthday" gorm:"type:date"`                     // 生日，未填写时为空
+	Avatar      string     `json:"avatar" gorm:"size:512"`                        // 头像地址
+	CharacterID uint64     `json:"character_id"`                                  // 偏好的系统角色，语音聊天未选择角色时使用，0表示使用默认角色
+	UpdatedAt   time.Time  `json:"updated_at"`                                    // 更新时间
+}
@@ -9,11 +9,25 @@ type VoiceMessage struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
 	SessionID string    `json:"session_id"`
+	CharacterID uint64  `json:"character_id"` // 会话选择的系统角色
+	SampleRate int      `json:"sample_rate"`  // 16bit PCM音频的采样率
+	Segmented bool      `json:"segmented"`    // 已由WebSocket服务端的VAD切分为一句话，VAD阶段不再重复检测
 	Data      []byte    `json:"data"`
 	CreatedAt time.Time `json:"created_at"`
 	RetryCount int      `json:"retry_count"`
 }
 
+// VADConfig VAD参数
+type VADConfig struct {
+	SampleRate      int           `json:"sample_rate"`      // 采样率，默认16000
+	FrameDuration   time.Duration `json:"frame_duration"`   // 分析帧长，默认20ms
+	EnergyThreshold float64       `json:"energy_threshold"` // 语音帧的最低能量(dBFS)，实际阈值会随环境噪声上调
+	ZCRThreshold    float64       `json:"zcr_threshold"`    // 低能量语音帧允许的最大过零率
+	MinSpeech       time.Duration `json:"min_speech"`       // 最短语音长度，更短的片段视为噪声
+	Hangover        time.Duration `json:"hangover"`         // 语音段前后保留的拖尾
+	SilenceTimeout  time.Duration `json:"silence_timeout"`  // 静音超过该时长视为一句话结束
+}
+
 // VADResult VAD处理结果
 type VADResult struct {
 	VoiceMessage
@@ -23,22 +37,52 @@ type VADResult struct {
 	AudioSegment []byte        `json:"audio_segment"`
 }
 
+// ProsodyFeatures 一段语音的韵律特征
+type ProsodyFeatures struct {
+	Duration   time.Duration `json:"duration"`    // 语音时长
+	PitchMean  float64       `json:"pitch_mean"`  // 平均基频(Hz)，没有浊音时为0
+	PitchStd   float64       `json:"pitch_std"`   // 基频标准差(Hz)
+	PitchRange float64       `json:"pitch_range"` // 基频范围(Hz)
+	EnergyMean float64       `json:"energy_mean"` // 语音帧平均能量(dBFS)
+	EnergyStd  float64       `json:"energy_std"`  // 语音帧能量标准差(dB)
+	SpeechRate float64       `json:"speech_rate"` // 语速，每秒音节数
+	PauseCount int           `json:"pause_count"` // 句中停顿次数
+	PauseRatio float64       `json:"pause_ratio"` // 停顿时长占比
+}
+
 // ASRResult ASR识别结果
 type ASRResult struct {
 	VoiceMessage
-	Text string `json:"text"`
+	Text    string           `json:"text"`
+	Prosody *ProsodyFeatures `json:"prosody,omitempty"` // 语音段的韵律特征，用于情绪分析
 }
 
 // LLMResult LLM生成结果
 type LLMResult struct {
 	VoiceMessage
-	Response string `json:"response"`
+	Response string `json:"response"` // 回复中的一句话
+	VoiceID  string `json:"voice_id"` // 角色的TTS音色
+	Seq      int    `json:"seq"`      // 句子在本轮回复中的序号
+	Final    bool   `json:"final"`    // 是否为本轮回复的最后一句
 }
 
 // TTSResult TTS转换结果
 type TTSResult struct {
 	VoiceMessage
+	Text  string `json:"text"`
 	Audio []byte `json:"audio"`
+	Seq   int    `json:"seq"`
+	Final bool   `json:"final"`
+}
+
+// VoiceResponseChunk 推送给客户端的语音回复片段，客户端按Seq顺序播放，Final表示本轮回复结束
+type VoiceResponseChunk struct {
+	MessageID string `json:"message_id"` // 对应的语音消息ID
+	SessionID string `json:"session_id"`
+	Seq       int    `json:"seq"`
+	Final     bool   `json:"final"`
+	Text      string `json:"text"`
+	Audio     []byte `json:"audio"`
 }
 
 // ProcessingStatus 处理状态
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/websocket"
+)
+
+// ErrAlertNotFound 家长提醒不存在
+var ErrAlertNotFound = errors.New("提醒不存在")
+
+// AlertConfig 家长提醒配置
+type AlertConfig struct {
+	// DedupWindows 各严重程度的去重窗口，窗口内同一孩子同类提醒合并为一条，更高严重程度的提醒总会推送
+	DedupWindows map[model.AlertSeverity]time.Duration
+}
+
+// DefaultAlertConfig 默认家长提醒配置
+var DefaultAlertConfig = AlertConfig{
+	DedupWindows: map[model.AlertSeverity]time.Duration{
+		model.AlertSeverityLow:    30 * time.Minute,
+		model.AlertSeverityMedium: 10 * time.Minute,
+		model.AlertSeverityHigh:   2 * time.Minute,
+	},
+}
+
+// alertCategories 需要实时提醒家长的安全问题类别
+var alertCategories = map[model.SafetyCategory]model.AlertSeverity{
+	model.SafetyDistress: model.AlertSeverityMedium,
+	model.SafetySelfHarm: model.AlertSeverityHigh,
+}
+
+// NewSafetyAlert 根据孩子输入的检查结果生成家长提醒，不需要提醒时返回nil
+func NewSafetyAlert(msg *model.VoiceMessage, text string, verdict *model.SafetyVerdict) *model.SafetyAlert {
+	if verdict == nil || verdict.Safe {
+		return nil
+	}
+	severity, ok := alertCategories[verdict.Category]
+	if !ok {
+		return nil
+	}
+	if verdict.Severity.Level() > 0 {
+		severity = verdict.Severity
+	}
+
+	userID, err := strconv.ParseUint(msg.UserID, 10, 64)
+	if err != nil {
+		logs.Error("parse user id %q error: %v", msg.UserID, err)
+		return nil
+	}
+
+	now := time.Now()
+	return &model.SafetyAlert{
+		UserID:      userID,
+		SessionID:   msg.SessionID,
+		MessageID:   msg.ID,
+		Category:    verdict.Category,
+		Severity:    severity,
+		Text:        text,
+		Reason:      verdict.Reason,
+		Occurrences: 1,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+	}
+}
+
+// AlertService 家长实时提醒服务：去重、保存并推送到家长的WebSocket连接
+type AlertService struct {
+	db     *gorm.DB
+	config AlertConfig
+	wsPool *websocket.Pool
+}
+
+// NewAlertService 创建家长提醒服务
+func NewAlertService(db *gorm.DB, config *AlertConfig, wsPool *websocket.Pool) *AlertService {
+	if config == nil {
+		config = &DefaultAlertConfig
+	}
+	return &AlertService{
+		db:     db,
+		config: *config,
+		wsPool: wsPool,
+	}
+}
+
+// HandleAlert 处理一条提醒：去重窗口内已有同类提醒且严重程度不低于本次时只累加次数，否则保存并推送给家长
+func (s *AlertService) HandleAlert(ctx context.Context, alert *model.SafetyAlert) error {
+	window := s.config.DedupWindows[alert.Severity]
+	if window > 0 {
+		var last model.SafetyAlert
+		err := s.db.WithContext(ctx).
+			Where("user_id = ? AND category = ? AND created_at >= ?", alert.UserID, alert.Category, alert.CreatedAt.Add(-window)).
+			Order("created_at DESC").
+			First(&last).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("查询提醒失败: %v", err)
+		}
+		if err == nil && last.Severity.Level() >= alert.Severity.Level() {
+			// 消息重投时不重复计数
+			if last.MessageID == alert.MessageID {
+				return nil
+			}
+			return s.db.WithContext(ctx).Model(&last).Updates(map[string]interface{}{
+				"occurrences":  gorm.Expr("occurrences + 1"),
+				"last_seen_at": alert.LastSeenAt,
+			}).Error
+		}
+	}
+
+	if err := s.db.WithContext(ctx).Create(alert).Error; err != nil {
+		return fmt.Errorf("保存提醒失败: %v", err)
+	}
+
+	s.push(ctx, alert)
+	return nil
+}
+
+// push 推送提醒给孩子所有在线的监护人，并记录推送时间
+func (s *AlertService) push(ctx context.Context, alert *model.SafetyAlert) {
+	if len(s.wsPool.GetGuardianClients(alert.UserID)) == 0 {
+		return
+	}
+
+	msgData, err := json.Marshal(websocket.Message{
+		Type:    websocket.SafetyAlertMessage,
+		Payload: alert,
+	})
+	if err != nil {
+		logs.Error("序列化提醒失败: %v", err)
+		return
+	}
+	sent, err := s.wsPool.SendToGuardians(alert.UserID, msgData)
+	if err != nil {
+		logs.Error("推送提醒失败: %v", err)
+	}
+	if sent == 0 {
+		return
+	}
+
+	now := time.Now()
+	alert.PushedAt = &now
+	if err := s.db.WithContext(ctx).Model(alert).Update("pushed_at", now).Error; err != nil {
+		logs.Error("更新提醒推送时间失败: %v", err)
+	}
+}
+
+// ListAlerts 分页获取孩子的家长提醒，onlyUnacknowledged为true时只返回家长未确认的提醒
+func (s *AlertService) ListAlerts(ctx context.Context, userID uint64, onlyUnacknowledged bool, page, pageSize int) ([]model.SafetyAlert, int64, error) {
+	query := s.db.WithContext(ctx).Model(&model.SafetyAlert{}).Where("user_id = ?", userID)
+	if onlyUnacknowledged {
+		query = query.Where("acknowledged = ?", false)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("统计提醒失败: %v", err)
+	}
+
+	var alerts []model.SafetyAlert
+	if err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&alerts).Error; err != nil {
+		return nil, 0, fmt.Errorf("获取提醒失败: %v", err)
+	}
+	return alerts, total, nil
+}
+
+// AcknowledgeAlert 家长确认提醒
+func (s *AlertService) AcknowledgeAlert(ctx context.Context, userID, id uint64) error {
+	var alert model.SafetyAlert
+	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&alert).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAlertNotFound
+		}
+		return fmt.Errorf("获取提醒失败: %v", err)
+	}
+
+	if err := s.db.WithContext(ctx).Model(&alert).Update("acknowledged", true).Error; err != nil {
+		return fmt.Errorf("更新提醒失败: %v", err)
+	}
+	return nil
+}
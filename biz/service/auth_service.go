@@ -0,0 +1,352 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	defaultTokenIssuer     = "sweekar"
+	// minAuthSecretLength 签名密钥的最短长度
+	minAuthSecretLength = 32
+	// deviceTokenTTL 孩子设备令牌的有效期，家长可以随时解除配对
+	deviceTokenTTL = 365 * 24 * time.Hour
+)
+
+// TokenScopeVoice 孩子设备令牌的权限范围，只能用于语音聊天
+const TokenScopeVoice = "voice"
+
+var (
+	// ErrInvalidToken 令牌无效、已过期或已作废
+	ErrInvalidToken = errors.New("令牌无效或已过期")
+	// errRefreshTokenReused 刷新令牌已经换取过新令牌
+	errRefreshTokenReused = errors.New("刷新令牌已被使用")
+)
+
+// AuthConfig 令牌配置
+type AuthConfig struct {
+	Secret          string        // HS256签名密钥，至少32字节
+	Issuer          string        // 令牌签发方，默认为sweekar
+	AccessTokenTTL  time.Duration // 访问令牌有效期，默认15分钟
+	RefreshTokenTTL time.Duration // 刷新令牌有效期，默认30天，每次刷新重新计算
+}
+
+// TokenPair 登录或刷新后返回给客户端的令牌
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // 访问令牌的有效秒数
+}
+
+// AccessClaims 访问令牌携带的用户身份
+type AccessClaims struct {
+	UserID    uint64         `json:"uid"`             // 用户ID
+	Role      model.UserRole `json:"role"`            // 账号角色
+	FamilyID  uint64         `json:"fid,omitempty"`   // 签发时用户所在的家庭，没有家庭时为0
+	SessionID string         `json:"sid"`             // 所属会话
+	Scope     string         `json:"scope,omitempty"` // 权限范围，为空表示不限制
+	DeviceID  string         `json:"did,omitempty"`   // 绑定的设备标识，只有设备令牌有
+	jwt.RegisteredClaims
+}
+
+// AuthService 签发和校验令牌：访问令牌为短期有效的JWT，刷新令牌每次使用后轮换；
+// 令牌都属于一个登录会话，退出登录或修改密码时作废会话，其中的访问令牌随之失效
+type AuthService struct {
+	db       *gorm.DB
+	families *FamilyService
+	config   AuthConfig
+}
+
+// NewAuthService 创建令牌服务
+func NewAuthService(db *gorm.DB, families *FamilyService, config *AuthConfig) (*AuthService, error) {
+	if len(config.Secret) < minAuthSecretLength {
+		return nil, fmt.Errorf("令牌签名密钥至少需要%d字节", minAuthSecretLength)
+	}
+	s := &AuthService{
+		db:       db,
+		families: families,
+		config:   *config,
+	}
+	if s.config.Issuer == "" {
+		s.config.Issuer = defaultTokenIssuer
+	}
+	if s.config.AccessTokenTTL <= 0 {
+		s.config.AccessTokenTTL = defaultAccessTokenTTL
+	}
+	if s.config.RefreshTokenTTL <= 0 {
+		s.config.RefreshTokenTTL = defaultRefreshTokenTTL
+	}
+	return s, nil
+}
+
+// Login 为已通过密码校验的用户创建会话并签发令牌
+func (s *AuthService) Login(ctx context.Context, user *model.User) (*TokenPair, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.config.RefreshTokenTTL)
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&model.AuthSession{
+			ID:              sessionID,
+			UserID:          user.ID,
+			ExpiresAt:       expiresAt,
+			LastRefreshedAt: now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.RefreshToken{
+			SessionID: sessionID,
+			TokenHash: hashToken(refreshToken),
+			ExpiresAt: expiresAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建登录会话失败: %v", err)
+	}
+
+	return s.tokenPair(ctx, user, sessionID, refreshToken)
+}
+
+// createDeviceSession 在tx所在的事务中为孩子的设备创建会话，签发只能用于语音聊天、绑定设备的长期令牌
+func (s *AuthService) createDeviceSession(ctx context.Context, tx *gorm.DB, child *model.User, deviceID string) (string, string, error) {
+	sessionID, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	if err := tx.Create(&model.AuthSession{
+		ID:              sessionID,
+		UserID:          child.ID,
+		ExpiresAt:       now.Add(deviceTokenTTL),
+		LastRefreshedAt: now,
+	}).Error; err != nil {
+		return "", "", fmt.Errorf("创建设备会话失败: %v", err)
+	}
+
+	token, err := s.signAccessToken(ctx, &AccessClaims{
+		UserID:    child.ID,
+		Role:      child.Role,
+		SessionID: sessionID,
+		Scope:     TokenScopeVoice,
+		DeviceID:  deviceID,
+	}, deviceTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	return token, sessionID, nil
+}
+
+// Refresh 用刷新令牌换取新的访问令牌和刷新令牌，旧的刷新令牌随即失效；
+// 已经用过的刷新令牌再次使用时说明令牌可能泄露，整个会话作废，需要重新登录
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	var token model.RefreshToken
+	err := s.db.WithContext(ctx).Where("token_hash = ?", hashToken(refreshToken)).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询刷新令牌失败: %v", err)
+	}
+
+	now := time.Now()
+	if token.UsedAt != nil {
+		return nil, s.revokeReusedSession(ctx, token.SessionID)
+	}
+	if now.After(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	var session model.AuthSession
+	if err := s.db.WithContext(ctx).Where("id = ?", token.SessionID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("查询登录会话失败: %v", err)
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrInvalidToken
+	}
+
+	// 账号删除后不能再刷新
+	var user model.User
+	if err := s.db.WithContext(ctx).First(&user, session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+
+	newToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := now.Add(s.config.RefreshTokenTTL)
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		if err := tx.Create(&model.RefreshToken{
+			SessionID: session.ID,
+			TokenHash: hashToken(newToken),
+			ExpiresAt: expiresAt,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"expires_at":        expiresAt,
+			"last_refreshed_at": now,
+		}).Error
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		return nil, s.revokeReusedSession(ctx, session.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("刷新令牌失败: %v", err)
+	}
+
+	return s.tokenPair(ctx, &user, session.ID, newToken)
+}
+
+// Logout 作废登录会话
+func (s *AuthService) Logout(ctx context.Context, sessionID string) error {
+	err := s.db.WithContext(ctx).Model(&model.AuthSession{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("退出登录失败: %v", err)
+	}
+	return nil
+}
+
+// ValidateAccessToken 校验访问令牌的签名、有效期以及所属会话是否已作废
+func (s *AuthService) ValidateAccessToken(ctx context.Context, accessToken string) (*AccessClaims, error) {
+	var claims AccessClaims
+	_, err := jwt.ParseWithClaims(accessToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.config.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.config.Issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.SessionID == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var count int64
+	err = s.db.WithContext(ctx).Model(&model.AuthSession{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", claims.SessionID, claims.UserID).
+		Count(&count).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询登录会话失败: %v", err)
+	}
+	if count == 0 {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// tokenPair 签发访问令牌，和刷新令牌一起返回
+func (s *AuthService) tokenPair(ctx context.Context, user *model.User, sessionID, refreshToken string) (*TokenPair, error) {
+	accessToken, err := s.signAccessToken(ctx, &AccessClaims{
+		UserID:    user.ID,
+		Role:      user.Role,
+		SessionID: sessionID,
+	}, s.config.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.config.AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// signAccessToken 补全用户所在的家庭和标准字段后签发访问令牌
+func (s *AuthService) signAccessToken(ctx context.Context, claims *AccessClaims, ttl time.Duration) (string, error) {
+	family, err := s.families.GetFamilyByUser(ctx, claims.UserID)
+	if err == nil {
+		claims.FamilyID = family.ID
+	} else if !errors.Is(err, ErrFamilyNotFound) {
+		return "", err
+	}
+
+	tokenID, err := randomToken(8)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    s.config.Issuer,
+		Subject:   strconv.FormatUint(claims.UserID, 10),
+		ID:        tokenID,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.config.Secret))
+	if err != nil {
+		return "", fmt.Errorf("签发访问令牌失败: %v", err)
+	}
+	return token, nil
+}
+
+// revokeReusedSession 刷新令牌被重复使用时作废整个会话
+func (s *AuthService) revokeReusedSession(ctx context.Context, sessionID string) error {
+	if err := s.Logout(ctx, sessionID); err != nil {
+		return err
+	}
+	return ErrInvalidToken
+}
+
+// revokeSessions 在tx所在的事务中作废用户的所有登录会话
+func revokeSessions(tx *gorm.DB, userID uint64) error {
+	return tx.Model(&model.AuthSession{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// randomToken 生成n字节的随机令牌，以十六进制表示
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成随机令牌失败: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken 令牌的SHA-256，数据库中只保存哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+func newTestAuthService(t *testing.T, db *gorm.DB) *AuthService {
+	t.Helper()
+	auth, err := NewAuthService(db, NewFamilyService(db), &AuthConfig{Secret: "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatalf("NewAuthService: %v", err)
+	}
+	return auth
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	tests := []struct {
+		name string
+		// reuse 用已经换过新令牌的旧刷新令牌再次刷新
+		reuse bool
+		// wantSessionRevoked 会话是否被作废，作废后新签发的令牌也不能再使用
+		wantSessionRevoked bool
+	}{
+		{name: "正常轮换", reuse: false, wantSessionRevoked: false},
+		{name: "旧令牌被重复使用", reuse: true, wantSessionRevoked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			auth := newTestAuthService(t, db)
+			user := &model.User{Role: model.UserRoleParent}
+			if err := db.Create(user).Error; err != nil {
+				t.Fatalf("create user: %v", err)
+			}
+
+			first, err := auth.Login(ctx, user)
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			second, err := auth.Refresh(ctx, first.RefreshToken)
+			if err != nil {
+				t.Fatalf("Refresh: %v", err)
+			}
+			if second.RefreshToken == first.RefreshToken {
+				t.Fatal("refresh token was not rotated")
+			}
+
+			if tt.reuse {
+				if _, err := auth.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("reused refresh err = %v, want ErrInvalidToken", err)
+				}
+			}
+
+			_, refreshErr := auth.Refresh(ctx, second.RefreshToken)
+			_, accessErr := auth.ValidateAccessToken(ctx, second.AccessToken)
+			if tt.wantSessionRevoked {
+				if !errors.Is(refreshErr, ErrInvalidToken) || !errors.Is(accessErr, ErrInvalidToken) {
+					t.Errorf("after reuse: refresh err = %v, access err = %v, want ErrInvalidToken", refreshErr, accessErr)
+				}
+				return
+			}
+			if refreshErr != nil || accessErr != nil {
+				t.Errorf("refresh err = %v, access err = %v, want nil", refreshErr, accessErr)
+			}
+		})
+	}
+}
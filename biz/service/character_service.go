@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+// ErrCharacterNotFound 角色不存在
+var ErrCharacterNotFound = errors.New("角色不存在")
+
+// DefaultStyleRules 面向3-6岁儿童的通用表达规则，角色未配置规则时使用
+var DefaultStyleRules = []string{
+	"使用简单、口语化的短句，每次回答不超过三句话",
+	"多用鼓励和肯定的语气，不批评、不吓唬孩子",
+	"不讨论暴力、恐怖、成人话题，遇到这类问题温和地转移话题",
+	"不要询问或记录孩子的住址、电话、学校等个人信息",
+	"适当提出简单的问题，引导孩子继续表达",
+}
+
+// defaultCharacters 系统内置角色
+var defaultCharacters = []model.Character{
+	{
+		Name:         "小兔子",
+		Description:  "活泼好奇的小兔子，喜欢唱歌和讲故事",
+		SystemPrompt: "你是一只名叫小兔子的卡通兔子，是小朋友的好朋友。你活泼、好奇，喜欢胡萝卜、唱儿歌和讲小故事。你正在和一位3到6岁的小朋友聊天。",
+		VoiceID:      "rabbit",
+		IsDefault:    true,
+	},
+	{
+		Name:         "小熊",
+		Description:  "温柔耐心的小熊，喜欢陪小朋友聊天和认识世界",
+		SystemPrompt: "你是一只名叫小熊的卡通熊，是小朋友温柔的大朋友。你说话慢慢的、很有耐心，喜欢蜂蜜、森林里的动物和回答小朋友的各种问题。你正在和一位3到6岁的小朋友聊天。",
+		VoiceID:      "bear",
+	},
+}
+
+// CharacterService 系统角色服务
+type CharacterService struct {
+	db *gorm.DB
+
+	// 角色配置缓存，每轮对话都会读取角色配置
+	cache map[uint64]*model.Character
+	mutex sync.RWMutex
+}
+
+// NewCharacterService 创建系统角色服务
+func NewCharacterService(db *gorm.DB) *CharacterService {
+	return &CharacterService{
+		db:    db,
+		cache: make(map[uint64]*model.Character),
+	}
+}
+
+// EnsureDefaultCharacters 初始化内置角色，已存在的角色不会被覆盖
+func (s *CharacterService) EnsureDefaultCharacters(ctx context.Context) error {
+	for _, c := range defaultCharacters {
+		character := c
+		if err := s.db.WithContext(ctx).Where(model.Character{Name: character.Name}).FirstOrCreate(&character).Error; err != nil {
+			return fmt.Errorf("初始化角色 %s 失败: %v", character.Name, err)
+		}
+	}
+	return nil
+}
+
+// CreateCharacter 创建角色
+func (s *CharacterService) CreateCharacter(ctx context.Context, character *model.Character) error {
+	if err := s.db.WithContext(ctx).Create(character).Error; err != nil {
+		return fmt.Errorf("创建角色失败: %v", err)
+	}
+	return nil
+}
+
+// GetCharacter 获取角色
+func (s *CharacterService) GetCharacter(ctx context.Context, id uint64) (*model.Character, error) {
+	s.mutex.RLock()
+	cached, ok := s.cache[id]
+	s.mutex.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	var character model.Character
+	if err := s.db.WithContext(ctx).First(&character, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCharacterNotFound
+		}
+		return nil, fmt.Errorf("查询角色失败: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.cache[id] = &character
+	s.mutex.Unlock()
+
+	return &character, nil
+}
+
+// ListCharacters 获取所有角色
+func (s *CharacterService) ListCharacters(ctx context.Context) ([]*model.Character, error) {
+	var characters []*model.Character
+	if err := s.db.WithContext(ctx).Order("id").Find(&characters).Error; err != nil {
+		return nil, fmt.Errorf("查询角色列表失败: %v", err)
+	}
+	return characters, nil
+}
+
+// UpdateCharacter 更新角色
+func (s *CharacterService) UpdateCharacter(ctx context.Context, character *model.Character) error {
+	var existing model.Character
+	if err := s.db.WithContext(ctx).First(&existing, character.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCharacterNotFound
+		}
+		return fmt.Errorf("查询角色失败: %v", err)
+	}
+
+	err := s.db.WithContext(ctx).Model(&existing).
+		Select("Name", "Description", "SystemPrompt", "VoiceID", "StyleRules", "IsDefault").
+		Updates(character).Error
+	if err != nil {
+		return fmt.Errorf("更新角色失败: %v", err)
+	}
+
+	s.invalidate(character.ID)
+	return nil
+}
+
+// DeleteCharacter 删除角色
+func (s *CharacterService) DeleteCharacter(ctx context.Context, id uint64) error {
+	result := s.db.WithContext(ctx).Delete(&model.Character{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("删除角色失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCharacterNotFound
+	}
+
+	s.invalidate(id)
+	return nil
+}
+
+// ResolveCharacter 获取会话使用的角色，未选择或角色已删除时返回默认角色
+func (s *CharacterService) ResolveCharacter(ctx context.Context, id uint64) (*model.Character, error) {
+	if id != 0 {
+		character, err := s.GetCharacter(ctx, id)
+		if err == nil {
+			return character, nil
+		}
+		if !errors.Is(err, ErrCharacterNotFound) {
+			return nil, err
+		}
+	}
+
+	var character model.Character
+	err := s.db.WithContext(ctx).Order("is_default DESC, id").First(&character).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCharacterNotFound
+		}
+		return nil, fmt.Errorf("查询默认角色失败: %v", err)
+	}
+	return &character, nil
+}
+
+// BuildSystemPrompt 生成角色的系统提示词，包含角色设定和适龄表达规则
+func BuildSystemPrompt(character *model.Character) string {
+	rules := character.StyleRules
+	if len(rules) == 0 {
+		rules = DefaultStyleRules
+	}
+
+	var b strings.Builder
+	b.WriteString(character.SystemPrompt)
+	b.WriteString("\n\n说话时请遵守以下规则：")
+	for _, rule := range rules {
+		b.WriteString("\n- ")
+		b.WriteString(rule)
+	}
+	return b.String()
+}
+
+// invalidate 清除角色缓存
+func (s *CharacterService) invalidate(id uint64) {
+	s.mutex.Lock()
+	delete(s.cache, id)
+	s.mutex.Unlock()
+}
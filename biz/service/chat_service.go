@@ -54,24 +54,29 @@ func (s *ChatService) GetUserMessages(ctx context.Context, userID uint64, limit
 	return messages, nil
 }
 
-// GetParentMessages 获取家长的聊天记录
-func (s *ChatService) GetParentMessages(ctx context.Context, parentID uint64, limit int64) ([]*model.ChatMessage, error) {
-	opts := options.Find().
-		SetSort(bson.D{{"created_at", -1}}).
-		SetLimit(limit)
+// GetChatHistory 分页获取孩子的聊天记录，按时间从新到旧排列
+func (s *ChatService) GetChatHistory(ctx context.Context, userID uint64, page, pageSize int) ([]*model.ChatMessage, int64, error) {
+	filter := bson.M{"user_id": userID}
+	total, err := s.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("统计聊天记录失败: %v", err)
+	}
 
-	cursor, err := s.coll.Find(ctx, bson.M{"parent_id": parentID}, opts)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize))
+	cursor, err := s.coll.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, fmt.Errorf("查询聊天记录失败: %v", err)
+		return nil, 0, fmt.Errorf("查询聊天记录失败: %v", err)
 	}
 	defer cursor.Close(ctx)
 
 	var messages []*model.ChatMessage
 	if err := cursor.All(ctx, &messages); err != nil {
-		return nil, fmt.Errorf("解析聊天记录失败: %v", err)
+		return nil, 0, fmt.Errorf("解析聊天记录失败: %v", err)
 	}
-
-	return messages, nil
+	return messages, total, nil
 }
 
 // GetEmotionMessages 获取情绪消息记录
@@ -98,5 +103,69 @@ func (s *ChatService) GetEmotionMessages(ctx context.Context, userID uint64, sta
 		return nil, fmt.Errorf("解析情绪记录失败: %v", err)
 	}
 
+	return messages, nil
+}
+
+// GetSessionMessages 获取用户会话最近的聊天记录，按时间正序返回
+func (s *ChatService) GetSessionMessages(ctx context.Context, userID uint64, sessionID string, limit int64) ([]*model.ChatMessage, error) {
+	opts := options.Find().
+		SetSort(bson.D{{"created_at", -1}}).
+		SetLimit(limit)
+
+	cursor, err := s.coll.Find(ctx, bson.M{"user_id": userID, "session_id": sessionID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("查询会话记录失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*model.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("解析会话记录失败: %v", err)
+	}
+
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+
+	return messages, nil
+}
+
+// SessionOwnedBy 会话中是否没有其他用户的聊天记录，新的会话ID也视为属于该用户
+func (s *ChatService) SessionOwnedBy(ctx context.Context, userID uint64, sessionID string) (bool, error) {
+	filter := bson.M{
+		"session_id": sessionID,
+		"user_id":    bson.M{"$ne": userID},
+	}
+	count, err := s.coll.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, fmt.Errorf("查询会话记录失败: %v", err)
+	}
+	return count == 0, nil
+}
+
+// GetChildMessages 获取孩子在时间范围内说的话，按时间正序返回
+func (s *ChatService) GetChildMessages(ctx context.Context, userID uint64, startTime, endTime time.Time) ([]*model.ChatMessage, error) {
+	filter := bson.M{
+		"user_id": userID,
+		"role":    model.RoleChild,
+		"created_at": bson.M{
+			"$gte": startTime,
+			"$lt":  endTime,
+		},
+	}
+
+	opts := options.Find().SetSort(bson.D{{"created_at", 1}})
+
+	cursor, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("查询聊天记录失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []*model.ChatMessage
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("解析聊天记录失败: %v", err)
+	}
+
 	return messages, nil
 }
\ No newline at end of file
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/fatedier/beego/logs"
+
+	"github.com/sweekar/biz/model"
+)
+
+// ConversationConfig 会话上下文配置
+type ConversationConfig struct {
+	MaxTurns    int           // 保留的最近消息条数
+	TokenBudget int           // 历史消息(含摘要)的token预算
+	IdleTimeout time.Duration // 会话空闲多久后从内存中清除
+}
+
+// DefaultConversationConfig 默认会话上下文配置
+var DefaultConversationConfig = ConversationConfig{
+	MaxTurns:    20,
+	TokenBudget: 1500,
+	IdleTimeout: 30 * time.Minute,
+}
+
+// ConversationTurn 会话中的一条消息
+type ConversationTurn struct {
+	Role    model.MessageRole
+	Content string
+}
+
+// ConversationSummarizer 将较早的对话压缩为摘要
+type ConversationSummarizer interface {
+	Summarize(ctx context.Context, summary string, turns []ConversationTurn) (string, error)
+}
+
+// conversation 单个会话的上下文
+type conversation struct {
+	summary   string
+	turns     []ConversationTurn
+	updatedAt time.Time
+	mutex     sync.Mutex
+}
+
+// conversationKey 会话上下文的键，会话ID由客户端提供，必须和用户一起区分会话
+type conversationKey struct {
+	userID    string
+	sessionID string
+}
+
+// ConversationStore 按用户和SessionID保存多轮对话上下文
+type ConversationStore struct {
+	config      ConversationConfig
+	chatService *ChatService
+	summarizer  ConversationSummarizer
+
+	sessions  map[conversationKey]*conversation
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+// NewConversationStore 创建会话上下文存储
+func NewConversationStore(config *ConversationConfig, chatService *ChatService, summarizer ConversationSummarizer) *ConversationStore {
+	if config == nil {
+		config = &DefaultConversationConfig
+	}
+	return &ConversationStore{
+		config:      *config,
+		chatService: chatService,
+		summarizer:  summarizer,
+		sessions:    make(map[conversationKey]*conversation),
+		lastSweep:   time.Now(),
+	}
+}
+
+// History 获取用户会话的摘要和最近的对话记录
+func (s *ConversationStore) History(ctx context.Context, userID, sessionID string) (string, []ConversationTurn) {
+	conv := s.session(ctx, conversationKey{userID: userID, sessionID: sessionID})
+
+	conv.mutex.Lock()
+	defer conv.mutex.Unlock()
+
+	turns := make([]ConversationTurn, len(conv.turns))
+	copy(turns, conv.turns)
+	return conv.summary, turns
+}
+
+// AppendTurn 记录一轮孩子与角色的对话，并持久化到聊天记录
+func (s *ConversationStore) AppendTurn(ctx context.Context, msg *model.VoiceMessage, childText, reply string) {
+	if msg.SessionID == "" || childText == "" {
+		return
+	}
+
+	turns := []ConversationTurn{{Role: model.RoleChild, Content: childText}}
+	if reply != "" {
+		turns = append(turns, ConversationTurn{Role: model.RoleCharacter, Content: reply})
+	}
+
+	conv := s.session(ctx, conversationKey{userID: msg.UserID, sessionID: msg.SessionID})
+	conv.mutex.Lock()
+	conv.turns = append(conv.turns, turns...)
+	conv.updatedAt = time.Now()
+	s.trim(ctx, conv)
+	conv.mutex.Unlock()
+
+	s.persist(ctx, msg, turns)
+	s.sweep()
+}
+
+// session 获取会话上下文，内存中不存在时从聊天记录恢复
+func (s *ConversationStore) session(ctx context.Context, key conversationKey) *conversation {
+	s.mutex.Lock()
+	conv, ok := s.sessions[key]
+	if !ok {
+		conv = &conversation{updatedAt: time.Now()}
+		s.sessions[key] = conv
+		// 在释放全局锁前锁住会话，避免恢复完成前被读取
+		conv.mutex.Lock()
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		conv.turns = s.restore(ctx, key)
+		s.trim(ctx, conv)
+		conv.mutex.Unlock()
+	}
+	return conv
+}
+
+// restore 从用户自己的聊天记录中恢复会话最近的对话
+func (s *ConversationStore) restore(ctx context.Context, key conversationKey) []ConversationTurn {
+	if s.chatService == nil {
+		return nil
+	}
+
+	userID, err := strconv.ParseUint(key.userID, 10, 64)
+	if err != nil {
+		logs.Error("parse user id %q error: %v", key.userID, err)
+		return nil
+	}
+	messages, err := s.chatService.GetSessionMessages(ctx, userID, key.sessionID, int64(s.config.MaxTurns))
+	if err != nil {
+		logs.Error("restore conversation %s error: %v", key.sessionID, err)
+		return nil
+	}
+
+	turns := make([]ConversationTurn, 0, len(messages))
+	for _, m := range messages {
+		turns = append(turns, ConversationTurn{Role: m.Role, Content: m.Content})
+	}
+	return turns
+}
+
+// trim 按条数和token预算裁剪会话，被移出窗口的对话合并到摘要中，调用方需持有会话锁
+func (s *ConversationStore) trim(ctx context.Context, conv *conversation) {
+	cut := 0
+	tokens := estimateTokens(conv.summary)
+	for _, t := range conv.turns {
+		tokens += estimateTokens(t.Content)
+	}
+
+	// 至少保留最近一轮对话
+	for cut < len(conv.turns)-2 &&
+		(len(conv.turns)-cut > s.config.MaxTurns || tokens > s.config.TokenBudget) {
+		tokens -= estimateTokens(conv.turns[cut].Content)
+		cut++
+	}
+	if cut == 0 {
+		return
+	}
+
+	evicted := conv.turns[:cut]
+	conv.turns = append([]ConversationTurn(nil), conv.turns[cut:]...)
+
+	if s.summarizer == nil {
+		return
+	}
+	summary, err := s.summarizer.Summarize(ctx, conv.summary, evicted)
+	if err != nil {
+		logs.Error("summarize conversation error: %v", err)
+		return
+	}
+	conv.summary = summary
+}
+
+// persist 通过ChatService保存对话记录
+func (s *ConversationStore) persist(ctx context.Context, msg *model.VoiceMessage, turns []ConversationTurn) {
+	if s.chatService == nil {
+		return
+	}
+
+	userID, err := strconv.ParseUint(msg.UserID, 10, 64)
+	if err != nil {
+		logs.Error("parse user id %q error: %v", msg.UserID, err)
+		return
+	}
+
+	for _, t := range turns {
+		chatMsg := &model.ChatMessage{
+			UserID:    userID,
+			SessionID: msg.SessionID,
+			MessageID: msg.ID,
+			Role:      t.Role,
+			Type:      model.TextMessage,
+			Content:   t.Content,
+		}
+		if err := s.chatService.SaveMessage(ctx, chatMsg); err != nil {
+			logs.Error("save conversation turn error: %v", err)
+		}
+	}
+}
+
+// sweep 清除空闲超时的会话
+func (s *ConversationStore) sweep() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) < s.config.IdleTimeout {
+		return
+	}
+	s.lastSweep = now
+
+	for key, conv := range s.sessions {
+		conv.mutex.Lock()
+		idle := now.Sub(conv.updatedAt) > s.config.IdleTimeout
+		conv.mutex.Unlock()
+		if idle {
+			delete(s.sessions, key)
+		}
+	}
+}
+
+// estimateTokens 粗略估算文本的token数：中文按每字一个token，其他字符按每4个一个token
+func estimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// LLMSummarizer 使用大模型生成对话摘要
+type LLMSummarizer struct {
+	llm ChatModel
+}
+
+// NewLLMSummarizer 创建大模型对话摘要器
+func NewLLMSummarizer(llm ChatModel) *LLMSummarizer {
+	return &LLMSummarizer{llm: llm}
+}
+
+// Summarize 将已有摘要和较早的对话合并为新的摘要
+func (s *LLMSummarizer) Summarize(ctx context.Context, summary string, turns []ConversationTurn) (string, error) {
+	var b strings.Builder
+	if summary != "" {
+		b.WriteString("已有摘要：")
+		b.WriteString(summary)
+		b.WriteString("\n")
+	}
+	b.WriteString("新的对话：\n")
+	for _, t := range turns {
+		if t.Role == model.RoleChild {
+			b.WriteString("孩子：")
+		} else {
+			b.WriteString("角色：")
+		}
+		b.WriteString(t.Content)
+		b.WriteString("\n")
+	}
+
+	result, err := s.llm.Chat(ctx, []PromptMessage{
+		{
+			Role:    PromptRoleSystem,
+			Content: "请把下面孩子与陪伴角色的对话合并成不超过100字的中文摘要，保留孩子提到的名字、喜好、正在聊的话题和未完成的约定。只输出摘要。",
+		},
+		{
+			Role:    PromptRoleUser,
+			Content: b.String(),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("create summary error: %v", err)
+	}
+	return result, nil
+}
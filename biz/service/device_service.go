@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// pairingCodeDigits 配对码的位数
+	pairingCodeDigits = 8
+	// pairingCodeTTL 配对码的有效期
+	pairingCodeTTL = 10 * time.Minute
+	// pairingQRPrefix 配对二维码的内容前缀，客户端扫码后取出配对码
+	pairingQRPrefix = "sweekar://pair?code="
+	// maxPairingFailures 同一来源在pairingFailureWindow内最多输错配对码的次数
+	maxPairingFailures   = 10
+	pairingFailureWindow = 10 * time.Minute
+	// maxDeviceIDLength 设备标识的最大长度
+	maxDeviceIDLength = 128
+	// maxDeviceNameLength 设备名称的最大字符数
+	maxDeviceNameLength = 64
+)
+
+var (
+	// ErrInvalidPairingCode 配对码错误、已过期或已使用
+	ErrInvalidPairingCode = errors.New("配对码无效或已过期")
+	// ErrTooManyPairingAttempts 配对码输错次数过多
+	ErrTooManyPairingAttempts = errors.New("配对码错误次数过多，请稍后再试")
+	// ErrInvalidDevice 设备标识或名称无效
+	ErrInvalidDevice = errors.New("设备信息无效")
+	// ErrDeviceNotFound 设备不存在或不属于家长的孩子
+	ErrDeviceNotFound = errors.New("设备不存在")
+)
+
+// PairingCodeResult 生成的配对码，家长在孩子的设备上输入配对码或扫描二维码
+type PairingCodeResult struct {
+	Code      string    `json:"code"`       // 配对码
+	QRContent string    `json:"qr_content"` // 二维码内容
+	ExpiresAt time.Time `json:"expires_at"` // 过期时间
+}
+
+// DeviceToken 配对成功后返回给孩子设备的令牌，没有刷新令牌，有效期内一直可用
+type DeviceToken struct {
+	AccessToken string        `json:"access_token"`
+	TokenType   string        `json:"token_type"`
+	ExpiresIn   int           `json:"expires_in"` // 令牌的有效秒数
+	Device      *model.Device `json:"device"`
+}
+
+// DeviceService 孩子设备配对：家长为孩子生成一次性配对码，孩子的设备用配对码换取只能用于语音聊天的设备令牌
+type DeviceService struct {
+	db      *gorm.DB
+	users   *UserService
+	auth    *AuthService
+	limiter *failureLimiter
+}
+
+// NewDeviceService 创建设备配对服务
+func NewDeviceService(db *gorm.DB, users *UserService, auth *AuthService) *DeviceService {
+	return &DeviceService{
+		db:      db,
+		users:   users,
+		auth:    auth,
+		limiter: newFailureLimiter(maxPairingFailures, pairingFailureWindow),
+	}
+}
+
+// CreatePairingCode 为家长的孩子生成配对码，之前未使用的配对码随即失效
+func (s *DeviceService) CreatePairingCode(ctx context.Context, parentID, childID uint64) (*PairingCodeResult, error) {
+	child, err := s.users.ManagedChild(ctx, parentID, childID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var code string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("child_id = ? AND used_at IS NULL", child.ID).Delete(&model.PairingCode{}).Error; err != nil {
+			return err
+		}
+
+		// 配对码只有8位，避免和其他孩子仍然有效的配对码重复
+		for {
+			code, err = randomDigits(pairingCodeDigits)
+			if err != nil {
+				return err
+			}
+			var count int64
+			err = tx.Model(&model.PairingCode{}).
+				Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(code), now).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				break
+			}
+		}
+
+		return tx.Create(&model.PairingCode{
+			CodeHash:  hashToken(code),
+			ChildID:   child.ID,
+			ParentID:  parentID,
+			ExpiresAt: now.Add(pairingCodeTTL),
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("生成配对码失败: %v", err)
+	}
+
+	return &PairingCodeResult{
+		Code:      code,
+		QRContent: pairingQRPrefix + code,
+		ExpiresAt: now.Add(pairingCodeTTL),
+	}, nil
+}
+
+// Pair 孩子的设备用配对码换取设备令牌，source为请求来源，用于限制输错配对码的次数；
+// 同一设备重新配对时，之前的设备令牌作废
+func (s *DeviceService) Pair(ctx context.Context, code, deviceID, name, source string) (*DeviceToken, error) {
+	deviceID = strings.TrimSpace(deviceID)
+	name = strings.TrimSpace(name)
+	if deviceID == "" || len(deviceID) > maxDeviceIDLength || len([]rune(name)) > maxDeviceNameLength {
+		return nil, ErrInvalidDevice
+	}
+	if !s.limiter.allow(source) {
+		return nil, ErrTooManyPairingAttempts
+	}
+
+	now := time.Now()
+	var pairing model.PairingCode
+	err := s.db.WithContext(ctx).
+		Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(strings.TrimSpace(code)), now).
+		First(&pairing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.limiter.fail(source)
+		return nil, ErrInvalidPairingCode
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询配对码失败: %v", err)
+	}
+
+	// 生成配对码之后孩子可能已被删除，或者家长已不再是孩子的监护人
+	child, err := s.users.ManagedChild(ctx, pairing.ParentID, pairing.ChildID)
+	if errors.Is(err, ErrChildNotFound) || errors.Is(err, ErrReadOnlyGuardian) {
+		return nil, ErrInvalidPairingCode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token string
+	device := &model.Device{
+		ChildID:  child.ID,
+		ParentID: pairing.ParentID,
+		DeviceID: deviceID,
+		Name:     name,
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.PairingCode{}).
+			Where("id = ? AND used_at IS NULL", pairing.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidPairingCode
+		}
+
+		var replaced []model.Device
+		err := tx.Where("child_id = ? AND device_id = ? AND revoked_at IS NULL", child.ID, deviceID).
+			Find(&replaced).Error
+		if err != nil {
+			return err
+		}
+		for i := range replaced {
+			if err := revokeDevice(tx, &replaced[i], now); err != nil {
+				return err
+			}
+		}
+
+		token, device.SessionID, err = s.auth.createDeviceSession(ctx, tx, child, deviceID)
+		if err != nil {
+			return err
+		}
+		return tx.Create(device).Error
+	})
+	if errors.Is(err, ErrInvalidPairingCode) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("设备配对失败: %v", err)
+	}
+
+	return &DeviceToken{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(deviceTokenTTL / time.Second),
+		Device:      device,
+	}, nil
+}
+
+// ListDevices 获取家长有完全管理权限的孩子已配对的设备，childID为0时返回所有孩子的设备
+func (s *DeviceService) ListDevices(ctx context.Context, parentID, childID uint64) ([]model.Device, error) {
+	if childID != 0 {
+		if _, err := s.users.ManagedChild(ctx, parentID, childID); err != nil {
+			return nil, err
+		}
+	}
+
+	query := s.db.WithContext(ctx).
+		Where("revoked_at IS NULL").
+		Where("child_id IN (?)", guardedChildren(s.db, parentID, true))
+	if childID != 0 {
+		query = query.Where("child_id = ?", childID)
+	}
+
+	var devices []model.Device
+	if err := query.Order("id").Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("查询设备列表失败: %v", err)
+	}
+	return devices, nil
+}
+
+// RevokeDevice 解除设备配对，设备令牌立即失效
+func (s *DeviceService) RevokeDevice(ctx context.Context, parentID, id uint64) error {
+	var device model.Device
+	err := s.db.WithContext(ctx).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Where("child_id IN (?)", guardedChildren(s.db, parentID, true)).
+		First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDeviceNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("查询设备失败: %v", err)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return revokeDevice(tx, &device, time.Now())
+	})
+	if err != nil {
+		return fmt.Errorf("解除设备配对失败: %v", err)
+	}
+	return nil
+}
+
+// revokeDevice 在tx所在的事务中解除设备配对并作废设备会话
+func revokeDevice(tx *gorm.DB, device *model.Device, now time.Time) error {
+	if err := tx.Model(device).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&model.AuthSession{}).
+		Where("id = ? AND revoked_at IS NULL", device.SessionID).
+		Update("revoked_at", now).Error
+}
+
+// randomDigits 生成n位随机数字
+func randomDigits(n int) (string, error) {
+	digits := make([]byte, n)
+	for i := range digits {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("生成随机数失败: %v", err)
+		}
+		digits[i] = byte('0' + d.Int64())
+	}
+	return string(digits), nil
+}
+
+// failureLimiter 按来源统计一段时间内的失败次数，超过上限后拒绝请求，只在单个实例内生效
+type failureLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string][]time.Time
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:      max,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// allow 来源在时间窗口内的失败次数是否还没有达到上限
+func (l *failureLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.prune(key, time.Now())) < l.max
+}
+
+// fail 记录一次失败
+func (l *failureLimiter) fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.failures[key] = append(l.prune(key, now), now)
+}
+
+// prune 清理来源在时间窗口之外的失败记录，返回剩余的记录
+func (l *failureLimiter) prune(key string, now time.Time) []time.Time {
+	failures := l.failures[key]
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) >= l.window {
+		i++
+	}
+	failures = failures[i:]
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = failures
+	return failures
+}
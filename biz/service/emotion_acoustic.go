@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+// AcousticEmotionScorer 根据韵律特征给出情绪分布
+type AcousticEmotionScorer interface {
+	Score(ctx context.Context, features *model.ProsodyFeatures) (*model.EmotionData, error)
+}
+
+// 3到6岁儿童说话的参考值，用于衡量韵律特征偏离平常的程度
+const (
+	childPitchMean  = 300.0 // 平均基频(Hz)
+	childPitchStd   = 40.0  // 基频标准差(Hz)
+	childEnergyMean = -28.0 // 平均能量(dBFS)
+	childSpeechRate = 3.5   // 每秒音节数
+	childPauseRatio = 0.15  // 停顿占比
+
+	// minAcousticDuration 语音短于该时长时韵律特征不可靠
+	minAcousticDuration = 600 * time.Millisecond
+	// acousticWeight 融合时声学情绪的最大权重，文本情绪仍是主要依据
+	acousticWeight = 0.35
+	// neutralTextAcousticWeight 文本没有明显情绪时声学情绪的最大权重，孩子常用平淡的话表达情绪
+	neutralTextAcousticWeight = 0.6
+)
+
+// ProsodyEmotionScorer 基于规则的声学情绪打分：
+// 音高高、音量大、语速快偏向兴奋或生气；音量小、语速慢、停顿多偏向难过或孤单；音高波动大且停顿多偏向害怕或紧张
+type ProsodyEmotionScorer struct{}
+
+// NewProsodyEmotionScorer 创建声学情绪打分器
+func NewProsodyEmotionScorer() *ProsodyEmotionScorer {
+	return &ProsodyEmotionScorer{}
+}
+
+// Score 根据韵律特征给出情绪分布，Intensity表示特征偏离平常的程度
+func (s *ProsodyEmotionScorer) Score(ctx context.Context, f *model.ProsodyFeatures) (*model.EmotionData, error) {
+	// 各维度相对儿童参考值的偏离，正数表示更高/更快/更多
+	pitch := 0.0
+	if f.PitchMean > 0 {
+		pitch = clamp((f.PitchMean-childPitchMean)/childPitchMean*4, -1, 1)
+	}
+	variation := clamp((f.PitchStd-childPitchStd)/childPitchStd, -1, 1)
+	energy := clamp((f.EnergyMean-childEnergyMean)/10, -1, 1)
+	rate := clamp((f.SpeechRate-childSpeechRate)/childSpeechRate*2, -1, 1)
+	pauses := clamp((f.PauseRatio-childPauseRatio)/childPauseRatio, -1, 1)
+
+	arousal := (pitch + energy + rate) / 3
+	scores := map[model.EmotionType]float64{
+		model.EmotionNeutral:     1 - math.Abs(arousal),
+		model.EmotionExcitement:  positive(arousal) * (1 + positive(variation)) / 2,
+		model.EmotionHappy:       positive(arousal) * positive(pitch),
+		model.EmotionAngry:       positive(energy) * positive(-variation+0.5) * positive(rate+0.5),
+		model.EmotionSad:         positive(-arousal) * (1 + positive(pauses)) / 2,
+		model.EmotionLoneliness:  positive(-energy) * positive(-rate) * positive(pauses),
+		model.EmotionFear:        positive(variation) * positive(pitch) * positive(pauses+0.5),
+		model.EmotionAnxiety:     positive(variation) * positive(rate) * positive(pauses),
+		model.EmotionBoredom:     positive(-pitch) * positive(-variation) * positive(-rate),
+		model.EmotionFrustration: positive(energy) * positive(pauses),
+	}
+
+	data := newEmotionData(scores)
+	data.Intensity = math.Min(math.Abs(arousal)+math.Max(positive(variation), positive(pauses))/2, 1)
+	return data, nil
+}
+
+// FuseEmotion 融合文本和声学情绪分布；声学结果的权重随语音时长和特征可信度变化，没有声学结果时直接使用文本结果
+func FuseEmotion(text, acoustic *model.EmotionData, features *model.ProsodyFeatures) *model.EmotionData {
+	if acoustic == nil || features == nil || features.Duration < minAcousticDuration || features.PitchMean == 0 {
+		return text
+	}
+
+	weight := acousticWeight
+	if text.Emotion == model.EmotionNeutral {
+		weight = neutralTextAcousticWeight
+	}
+	weight *= math.Min(features.Duration.Seconds()/2, 1)
+	scores := make(map[model.EmotionType]float64, len(model.EmotionTypes))
+	for _, emotion := range model.EmotionTypes {
+		scores[emotion] = (1-weight)*text.Scores[emotion] + weight*acoustic.Scores[emotion]
+	}
+
+	fused := newEmotionData(scores)
+	fused.Intensity = (1-weight)*text.Intensity + weight*acoustic.Intensity
+	return fused
+}
+
+func positive(v float64) float64 {
+	return math.Max(v, 0)
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(v, hi))
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sweekar/biz/model"
+)
+
+// EmotionClassifier 文本情绪分类器
+type EmotionClassifier interface {
+	Classify(ctx context.Context, text string) (*model.EmotionData, error)
+}
+
+// emotionLexicon 儿童口语情绪词典，权重表示该词对情绪的指示强度
+var emotionLexicon = map[model.EmotionType]map[string]float64{
+	model.EmotionHappy: {
+		"开心": 2, "高兴": 2, "快乐": 2, "好玩": 1.5, "喜欢": 1.5, "哈哈": 1.5, "嘻嘻": 1.5,
+		"真棒": 1.5, "好吃": 1, "漂亮": 1, "爱你": 1.5, "谢谢": 0.5, "笑": 1,
+	},
+	model.EmotionExcitement: {
+		"太棒了": 2, "耶": 1.5, "哇": 1.5, "期待": 2, "等不及": 2, "厉害": 1.5, "冲呀": 1.5, "太好了": 2, "马上就": 1,
+	},
+	model.EmotionSad: {
+		"难过": 2, "伤心": 2, "哭": 2, "呜呜": 2, "不开心": 2, "不想": 0.5, "疼": 1,
+		"摔倒": 1, "丢了": 1, "坏掉了": 1.5, "对不起": 0.5, "死了": 1.5,
+	},
+	model.EmotionLoneliness: {
+		"没人陪我": 2.5, "没有人陪我": 2.5, "没人跟我玩": 2.5, "孤单": 2.5, "一个人": 1.5, "想妈妈": 2, "想爸爸": 2,
+		"没有朋友": 2, "好想你": 1.5, "陪我": 1,
+	},
+	model.EmotionFear: {
+		"害怕": 2, "怕": 1, "可怕": 2, "吓人": 2, "吓死": 2, "噩梦": 2, "怪兽": 1.5, "好黑": 1.5, "打雷": 1, "别过来": 2,
+	},
+	model.EmotionAnxiety: {
+		"担心": 2, "紧张": 2, "着急": 1.5, "怎么办": 1.5, "会不会": 1, "不敢": 1.5, "睡不着": 1.5, "万一": 1.5,
+	},
+	model.EmotionAngry: {
+		"生气": 2, "讨厌": 2, "气死": 2, "哼": 1.5, "走开": 1.5, "坏蛋": 1.5, "不公平": 1.5,
+		"抢": 1, "打人": 1, "不理你": 1.5, "别吵": 1, "不要": 1,
+	},
+	model.EmotionFrustration: {
+		"做不好": 2, "弄不好": 2, "学不会": 2, "搭不起来": 2, "又错了": 2, "为什么不行": 2, "总是不行": 2,
+		"算了": 1, "好难": 1.5, "太难了": 2, "烦": 1.5,
+	},
+	model.EmotionBoredom: {
+		"无聊": 2.5, "没意思": 2, "没劲": 2, "不好玩": 1.5, "没事做": 1.5, "好闷": 1.5, "还要多久": 1,
+	},
+}
+
+// 程度副词，放大后面情绪词的权重
+var emotionIntensifiers = map[string]float64{
+	"好": 1.5, "太": 1.8, "很": 1.5, "非常": 1.8, "特别": 1.8, "超级": 2, "真": 1.3, "有点": 0.7, "有一点": 0.6,
+}
+
+// 否定词，否定后面的情绪词
+var emotionNegations = []string{"不", "没", "没有", "别", "不是"}
+
+// emotionNegationTarget 情绪词被否定后指向的情绪，未列出的情绪否定后视为平静
+var emotionNegationTarget = map[model.EmotionType]model.EmotionType{
+	model.EmotionHappy:      model.EmotionSad,
+	model.EmotionExcitement: model.EmotionBoredom,
+}
+
+const (
+	// neutralPrior 平静情绪的基础分，没有命中情绪词时结果为平静
+	neutralPrior = 1.0
+	// fullIntensityScore 情绪得分达到该值时强度为1
+	fullIntensityScore = 5.0
+	// minSecondaryScore 次要情绪的最低概率
+	minSecondaryScore = 0.2
+)
+
+// lexiconMatch 文本中命中的情绪词
+type lexiconMatch struct {
+	pos     int
+	word    string
+	emotion model.EmotionType
+	weight  float64
+}
+
+// LexiconEmotionClassifier 基于中文情绪词典的离线分类器，支持程度副词和否定
+type LexiconEmotionClassifier struct{}
+
+// NewLexiconEmotionClassifier 创建词典情绪分类器
+func NewLexiconEmotionClassifier() *LexiconEmotionClassifier {
+	return &LexiconEmotionClassifier{}
+}
+
+// Classify 分析文本的情绪分布
+func (c *LexiconEmotionClassifier) Classify(ctx context.Context, text string) (*model.EmotionData, error) {
+	scores := map[model.EmotionType]float64{}
+	for _, m := range matchLexicon(text) {
+		prefix := text[:m.pos]
+		target := m.emotion
+		if rest, ok := trimNegation(prefix); ok {
+			target = model.EmotionNeutral
+			if t, ok := emotionNegationTarget[m.emotion]; ok {
+				target = t
+			}
+			prefix = rest
+		}
+		scores[target] += m.weight * intensifier(prefix)
+	}
+
+	// 强度取决于最强烈情绪的得分，平静不计强度
+	top := 0.0
+	for emotion, score := range scores {
+		if emotion != model.EmotionNeutral && score > top {
+			top = score
+		}
+	}
+
+	scores[model.EmotionNeutral] += neutralPrior
+	data := newEmotionData(scores)
+	if data.Emotion != model.EmotionNeutral {
+		data.Intensity = math.Min(top/fullIntensityScore, 1)
+	}
+	return data, nil
+}
+
+// matchLexicon 按最长匹配找出文本中的情绪词，重叠的词只保留先出现且更长的一个
+func matchLexicon(text string) []lexiconMatch {
+	var matches []lexiconMatch
+	for emotion, words := range emotionLexicon {
+		for word, weight := range words {
+			for offset := 0; ; {
+				i := strings.Index(text[offset:], word)
+				if i < 0 {
+					break
+				}
+				matches = append(matches, lexiconMatch{pos: offset + i, word: word, emotion: emotion, weight: weight})
+				offset += i + len(word)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].pos != matches[j].pos {
+			return matches[i].pos < matches[j].pos
+		}
+		return len(matches[i].word) > len(matches[j].word)
+	})
+
+	result := matches[:0]
+	end := 0
+	for _, m := range matches {
+		if m.pos < end {
+			continue
+		}
+		result = append(result, m)
+		end = m.pos + len(m.word)
+	}
+	return result
+}
+
+// intensifier 返回紧邻情绪词前的程度副词的放大倍数
+func intensifier(prefix string) float64 {
+	best, factor := 0, 1.0
+	for word, f := range emotionIntensifiers {
+		if strings.HasSuffix(prefix, word) && len(word) > best {
+			best, factor = len(word), f
+		}
+	}
+	return factor
+}
+
+// trimNegation 情绪词是否被否定，并去掉prefix末尾的否定词，便于识别否定词前的程度副词。
+// 否定词和情绪词之间可以有一个程度副词，如"不太开心"、"不是很开心"、"没有很开心"
+func trimNegation(prefix string) (string, bool) {
+	if rest := trimSuffix(prefix, emotionNegations); len(rest) < len(prefix) {
+		return rest, true
+	}
+
+	for adverb := range emotionIntensifiers {
+		if !strings.HasSuffix(prefix, adverb) {
+			continue
+		}
+		before := prefix[:len(prefix)-len(adverb)]
+		if rest := trimSuffix(before, emotionNegations); len(rest) < len(before) {
+			return rest, true
+		}
+	}
+	return prefix, false
+}
+
+// trimSuffix 去掉prefix末尾最长的一个词，没有以任何词结尾时原样返回
+func trimSuffix(prefix string, words []string) string {
+	best := 0
+	for _, word := range words {
+		if strings.HasSuffix(prefix, word) && len(word) > best {
+			best = len(word)
+		}
+	}
+	return prefix[:len(prefix)-best]
+}
+
+// newEmotionData 将各情绪的得分归一化为概率分布，取概率最高的情绪作为结果，第二高的非平静情绪作为次要情绪
+func newEmotionData(scores map[model.EmotionType]float64) *model.EmotionData {
+	total := 0.0
+	for _, score := range scores {
+		if score > 0 {
+			total += score
+		}
+	}
+
+	data := &model.EmotionData{
+		Emotion: model.EmotionNeutral,
+		Scores:  make(map[model.EmotionType]float64, len(model.EmotionTypes)),
+	}
+	if total == 0 {
+		data.Confidence = 1
+		data.Scores[model.EmotionNeutral] = 1
+		return data
+	}
+
+	// 按固定顺序遍历，得分相同时结果稳定
+	secondary := 0.0
+	for _, emotion := range model.EmotionTypes {
+		if scores[emotion] <= 0 {
+			continue
+		}
+		p := scores[emotion] / total
+		data.Scores[emotion] = p
+		if p > data.Confidence {
+			if data.Emotion != model.EmotionNeutral {
+				data.Secondary, secondary = data.Emotion, data.Confidence
+			}
+			data.Emotion, data.Confidence = emotion, p
+		} else if emotion != model.EmotionNeutral && p > secondary {
+			data.Secondary, secondary = emotion, p
+		}
+	}
+	if secondary < minSecondaryScore {
+		data.Secondary = ""
+	}
+	return data
+}
+
+// LLMEmotionClassifier 使用大模型判断孩子说话时的情绪
+type LLMEmotionClassifier struct {
+	llm ChatModel
+}
+
+// NewLLMEmotionClassifier 创建大模型情绪分类器
+func NewLLMEmotionClassifier(llm ChatModel) *LLMEmotionClassifier {
+	return &LLMEmotionClassifier{llm: llm}
+}
+
+// Classify 分析文本的情绪分布
+func (c *LLMEmotionClassifier) Classify(ctx context.Context, text string) (*model.EmotionData, error) {
+	names := make([]string, 0, len(model.EmotionTypes))
+	for _, emotion := range model.EmotionTypes {
+		names = append(names, string(emotion))
+	}
+
+	result, err := c.llm.Chat(ctx, []PromptMessage{
+		{
+			Role: PromptRoleSystem,
+			Content: "下面是一位3到6岁儿童说的话，请判断孩子说这句话时的情绪，给出每种情绪的概率(概率之和为1)，以及情绪强度intensity(0到1)。" +
+				"情绪类别：" + strings.Join(names, "、") + "。" +
+				`只输出JSON，例如：{"scores":{"excitement":0.6,"happy":0.3,"neutral":0.1},"intensity":0.7}。`,
+		},
+		{Role: PromptRoleUser, Content: text},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("classify emotion error: %v", err)
+	}
+
+	// 兼容模型在JSON前后附加的说明文字
+	start, end := strings.Index(result, "{"), strings.LastIndex(result, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid emotion response: %s", result)
+	}
+	var raw struct {
+		Scores    map[model.EmotionType]float64 `json:"scores"`
+		Intensity float64                       `json:"intensity"`
+	}
+	if err := json.Unmarshal([]byte(result[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("parse emotion response error: %v", err)
+	}
+
+	// 忽略未知的情绪类别
+	scores := make(map[model.EmotionType]float64, len(model.EmotionTypes))
+	for _, emotion := range model.EmotionTypes {
+		scores[emotion] = raw.Scores[emotion]
+	}
+	data := newEmotionData(scores)
+	if data.Emotion != model.EmotionNeutral {
+		data.Intensity = math.Max(0, math.Min(raw.Intensity, 1))
+	}
+	return data, nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestLexiconEmotionClassifier(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		emotion   model.EmotionType
+		intensity float64
+	}{
+		{name: "没有情绪词", text: "今天我们去了公园", emotion: model.EmotionNeutral, intensity: 0},
+		{name: "情绪词", text: "我今天很开心", emotion: model.EmotionHappy, intensity: 2 * 1.5 / fullIntensityScore},
+		{name: "程度副词", text: "我太生气了", emotion: model.EmotionAngry, intensity: 2 * 1.8 / fullIntensityScore},
+		{name: "减弱的程度副词", text: "我有点害怕", emotion: model.EmotionFear, intensity: 2 * 0.7 / fullIntensityScore},
+		{name: "最长匹配", text: "我不开心", emotion: model.EmotionSad, intensity: 2 / fullIntensityScore},
+		{name: "细分情绪优先于重叠的短词", text: "没人陪我玩", emotion: model.EmotionLoneliness, intensity: 2.5 / fullIntensityScore},
+		{name: "否定后指向相反的情绪", text: "我不喜欢这个", emotion: model.EmotionSad, intensity: 1.5 / fullIntensityScore},
+		{name: "否定后视为平静", text: "我不害怕", emotion: model.EmotionNeutral, intensity: 0},
+		{name: "否定词和情绪词之间有程度副词", text: "我今天不太开心", emotion: model.EmotionSad, intensity: 2 / fullIntensityScore},
+		{name: "不是很", text: "我不是很开心", emotion: model.EmotionSad, intensity: 2 / fullIntensityScore},
+		{name: "没有很", text: "我没有很开心", emotion: model.EmotionSad, intensity: 2 / fullIntensityScore},
+		{name: "程度副词后的否定", text: "我不是很害怕", emotion: model.EmotionNeutral, intensity: 0},
+		{name: "强度不超过1", text: "超级开心超级高兴超级快乐", emotion: model.EmotionHappy, intensity: 1},
+	}
+
+	classifier := NewLexiconEmotionClassifier()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := classifier.Classify(context.Background(), tt.text)
+			if err != nil {
+				t.Fatalf("Classify: %v", err)
+			}
+			if data.Emotion != tt.emotion {
+				t.Errorf("emotion = %s, want %s (scores %v)", data.Emotion, tt.emotion, data.Scores)
+			}
+			if math.Abs(data.Intensity-tt.intensity) > 1e-9 {
+				t.Errorf("intensity = %v, want %v", data.Intensity, tt.intensity)
+			}
+
+			total := 0.0
+			for _, p := range data.Scores {
+				total += p
+			}
+			if math.Abs(total-1) > 1e-9 {
+				t.Errorf("scores sum to %v, want 1", total)
+			}
+			if data.Confidence != data.Scores[data.Emotion] {
+				t.Errorf("confidence = %v, want score of %s %v", data.Confidence, data.Emotion, data.Scores[data.Emotion])
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// minNegativeDayChats 聊天次数少于该值的日子不判断负面情绪是否异常
+	minNegativeDayChats = 3
+	// minNegativeDayShare 负面情绪占比至少达到该值才算异常
+	minNegativeDayShare = 0.4
+	// negativeDayMargin 负面情绪占比需高出周期整体水平的幅度
+	negativeDayMargin = 0.2
+	// minEmotionChange 占比变化小于该值的情绪在总结中不提及
+	minEmotionChange = 0.05
+)
+
+// periodRange 报告周期覆盖的时间范围[start, end)，周报从周一开始
+func periodRange(period model.ReportPeriod, date time.Time) (time.Time, time.Time) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	switch period {
+	case model.ReportWeekly:
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 7)
+	case model.ReportMonthly:
+		start := day.AddDate(0, 0, 1-day.Day())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return day, day.AddDate(0, 0, 1)
+	}
+}
+
+// reportDate 报告日期列只保存日期，按UTC零点保存当地日期，避免数据库连接时区把日期换算到前一天
+func reportDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// countEmotions 统计情绪分布
+func countEmotions(records []model.EmotionRecord) model.EmotionStats {
+	stats := make(model.EmotionStats)
+	for _, record := range records {
+		stats[record.Emotion]++
+	}
+	return stats
+}
+
+// emotionShares 各情绪的占比和负面情绪的总占比
+func emotionShares(stats model.EmotionStats) (map[model.EmotionType]float64, float64) {
+	total := 0
+	for _, count := range stats {
+		total += count
+	}
+	shares := make(map[model.EmotionType]float64, len(stats))
+	if total == 0 {
+		return shares, 0
+	}
+
+	negative := 0.0
+	for emotion, count := range stats {
+		shares[emotion] = float64(count) / float64(total)
+		if emotion.IsNegative() {
+			negative += shares[emotion]
+		}
+	}
+	return shares, negative
+}
+
+// comparePeriods 对比本周期和上一周期的情绪分布
+func comparePeriods(current, previous model.EmotionStats, previousChatCount int) *model.PeriodComparison {
+	shares, negative := emotionShares(current)
+	previousShares, previousNegative := emotionShares(previous)
+
+	comparison := &model.PeriodComparison{
+		PreviousChatCount:     previousChatCount,
+		PreviousStats:         previous,
+		NegativeShare:         negative,
+		PreviousNegativeShare: previousNegative,
+	}
+	for _, emotion := range model.EmotionTypes {
+		if shares[emotion] == 0 && previousShares[emotion] == 0 {
+			continue
+		}
+		comparison.Changes = append(comparison.Changes, model.EmotionChange{
+			Emotion:  emotion,
+			Share:    shares[emotion],
+			Previous: previousShares[emotion],
+			Change:   shares[emotion] - previousShares[emotion],
+		})
+	}
+	sort.SliceStable(comparison.Changes, func(i, j int) bool {
+		return math.Abs(comparison.Changes[i].Change) > math.Abs(comparison.Changes[j].Change)
+	})
+	return comparison
+}
+
+// findNegativeDays 找出负面情绪占比明显高于周期整体水平的日子，按日期排列
+func findNegativeDays(records []model.EmotionRecord, loc *time.Location) []model.NegativeDay {
+	byDay := make(map[time.Time]model.EmotionStats)
+	for _, record := range records {
+		t := record.CreatedAt.In(loc)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if byDay[day] == nil {
+			byDay[day] = make(model.EmotionStats)
+		}
+		byDay[day][record.Emotion]++
+	}
+	if len(byDay) < 2 {
+		return nil
+	}
+
+	_, overall := emotionShares(countEmotions(records))
+	threshold := math.Max(minNegativeDayShare, overall+negativeDayMargin)
+
+	var days []model.NegativeDay
+	for day, stats := range byDay {
+		chats := 0
+		for _, count := range stats {
+			chats += count
+		}
+		_, negative := emotionShares(stats)
+		if chats < minNegativeDayChats || negative < threshold {
+			continue
+		}
+
+		var top model.EmotionType
+		for _, emotion := range rankEmotions(stats) {
+			if emotion.IsNegative() {
+				top = emotion
+				break
+			}
+		}
+		days = append(days, model.NegativeDay{
+			Date:          day,
+			ChatCount:     chats,
+			NegativeShare: negative,
+			TopEmotion:    top,
+		})
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Date.Before(days[j].Date)
+	})
+	return days
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestPeriodRange(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("load location: %v", err)
+	}
+	at := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, shanghai)
+	}
+
+	tests := []struct {
+		name   string
+		period model.ReportPeriod
+		date   time.Time
+		start  time.Time
+		end    time.Time
+	}{
+		{name: "日报", period: model.ReportDaily, date: at(2024, 3, 14, 21), start: at(2024, 3, 14, 0), end: at(2024, 3, 15, 0)},
+		{name: "未知周期按日报", period: "", date: at(2024, 3, 14, 0), start: at(2024, 3, 14, 0), end: at(2024, 3, 15, 0)},
+		{name: "周报从周一开始", period: model.ReportWeekly, date: at(2024, 3, 14, 9), start: at(2024, 3, 11, 0), end: at(2024, 3, 18, 0)},
+		{name: "周一的周报", period: model.ReportWeekly, date: at(2024, 3, 11, 0), start: at(2024, 3, 11, 0), end: at(2024, 3, 18, 0)},
+		{name: "周日属于前一周", period: model.ReportWeekly, date: at(2024, 3, 17, 23), start: at(2024, 3, 11, 0), end: at(2024, 3, 18, 0)},
+		{name: "跨年的周报", period: model.ReportWeekly, date: at(2025, 1, 1, 12), start: at(2024, 12, 30, 0), end: at(2025, 1, 6, 0)},
+		{name: "月报", period: model.ReportMonthly, date: at(2024, 2, 29, 8), start: at(2024, 2, 1, 0), end: at(2024, 3, 1, 0)},
+		{name: "十二月的月报", period: model.ReportMonthly, date: at(2024, 12, 31, 8), start: at(2024, 12, 1, 0), end: at(2025, 1, 1, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := periodRange(tt.period, tt.date)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("periodRange = [%v, %v), want [%v, %v)", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
@@ -2,48 +2,139 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/apache/rocketmq-client-go/v2"
-	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/fatedier/beego/logs"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"sweekar/biz/model"
 	"sweekar/pkg/websocket"
 )
 
+// ErrReportNotFound 情绪报告不存在
+var ErrReportNotFound = errors.New("情绪报告不存在")
+
+// reportContentColumns 重新生成报告时覆盖的列
+var reportContentColumns = []string{
+	"end_date", "chat_count", "emotion_stats", "summary", "timeline", "topics",
+	"quotes", "talking_points", "comparison", "negative_days", "updated_at",
+}
+
+// EmotionProviders 情绪分析各环节的实现，为空的环节使用内置实现
+type EmotionProviders struct {
+	Classifier EmotionClassifier     // 文本情绪分类
+	Acoustic   AcousticEmotionScorer // 声学情绪打分
+	Summarizer ReportSummarizer      // 报告总结生成
+}
+
 // EmotionProcessor 情绪处理器
 type EmotionProcessor struct {
 	db           *gorm.DB
 	mqProducer   rocketmq.Producer
 	mqConsumer   rocketmq.PushConsumer
 	wsHandler    *websocket.Handler
+	chatService  *ChatService
+	classifier   EmotionClassifier
+	acoustic     AcousticEmotionScorer
+	summarizer   ReportSummarizer
 }
 
-// NewEmotionProcessor 创建情绪处理器
-func NewEmotionProcessor(db *gorm.DB, producer rocketmq.Producer, consumer rocketmq.PushConsumer, wsHandler *websocket.Handler) *EmotionProcessor {
-	return &EmotionProcessor{
-		db:         db,
-		mqProducer: producer,
-		mqConsumer: consumer,
-		wsHandler:  wsHandler,
+// NewEmotionProcessor 创建情绪处理器，providers为空时使用内置的词典分类器、韵律打分器和模板总结
+func NewEmotionProcessor(db *gorm.DB, producer rocketmq.Producer, consumer rocketmq.PushConsumer, wsHandler *websocket.Handler, chatService *ChatService, providers *EmotionProviders) *EmotionProcessor {
+	p := &EmotionProcessor{
+		db:          db,
+		mqProducer:  producer,
+		mqConsumer:  consumer,
+		wsHandler:   wsHandler,
+		chatService: chatService,
+		classifier:  NewLexiconEmotionClassifier(),
+		acoustic:    NewProsodyEmotionScorer(),
+		summarizer:  NewTemplateReportSummarizer(),
+	}
+	if providers != nil {
+		if providers.Classifier != nil {
+			p.classifier = providers.Classifier
+		}
+		if providers.Acoustic != nil {
+			p.acoustic = providers.Acoustic
+		}
+		if providers.Summarizer != nil {
+			p.summarizer = providers.Summarizer
+		}
 	}
+	return p
 }
 
 // AnalyzeEmotion 分析聊天内容的情绪
 func (p *EmotionProcessor) AnalyzeEmotion(ctx context.Context, chatID uint64, userID uint64, content string) error {
-	// TODO: 接入情绪分析AI模型
-	// 这里模拟情绪分析结果
-	emotion := model.EmotionRecord{
+	return p.analyze(ctx, &model.EmotionRecord{
 		UserID:    userID,
 		ChatID:    chatID,
-		Emotion:   model.EmotionHappy,
-		Confidence: 0.85,
 		CreatedAt: time.Now(),
+	}, content, nil)
+}
+
+// AnalyzeVoiceEmotion 分析孩子一句语音识别结果的情绪
+func (p *EmotionProcessor) AnalyzeVoiceEmotion(ctx context.Context, asrResult *model.ASRResult) error {
+	userID, err := strconv.ParseUint(asrResult.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse user id %q error: %v", asrResult.UserID, err)
+	}
+
+	createdAt := asrResult.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	record := &model.EmotionRecord{
+		UserID:    userID,
+		SessionID: asrResult.SessionID,
+		CreatedAt: createdAt,
+	}
+	if asrResult.ID != "" {
+		record.MessageID = &asrResult.ID
+	}
+	return p.analyze(ctx, record, asrResult.Text, asrResult.Prosody)
+}
+
+// analyze 识别文本情绪，有韵律特征时融合声学情绪，并保存情绪记录
+func (p *EmotionProcessor) analyze(ctx context.Context, record *model.EmotionRecord, content string, prosody *model.ProsodyFeatures) error {
+	if content == "" {
+		return nil
+	}
+
+	text, err := p.classifier.Classify(ctx, content)
+	if err != nil {
+		return fmt.Errorf("分析情绪失败: %v", err)
+	}
+
+	// 声学打分失败时只使用文本情绪
+	var acoustic *model.EmotionData
+	if prosody != nil {
+		if acoustic, err = p.acoustic.Score(ctx, prosody); err != nil {
+			logs.Error("score acoustic emotion error: %v", err)
+			acoustic = nil
+		}
 	}
 
-	// 保存情绪记录
-	if err := p.db.Create(&emotion).Error; err != nil {
+	result := FuseEmotion(text, acoustic, prosody)
+	record.Emotion = result.Emotion
+	record.Confidence = result.Confidence
+	record.Intensity = result.Intensity
+	record.SecondaryEmotion = result.Secondary
+	record.Scores = result.Scores
+	record.TextScores = text.Scores
+	record.Prosody = prosody
+	if acoustic != nil {
+		record.AcousticScores = acoustic.Scores
+	}
+
+	// 保存情绪记录，消息队列重复投递的同一条语音消息只保存一次
+	if err := p.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(record).Error; err != nil {
 		return err
 	}
 
@@ -52,51 +143,152 @@ func (p *EmotionProcessor) AnalyzeEmotion(ctx context.Context, chatID uint64, us
 
 // GenerateDailyReport 生成每日情绪报告
 func (p *EmotionProcessor) GenerateDailyReport(ctx context.Context, userID uint64, date time.Time) error {
-	// 获取当天的聊天情绪记录
+	return p.GenerateReport(ctx, userID, model.ReportDaily, date)
+}
+
+// GenerateReport 生成date所在周期的情绪报告，周报和月报会与上一周期对比并标出负面情绪异常偏多的日子；
+// 重复生成时覆盖已有报告的内容
+func (p *EmotionProcessor) GenerateReport(ctx context.Context, userID uint64, period model.ReportPeriod, date time.Time) error {
+	start, end := periodRange(period, date)
+
+	// 获取周期内的聊天情绪记录
 	var records []model.EmotionRecord
-	if err := p.db.Where("user_id = ? AND DATE(created_at) = DATE(?)", userID, date).Find(&records).Error; err != nil {
+	if err := p.db.WithContext(ctx).Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, start, end).
+		Order("created_at").Find(&records).Error; err != nil {
 		return err
 	}
 
-	// 如果当天没有聊天记录，则不生成报告
+	// 如果周期内没有聊天记录，则不生成报告
 	if len(records) == 0 {
 		return nil
 	}
 
 	// 统计情绪分布
-	emotionStats := make(map[model.EmotionType]int)
-	for _, record := range records {
-		emotionStats[record.Emotion]++
-	}
+	emotionStats := countEmotions(records)
 
 	// 生成情绪报告
 	report := model.EmotionReport{
 		UserID:       userID,
-		Date:         date,
+		Period:       period,
+		Date:         reportDate(start),
+		EndDate:      reportDate(end.AddDate(0, 0, -1)),
 		ChatCount:    len(records),
 		EmotionStats: emotionStats,
-		Summary:      generateEmotionSummary(emotionStats), // 生成情绪总结
+		BasicEmotionStats: emotionStats.Basic(),
 		CreatedAt:    time.Now(),
 	}
 
-	// 保存情绪报告
-	if err := p.db.Create(&report).Error; err != nil {
-		return err
+	if period == model.ReportDaily {
+		report.Timeline = buildEmotionTimeline(records)
+	} else {
+		// 与上一周期对比，对比失败时报告中不包含对比
+		previousStart, previousEnd := periodRange(period, start.AddDate(0, 0, -1))
+		var previous []model.EmotionRecord
+		if err := p.db.WithContext(ctx).Select("emotion").
+			Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, previousStart, previousEnd).
+			Find(&previous).Error; err != nil {
+			logs.Error("get previous emotion records of user %d error: %v", userID, err)
+		} else if len(previous) > 0 {
+			report.Comparison = comparePeriods(emotionStats, countEmotions(previous), len(previous))
+		}
+		report.NegativeDays = findNegativeDays(records, start.Location())
 	}
 
-	// 发送报告生成消息到消息队列
-	msg := primitive.NewMessage("emotion_report", []byte(report.ID))
-	_, err := p.mqProducer.SendSync(context.Background(), msg)
+	// 从孩子周期内说的话中提取话题和有代表性的原话
+	if p.chatService != nil {
+		messages, err := p.chatService.GetChildMessages(ctx, userID, start, end)
+		if err != nil {
+			logs.Error("get child messages of user %d error: %v", userID, err)
+		}
+		report.Topics = extractTopics(messages)
+		report.Quotes = selectQuotes(records, messages)
+	}
+
+	// 生成情绪总结和沟通建议
+	summary := p.generateSummary(ctx, &report)
+	report.Summary = summary.Summary
+	report.TalkingPoints = summary.TalkingPoints
+
+	// 保存情绪报告，同一周期的报告已存在时覆盖内容，保留推送时间避免重复推送；报告由调度器在推送时间统一推送给家长
+	if err := p.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "period"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns(reportContentColumns),
+	}).Create(&report).Error; err != nil {
+		return fmt.Errorf("保存情绪报告失败: %v", err)
+	}
+
+	return nil
+}
+
+// GetEmotionReport 获取孩子最新的每日情绪报告
+func (p *EmotionProcessor) GetEmotionReport(ctx context.Context, userID string) (*model.EmotionReport, error) {
+	id, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("用户ID无效: %v", err)
 	}
 
-	// 通过WebSocket实时推送报告给家长
-	return p.wsHandler.BroadcastEmotionReport(userID, report)
+	reports, _, err := p.ListReports(ctx, id, model.ReportDaily, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, ErrReportNotFound
+	}
+	return &reports[0], nil
 }
 
-// generateEmotionSummary 生成情绪总结
-func generateEmotionSummary(stats map[model.EmotionType]int) string {
-	// TODO: 根据情绪统计生成更智能的总结
-	return "今天整体心情不错，多数时候比较开心"
-}
\ No newline at end of file
+// GetPeriodReport 获取date所在周期的情绪报告
+func (p *EmotionProcessor) GetPeriodReport(ctx context.Context, userID uint64, period model.ReportPeriod, date time.Time) (*model.EmotionReport, error) {
+	start, _ := periodRange(period, date)
+
+	var report model.EmotionReport
+	err := p.db.WithContext(ctx).Where("user_id = ? AND period = ? AND date = ?", userID, period, reportDate(start)).
+		First(&report).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询情绪报告失败: %v", err)
+	}
+	return &report, nil
+}
+
+// ListReports 分页获取孩子某个周期的情绪报告，按日期从新到旧排列
+func (p *EmotionProcessor) ListReports(ctx context.Context, userID uint64, period model.ReportPeriod, page, pageSize int) ([]model.EmotionReport, int64, error) {
+	query := p.db.WithContext(ctx).Model(&model.EmotionReport{}).Where("user_id = ? AND period = ?", userID, period)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询情绪报告失败: %v", err)
+	}
+
+	var reports []model.EmotionReport
+	if err := query.Order("date DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&reports).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询情绪报告失败: %v", err)
+	}
+	return reports, total, nil
+}
+
+// generateSummary 生成情绪总结，生成失败时使用模板总结
+func (p *EmotionProcessor) generateSummary(ctx context.Context, report *model.EmotionReport) *ReportSummary {
+	input := &ReportSummaryInput{
+		Period:       report.Period,
+		Date:         report.Date,
+		ChatCount:    report.ChatCount,
+		Stats:        report.EmotionStats,
+		Timeline:     report.Timeline,
+		Topics:       report.Topics,
+		Quotes:       report.Quotes,
+		Comparison:   report.Comparison,
+		NegativeDays: report.NegativeDays,
+	}
+
+	summary, err := p.summarizer.Summarize(ctx, input)
+	if err == nil {
+		return summary
+	}
+	logs.Error("summarize emotion report error: %v", err)
+
+	summary, _ = NewTemplateReportSummarizer().Summarize(ctx, input)
+	return summary
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestAnalyzeEmotionRedelivery(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	p := NewEmotionProcessor(db, nil, nil, nil, nil, nil)
+
+	asrResult := &model.ASRResult{
+		VoiceMessage: model.VoiceMessage{ID: "msg-1", UserID: "7", SessionID: "session-1", CreatedAt: time.Now()},
+		Text:         "我今天好开心",
+	}
+	// 消息队列重复投递同一条语音消息
+	for i := 0; i < 2; i++ {
+		if err := p.AnalyzeVoiceEmotion(ctx, asrResult); err != nil {
+			t.Fatalf("AnalyzeVoiceEmotion: %v", err)
+		}
+	}
+	// 文字聊天的记录没有消息ID，不受唯一索引限制
+	for chatID := uint64(1); chatID <= 2; chatID++ {
+		if err := p.AnalyzeEmotion(ctx, chatID, 7, "我今天好开心"); err != nil {
+			t.Fatalf("AnalyzeEmotion: %v", err)
+		}
+	}
+
+	var voice, chat int64
+	db.Model(&model.EmotionRecord{}).Where("user_id = ? AND message_id = ?", 7, "msg-1").Count(&voice)
+	db.Model(&model.EmotionRecord{}).Where("user_id = ? AND message_id IS NULL", 7).Count(&chat)
+	if voice != 1 || chat != 2 {
+		t.Errorf("got %d voice records and %d chat records, want 1 and 2", voice, chat)
+	}
+}
+
+func TestGenerateReportRerun(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	p := NewEmotionProcessor(db, nil, nil, nil, nil, nil)
+
+	date := time.Date(2024, 3, 14, 0, 0, 0, 0, time.UTC)
+	if err := db.Create(&model.EmotionRecord{UserID: 7, Emotion: model.EmotionHappy, Confidence: 0.8, CreatedAt: date.Add(9 * time.Hour)}).Error; err != nil {
+		t.Fatalf("create record: %v", err)
+	}
+	// 补生成时同一周期的报告会重复生成
+	for i := 0; i < 2; i++ {
+		if err := p.GenerateReport(ctx, 7, model.ReportDaily, date); err != nil {
+			t.Fatalf("GenerateReport: %v", err)
+		}
+	}
+
+	// 报告由调度器推送，生成报告不写入发件箱，避免没有消费者的消息阻塞发件箱转发
+	var reports, messages int64
+	db.Model(&model.EmotionReport{}).Where("user_id = ?", 7).Count(&reports)
+	db.Model(&model.OutboxMessage{}).Count(&messages)
+	if reports != 1 || messages != 0 {
+		t.Errorf("got %d reports and %d outbox messages, want 1 and 0", reports, messages)
+	}
+}
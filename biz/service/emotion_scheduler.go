@@ -3,120 +3,405 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/apache/rocketmq-client-go/v2"
-	"github.com/apache/rocketmq-client-go/v2/primitive"
 	"github.com/robfig/cron/v3"
 	"gorm.io/gorm"
 
+	"github.com/sweekar/pkg/mq"
 	"sweekar/biz/model"
 )
 
-// EmotionScheduler 情绪报告调度器
+// 报告定时任务名
+const (
+	jobGenerateReports = "generate_reports"
+	jobPushReports     = "push_reports"
+)
+
+const (
+	// schedulerLeaseName 调度器选主使用的租约名
+	schedulerLeaseName = "emotion_scheduler"
+	// maxBackfillReports 一次补生成最多生成的报告数
+	maxBackfillReports = 92
+)
+
+// ErrInvalidBackfill 补生成报告的参数无效
+var ErrInvalidBackfill = errors.New("补生成参数无效")
+
+// BackfillResult 补生成一份报告的结果
+type BackfillResult struct {
+	Date       string `json:"date"`                 // 报告周期的第一天
+	ReportID   uint64 `json:"report_id,omitempty"`  // 生成的报告，需要时用RepushReport推送
+	Suppressed bool   `json:"suppressed,omitempty"` // 报告周期在推送截止日期之前结束，不会自动推送
+	Error      string `json:"error,omitempty"`      // 生成失败的原因
+}
+
+// EmotionScheduler 情绪报告调度器，每分钟按各家庭的时区和时间设置找出需要生成或推送报告的家庭；
+// 生成和推送按家庭和日期只执行一次，中断后从未完成的孩子继续，推送消息经由发件箱发送；
+// 多个实例同时运行时只有选主成功的实例执行定时任务
 type EmotionScheduler struct {
-	db         *gorm.DB
-	mqProducer rocketmq.Producer
-	cron       *cron.Cron
-	processor  *EmotionProcessor
+	db        *gorm.DB
+	cron      *cron.Cron
+	processor *EmotionProcessor
+	families  *FamilyService
+	jobs      *JobRunner
+	outbox    *OutboxRelay
+	leader    *LeaderElection
 }
 
-// NewEmotionScheduler 创建情绪报告调度器
-func NewEmotionScheduler(db *gorm.DB, producer rocketmq.Producer, processor *EmotionProcessor) *EmotionScheduler {
-	scheduler := &EmotionScheduler{
-		db:         db,
-		mqProducer: producer,
-		cron:       cron.New(cron.WithSeconds()),
-		processor:  processor,
+// NewEmotionScheduler 创建情绪报告调度器，推送消息经由bus发送给通知服务，lease为空时使用数据库租约选主
+func NewEmotionScheduler(db *gorm.DB, bus mq.Bus, processor *EmotionProcessor, families *FamilyService, lease Lease) *EmotionScheduler {
+	if lease == nil {
+		lease = NewDBLease(db)
 	}
 
-	// 每天19:00生成情绪报告
-	_, err := scheduler.cron.AddFunc("0 0 19 * * ?", scheduler.generateDailyReports)
-	if err != nil {
-		panic(fmt.Sprintf("添加生成报告定时任务失败: %v", err))
+	scheduler := &EmotionScheduler{
+		db:        db,
+		cron:      cron.New(cron.WithSeconds(), cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger))),
+		processor: processor,
+		families:  families,
+		jobs:      NewJobRunner(db),
+		outbox:    NewOutboxRelay(db, bus),
+		leader:    NewLeaderElection(lease, schedulerLeaseName, 0),
 	}
+	scheduler.jobs.Register(jobGenerateReports, scheduler.generateReports)
+	scheduler.jobs.Register(jobPushReports, scheduler.pushReports)
 
-	// 每天20:00推送情绪报告
-	_, err = scheduler.cron.AddFunc("0 0 20 * * ?", scheduler.pushDailyReports)
+	// 每分钟检查到了报告生成或推送时间的家庭
+	_, err := scheduler.cron.AddFunc("0 * * * * ?", scheduler.tick)
 	if err != nil {
-		panic(fmt.Sprintf("添加推送报告定时任务失败: %v", err))
+		panic(fmt.Sprintf("添加报告定时任务失败: %v", err))
 	}
 
 	return scheduler
 }
 
-// Start 启动调度器
+// Start 启动调度器并参与选主
 func (s *EmotionScheduler) Start() {
+	s.leader.Start()
 	s.cron.Start()
 }
 
-// Stop 停止调度器
+// Stop 停止调度器，等待正在执行的任务结束后释放领导权
 func (s *EmotionScheduler) Stop() {
-	s.cron.Stop()
+	<-s.cron.Stop().Done()
+	s.leader.Stop()
 }
 
-// generateDailyReports 生成所有用户的每日情绪报告
-func (s *EmotionScheduler) generateDailyReports() {
-	// 获取所有有聊天记录的用户
-	var userIDs []uint64
-	today := time.Now().Truncate(24 * time.Hour)
+// tick 由领导者处理到了时间的家庭，继续中断的任务，并发送发件箱中的消息；
+// 执行过程中失去领导权时停止，写入都带有开始时任期的防护令牌
+func (s *EmotionScheduler) tick() {
+	fence, ok := s.leader.Fence()
+	if !ok {
+		return
+	}
 
-	err := s.db.Model(&model.EmotionRecord{}).
-		Where("DATE(created_at) = DATE(?)", today).
-		Distinct().
-		Pluck("user_id", &userIDs).Error
+	ctx := context.Background()
+	s.runDue(ctx, fence, time.Now())
 
+	if !s.leader.Holds(fence) {
+		return
+	}
+	if err := s.jobs.Resume(ctx, fence); err != nil {
+		fmt.Printf("继续报告任务失败: %v\n", err)
+	}
+
+	if !s.leader.Holds(fence) {
+		return
+	}
+	if _, err := s.outbox.Relay(ctx, fence); err != nil {
+		fmt.Printf("发送报告消息失败: %v\n", err)
+	}
+}
+
+// runDue 按各家庭的当地时间生成和推送报告，还没有加入家庭的孩子使用默认设置
+func (s *EmotionScheduler) runDue(ctx context.Context, fence Fence, now time.Time) {
+	paused, err := s.jobs.Paused(ctx)
 	if err != nil {
-		fmt.Printf("获取用户列表失败: %v\n", err)
+		fmt.Printf("获取任务状态失败: %v\n", err)
 		return
 	}
+	if paused[jobGenerateReports] && paused[jobPushReports] {
+		return
+	}
+
+	families, err := s.families.ListFamilies(ctx)
+	if err != nil {
+		fmt.Printf("获取家庭列表失败: %v\n", err)
+		return
+	}
+
+	families = append(families, *model.DefaultFamily())
+	for i := range families {
+		if !s.leader.Holds(fence) {
+			fmt.Printf("已失去领导权，停止处理剩余的 %d 个家庭\n", len(families)-i)
+			return
+		}
+		s.runFamily(ctx, fence, &families[i], now, paused)
+	}
+}
+
+// runFamily 家庭当天到了设置的时间且任务还没有执行过时生成或推送报告
+func (s *EmotionScheduler) runFamily(ctx context.Context, fence Fence, family *model.Family, now time.Time, paused map[string]bool) {
+	local := now.In(family.Location())
+	var jobs []string
+	if !paused[jobGenerateReports] && reportDue(family, local) {
+		jobs = append(jobs, jobGenerateReports)
+	}
+	if !paused[jobPushReports] && pushDue(family, local) {
+		jobs = append(jobs, jobPushReports)
+	}
+
+	scope := fmt.Sprintf("family:%d", family.ID)
+	if family.ID == 0 {
+		scope = "family:default"
+	}
 
-	// 为每个用户生成报告
-	for _, userID := range userIDs {
-		err := s.processor.GenerateDailyReport(context.Background(), userID, today)
+	var userIDs []uint64
+	for _, job := range jobs {
+		started, err := s.jobs.Started(ctx, job, scope, local)
 		if err != nil {
-			fmt.Printf("生成用户 %d 的情绪报告失败: %v\n", userID, err)
+			fmt.Printf("查询家庭 %d 的任务失败: %v\n", family.ID, err)
+			return
+		}
+		if started {
 			continue
 		}
+
+		if userIDs == nil {
+			if userIDs, err = s.familyChildren(ctx, family, local); err != nil {
+				fmt.Printf("获取家庭 %d 的孩子失败: %v\n", family.ID, err)
+				return
+			}
+		}
+		if err := s.jobs.Run(ctx, fence, job, scope, local, userIDs); err != nil {
+			fmt.Printf("家庭 %d 的报告任务执行失败: %v\n", family.ID, err)
+		}
 	}
 }
 
-// pushDailyReports 推送所有用户的每日情绪报告
-func (s *EmotionScheduler) pushDailyReports() {
-	// 获取今天生成但未推送的报告
+// familyChildren 家庭中的孩子；默认设置对应最近两个月有聊天记录、还没有加入家庭且账号未删除的用户
+func (s *EmotionScheduler) familyChildren(ctx context.Context, family *model.Family, local time.Time) ([]uint64, error) {
+	if family.ID != 0 {
+		return s.families.ChildIDs(ctx, family.ID)
+	}
+
+	var userIDs []uint64
+	since, _ := periodRange(model.ReportMonthly, local.AddDate(0, -1, 0))
+	err := s.db.WithContext(ctx).Model(&model.EmotionRecord{}).
+		Where("created_at >= ?", since).
+		Where("user_id NOT IN (?)", s.db.Model(&model.FamilyMember{}).Select("user_id")).
+		Where("user_id NOT IN (?)", s.db.Unscoped().Model(&model.User{}).Where("deleted_at IS NOT NULL").Select("id")).
+		Distinct().
+		Pluck("user_id", &userIDs).Error
+	return userIDs, err
+}
+
+// generateReports 生成孩子当天的日报，周日同时生成周报，每月最后一天同时生成月报
+func (s *EmotionScheduler) generateReports(ctx context.Context, local time.Time, userID uint64) error {
+	periods := []model.ReportPeriod{model.ReportDaily}
+	if local.Weekday() == time.Sunday {
+		periods = append(periods, model.ReportWeekly)
+	}
+	if local.AddDate(0, 0, 1).Day() == 1 {
+		periods = append(periods, model.ReportMonthly)
+	}
+
+	for _, period := range periods {
+		if err := s.processor.GenerateReport(ctx, userID, period, local); err != nil {
+			return fmt.Errorf("生成%s情绪报告失败: %v", period, err)
+		}
+	}
+	return nil
+}
+
+// pushReports 将孩子所有还未推送的报告写入发件箱，推送时间和推送消息在同一事务中更新，已推送的报告不会重复推送
+func (s *EmotionScheduler) pushReports(ctx context.Context, local time.Time, userID uint64) error {
 	var reports []model.EmotionReport
-	today := time.Now().Truncate(24 * time.Hour)
+	err := s.db.WithContext(ctx).Where("user_id = ? AND pushed_at IS NULL", userID).Find(&reports).Error
+	if err != nil {
+		return fmt.Errorf("获取待推送报告失败: %v", err)
+	}
 
-	err := s.db.Where("DATE(date) = DATE(?) AND pushed_at IS NULL", today).Find(&reports).Error
+	for i := range reports {
+		if err := s.pushReport(ctx, &reports[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushReport 标记报告已推送并写入推送消息
+func (s *EmotionScheduler) pushReport(ctx context.Context, report *model.EmotionReport) error {
+	now := time.Now()
+	report.PushedAt = &now
+
+	// 序列化报告数据
+	reportData, err := json.Marshal(report)
 	if err != nil {
-		fmt.Printf("获取待推送报告失败: %v\n", err)
-		return
+		return fmt.Errorf("序列化报告 %d 失败: %v", report.ID, err)
 	}
 
-	// 推送每个报告
-	for _, report := range reports {
-		// 序列化报告数据
-		reportData, err := json.Marshal(report)
-		if err != nil {
-			fmt.Printf("序列化报告 %d 失败: %v\n", report.ID, err)
-			continue
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.EmotionReport{}).
+			Where("id = ? AND pushed_at IS NULL", report.ID).
+			Update("pushed_at", now)
+		if result.Error != nil {
+			return fmt.Errorf("更新报告 %d 推送时间失败: %v", report.ID, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
 		}
 
-		// 发送推送消息
-		msg := primitive.NewMessage("emotion_report_push", reportData)
-		msg.WithKeys([]string{fmt.Sprintf("user_%d", report.UserID)})
+		if err := enqueueOutbox(tx, reportPushTopic, fmt.Sprintf("user_%d", report.UserID), reportData); err != nil {
+			return fmt.Errorf("写入报告 %d 推送消息失败: %v", report.ID, err)
+		}
+		return nil
+	})
+}
 
-		_, err = s.mqProducer.SendSync(context.Background(), msg)
-		if err != nil {
-			fmt.Printf("推送报告 %d 失败: %v\n", report.ID, err)
-			continue
+// Backfill 为孩子补生成startDate到endDate之间各周期的报告，日期为孩子所在家庭的当地日期，格式为YYYY-MM-DD；
+// 已有的报告会被覆盖。周期在pushFrom之前结束的报告不会自动推送，避免家长收到大量历史报告，需要时按返回的报告ID
+// 用RepushReport推送；其余报告照常在推送时间推送。pushFrom为空时为家庭当地的今天
+func (s *EmotionScheduler) Backfill(ctx context.Context, userID uint64, period model.ReportPeriod, startDate, endDate, pushFrom string) ([]BackfillResult, error) {
+	if !period.Valid() {
+		return nil, fmt.Errorf("%w: 报告周期%q无效", ErrInvalidBackfill, period)
+	}
+
+	family, err := s.families.GetFamilyByUser(ctx, userID)
+	if errors.Is(err, ErrFamilyNotFound) {
+		family, err = model.DefaultFamily(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	loc := family.Location()
+
+	start, err := time.ParseInLocation("2006-01-02", startDate, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 开始日期%q格式无效", ErrInvalidBackfill, startDate)
+	}
+	end, err := time.ParseInLocation("2006-01-02", endDate, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 结束日期%q格式无效", ErrInvalidBackfill, endDate)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("%w: 开始日期不能晚于结束日期", ErrInvalidBackfill)
+	}
+	cutoff, _ := periodRange(model.ReportDaily, time.Now().In(loc))
+	if pushFrom != "" {
+		if cutoff, err = time.ParseInLocation("2006-01-02", pushFrom, loc); err != nil {
+			return nil, fmt.Errorf("%w: 推送截止日期%q格式无效", ErrInvalidBackfill, pushFrom)
 		}
+	}
 
-		// 更新推送时间
-		report.PushedAt = time.Now()
-		if err := s.db.Save(&report).Error; err != nil {
-			fmt.Printf("更新报告 %d 推送时间失败: %v\n", report.ID, err)
+	var dates []time.Time
+	for date, _ := periodRange(period, start); !date.After(end); _, date = periodRange(period, date) {
+		if len(dates) == maxBackfillReports {
+			return nil, fmt.Errorf("%w: 一次最多补生成%d份报告", ErrInvalidBackfill, maxBackfillReports)
 		}
+		dates = append(dates, date)
 	}
-}
\ No newline at end of file
+
+	results := make([]BackfillResult, 0, len(dates))
+	var generated, suppressed []time.Time
+	for _, date := range dates {
+		result := BackfillResult{Date: date.Format("2006-01-02")}
+		if err := s.processor.GenerateReport(ctx, userID, period, date); err != nil {
+			result.Error = err.Error()
+		} else {
+			generated = append(generated, reportDate(date))
+			if _, periodEnd := periodRange(period, date); !periodEnd.After(cutoff) {
+				result.Suppressed = true
+				suppressed = append(suppressed, reportDate(date))
+			}
+		}
+		results = append(results, result)
+	}
+	if len(generated) == 0 {
+		return results, nil
+	}
+
+	if len(suppressed) > 0 {
+		err = s.db.WithContext(ctx).Model(&model.EmotionReport{}).
+			Where("user_id = ? AND period = ? AND date IN ? AND pushed_at IS NULL", userID, period, suppressed).
+			Update("pushed_at", time.Now()).Error
+		if err != nil {
+			return nil, fmt.Errorf("更新补生成报告的推送时间失败: %v", err)
+		}
+	}
+
+	var reports []model.EmotionReport
+	err = s.db.WithContext(ctx).Select("id", "date").
+		Where("user_id = ? AND period = ? AND date IN ?", userID, period, generated).
+		Find(&reports).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询补生成的报告失败: %v", err)
+	}
+	ids := make(map[string]uint64, len(reports))
+	for _, report := range reports {
+		ids[report.Date.Format("2006-01-02")] = report.ID
+	}
+	for i := range results {
+		// 周期内没有聊天记录时不会生成报告
+		results[i].ReportID = ids[results[i].Date]
+		results[i].Suppressed = results[i].Suppressed && results[i].ReportID != 0
+	}
+	return results, nil
+}
+
+// RepushReport 重新推送一份报告，推送消息写入发件箱后由领导者发送
+func (s *EmotionScheduler) RepushReport(ctx context.Context, reportID uint64) error {
+	var report model.EmotionReport
+	err := s.db.WithContext(ctx).First(&report, reportID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrReportNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("查询情绪报告失败: %v", err)
+	}
+
+	now := time.Now()
+	report.PushedAt = &now
+	reportData, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("序列化报告 %d 失败: %v", report.ID, err)
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&report).Update("pushed_at", now).Error; err != nil {
+			return fmt.Errorf("更新报告 %d 推送时间失败: %v", report.ID, err)
+		}
+		if err := enqueueOutbox(tx, reportPushTopic, fmt.Sprintf("user_%d", report.UserID), reportData); err != nil {
+			return fmt.Errorf("写入报告 %d 推送消息失败: %v", report.ID, err)
+		}
+		return nil
+	})
+}
+
+// Jobs 获取调度器的任务及其是否暂停
+func (s *EmotionScheduler) Jobs(ctx context.Context) ([]model.JobControl, error) {
+	return s.jobs.Jobs(ctx)
+}
+
+// PauseJob 暂停任务
+func (s *EmotionScheduler) PauseJob(ctx context.Context, job string, operatorID uint64) error {
+	return s.jobs.SetPaused(ctx, job, true, operatorID)
+}
+
+// ResumeJob 恢复暂停的任务，当天已过设置时间的家庭会在下一次调度时补执行
+func (s *EmotionScheduler) ResumeJob(ctx context.Context, job string, operatorID uint64) error {
+	return s.jobs.SetPaused(ctx, job, false, operatorID)
+}
+
+// ListJobRuns 分页获取任务执行记录
+func (s *EmotionScheduler) ListJobRuns(ctx context.Context, job string, status model.JobStatus, page, pageSize int) ([]model.JobRun, int64, error) {
+	return s.jobs.ListRuns(ctx, job, status, page, pageSize)
+}
+
+// RetryJobRun 重试已达到最大执行次数的失败任务
+func (s *EmotionScheduler) RetryJobRun(ctx context.Context, runID uint64) error {
+	return s.jobs.Retry(ctx, runID)
+}
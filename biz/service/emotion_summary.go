@@ -0,0 +1,408 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// maxTopics 报告中的话题数
+	maxTopics = 3
+	// maxQuotes 报告中引用孩子原话的条数
+	maxQuotes = 3
+	// maxQuoteRunes 引用的原话最长字数
+	maxQuoteRunes = 40
+	// minShiftConfidence 置信度低于该值的情绪不计入时间线
+	minShiftConfidence = 0.4
+)
+
+// topicKeywords 儿童常聊话题的关键词
+var topicKeywords = map[string][]string{
+	"动物":    {"小狗", "小猫", "兔子", "小熊", "恐龙", "小鸟", "小鱼", "大象", "老虎", "狮子", "猴子", "动物园"},
+	"玩具":    {"玩具", "积木", "娃娃", "小汽车", "乐高", "拼图", "皮球", "奥特曼"},
+	"家人":    {"妈妈", "爸爸", "爷爷", "奶奶", "外公", "外婆", "哥哥", "姐姐", "弟弟", "妹妹"},
+	"幼儿园":   {"幼儿园", "老师", "小朋友", "同学", "上学", "班上"},
+	"吃的":    {"吃", "饭", "蛋糕", "水果", "糖", "冰淇淋", "饼干", "零食"},
+	"户外玩耍":  {"公园", "滑梯", "秋千", "游乐场", "出去玩", "沙子", "骑车"},
+	"故事和动画": {"故事", "绘本", "动画片", "电视", "唱歌", "儿歌"},
+	"身体不舒服": {"疼", "生病", "医院", "打针", "吃药", "发烧", "咳嗽"},
+	"睡觉":    {"睡觉", "做梦", "噩梦", "午睡", "困了"},
+}
+
+// talkingPointsByEmotion 出现负面情绪时给家长的沟通建议，%s为报告周期的说法
+var talkingPointsByEmotion = map[model.EmotionType]string{
+	model.EmotionSad:         "孩子%s有些难过，可以抱抱孩子，问问孩子有没有遇到不开心的事",
+	model.EmotionAngry:       "孩子%s有生气的时候，可以和孩子聊聊生气时可以怎么做，比如深呼吸或者告诉大人",
+	model.EmotionFear:        "孩子%s表达过害怕，睡前可以陪孩子聊聊害怕的东西，让孩子知道爸爸妈妈一直都在身边",
+	model.EmotionAnxiety:     "孩子%s有些担心，可以问问孩子在担心什么，帮孩子把担心的事情说出来",
+	model.EmotionLoneliness:  "孩子%s提到孤单或想念家人，今晚可以多留一些陪伴时间，一起做孩子喜欢的事",
+	model.EmotionFrustration: "孩子%s遇到了做不好的事情，可以肯定孩子的努力，陪孩子再试一次",
+	model.EmotionBoredom:     "孩子%s说过无聊，可以和孩子一起想想接下来想玩什么",
+}
+
+// ReportSummaryInput 生成报告总结所需的数据
+type ReportSummaryInput struct {
+	Period       model.ReportPeriod
+	Date         time.Time
+	ChatCount    int
+	Stats        model.EmotionStats
+	Timeline     []model.EmotionShift
+	Topics       []string
+	Quotes       []model.EmotionQuote
+	Comparison   *model.PeriodComparison
+	NegativeDays []model.NegativeDay
+}
+
+// ReportSummary 报告总结和给家长的沟通建议
+type ReportSummary struct {
+	Summary       string   `json:"summary"`
+	TalkingPoints []string `json:"talking_points"`
+}
+
+// ReportSummarizer 情绪报告总结生成器
+type ReportSummarizer interface {
+	Summarize(ctx context.Context, input *ReportSummaryInput) (*ReportSummary, error)
+}
+
+// TemplateReportSummarizer 基于模板和规则的总结生成器
+type TemplateReportSummarizer struct{}
+
+// NewTemplateReportSummarizer 创建模板总结生成器
+func NewTemplateReportSummarizer() *TemplateReportSummarizer {
+	return &TemplateReportSummarizer{}
+}
+
+// Summarize 根据情绪统计、时间线、话题、原话和与上一周期的对比生成总结
+func (s *TemplateReportSummarizer) Summarize(ctx context.Context, input *ReportSummaryInput) (*ReportSummary, error) {
+	period := input.Period.Label()
+	ranked := rankEmotions(input.Stats)
+	if len(ranked) == 0 {
+		return &ReportSummary{Summary: period + "孩子没有和小伙伴聊天。"}, nil
+	}
+
+	total := 0
+	negative := 0
+	for emotion, count := range input.Stats {
+		total += count
+		if emotion.IsNegative() {
+			negative += count
+		}
+	}
+
+	var b strings.Builder
+	top := ranked[0]
+	fmt.Fprintf(&b, "%s孩子和小伙伴聊了%d次，大多数时候是%s的（约占%d%%）。",
+		period, input.ChatCount, top.Label(), input.Stats[top]*100/total)
+
+	// 其他出现较多的情绪
+	var others []string
+	for _, emotion := range ranked[1:] {
+		if input.Stats[emotion]*5 >= total {
+			others = append(others, emotion.Label())
+		}
+	}
+	if len(others) > 0 {
+		fmt.Fprintf(&b, "也有%s的时候。", strings.Join(others, "、"))
+	}
+
+	// 情绪的主要变化
+	if len(input.Timeline) > 0 {
+		first, last := input.Timeline[0], input.Timeline[len(input.Timeline)-1]
+		if first.From != last.To {
+			fmt.Fprintf(&b, "情绪从%s的%s变成了%s的%s。",
+				timeOfDay(first.Time), first.From.Label(), timeOfDay(last.Time), last.To.Label())
+		}
+	}
+
+	if len(input.Topics) > 0 {
+		fmt.Fprintf(&b, "聊得最多的是%s。", strings.Join(input.Topics, "、"))
+	}
+
+	// 与上一周期相比变化最明显的情绪
+	if input.Comparison != nil {
+		var changes []string
+		for _, change := range input.Comparison.Changes {
+			if len(changes) == 2 || math.Abs(change.Change) < minEmotionChange {
+				break
+			}
+			direction := "多"
+			if change.Change < 0 {
+				direction = "少"
+			}
+			changes = append(changes, fmt.Sprintf("%s的时候%s了%d%%", change.Emotion.Label(), direction, int(math.Round(math.Abs(change.Change)*100))))
+		}
+		if len(changes) > 0 {
+			fmt.Fprintf(&b, "和%s相比，%s。", input.Period.PreviousLabel(), strings.Join(changes, "，"))
+		}
+	}
+
+	if len(input.NegativeDays) > 0 {
+		days := make([]string, 0, len(input.NegativeDays))
+		for _, day := range input.NegativeDays {
+			days = append(days, day.Date.Format("1月2日"))
+		}
+		fmt.Fprintf(&b, "%s孩子的负面情绪明显比平时多。", strings.Join(days, "、"))
+	}
+
+	switch {
+	case negative == 0:
+		b.WriteString("整体心情很好。")
+	case negative*10 >= total*3:
+		fmt.Fprintf(&b, "%s负面情绪偏多，建议多关注孩子的感受。", period)
+	}
+
+	return &ReportSummary{
+		Summary:       b.String(),
+		TalkingPoints: templateTalkingPoints(input, ranked),
+	}, nil
+}
+
+// templateTalkingPoints 根据负面情绪、孩子的原话和话题生成沟通建议
+func templateTalkingPoints(input *ReportSummaryInput, ranked []model.EmotionType) []string {
+	var points []string
+	for _, emotion := range ranked {
+		if point, ok := talkingPointsByEmotion[emotion]; ok && emotion.IsNegative() {
+			points = append(points, fmt.Sprintf(point, input.Period.Label()))
+		}
+	}
+	for _, quote := range input.Quotes {
+		if quote.Emotion.IsNegative() {
+			points = append(points, fmt.Sprintf("孩子说过“%s”，可以温和地问问当时发生了什么", quote.Text))
+			break
+		}
+	}
+	if len(input.NegativeDays) > 0 {
+		day := input.NegativeDays[0]
+		points = append(points, fmt.Sprintf("%s孩子%s的时候比较多，可以回想一下那天发生了什么，找机会和孩子聊聊", day.Date.Format("1月2日"), day.TopEmotion.Label()))
+	}
+	if len(input.Topics) > 0 {
+		points = append(points, fmt.Sprintf("孩子%s对%s很感兴趣，可以顺着这个话题和孩子多聊聊", input.Period.Label(), input.Topics[0]))
+	}
+	if len(points) > 3 {
+		points = points[:3]
+	}
+	return points
+}
+
+// LLMReportSummarizer 使用大模型生成面向家长的总结，生成失败时使用模板总结
+type LLMReportSummarizer struct {
+	llm      ChatModel
+	fallback *TemplateReportSummarizer
+}
+
+// NewLLMReportSummarizer 创建大模型总结生成器
+func NewLLMReportSummarizer(llm ChatModel) *LLMReportSummarizer {
+	return &LLMReportSummarizer{
+		llm:      llm,
+		fallback: NewTemplateReportSummarizer(),
+	}
+}
+
+// Summarize 生成总结和沟通建议
+func (s *LLMReportSummarizer) Summarize(ctx context.Context, input *ReportSummaryInput) (*ReportSummary, error) {
+	if len(input.Stats) == 0 {
+		return s.fallback.Summarize(ctx, input)
+	}
+
+	data, err := json.Marshal(reportPromptData(input))
+	if err != nil {
+		return nil, fmt.Errorf("marshal report data error: %v", err)
+	}
+
+	result, err := s.llm.Chat(ctx, []PromptMessage{
+		{
+			Role: PromptRoleSystem,
+			Content: "你是一位温和的儿童心理顾问。下面是一位3到6岁孩子" + input.Period.Label() + "和陪伴角色聊天时的情绪数据，包括各情绪出现的次数、情绪变化、话题和孩子的原话，周报和月报还包括与上一周期的对比和负面情绪偏多的日子。" +
+				"请用亲切、具体、不制造焦虑的语气给家长写一段不超过150字的总结，并给出2到3条可以和孩子聊的建议。" +
+				`只输出JSON：{"summary":"总结","talking_points":["建议"]}。`,
+		},
+		{Role: PromptRoleUser, Content: string(data)},
+	})
+	if err != nil {
+		return s.fallback.Summarize(ctx, input)
+	}
+
+	// 兼容模型在JSON前后附加的说明文字
+	var summary ReportSummary
+	start, end := strings.Index(result, "{"), strings.LastIndex(result, "}")
+	if start < 0 || end < start || json.Unmarshal([]byte(result[start:end+1]), &summary) != nil || summary.Summary == "" {
+		return s.fallback.Summarize(ctx, input)
+	}
+	return &summary, nil
+}
+
+// reportPromptData 将报告数据整理为大模型易读的格式
+func reportPromptData(input *ReportSummaryInput) map[string]interface{} {
+	stats := make(map[string]int, len(input.Stats))
+	for emotion, count := range input.Stats {
+		stats[emotion.Label()] = count
+	}
+
+	timeline := make([]string, 0, len(input.Timeline))
+	for _, shift := range input.Timeline {
+		timeline = append(timeline, fmt.Sprintf("%s %s→%s", shift.Time.Format("15:04"), shift.From.Label(), shift.To.Label()))
+	}
+
+	quotes := make([]string, 0, len(input.Quotes))
+	for _, quote := range input.Quotes {
+		quotes = append(quotes, fmt.Sprintf("%s（%s）", quote.Text, quote.Emotion.Label()))
+	}
+
+	data := map[string]interface{}{
+		"日期":   input.Date.Format("2006-01-02"),
+		"聊天次数": input.ChatCount,
+		"情绪统计": stats,
+		"情绪变化": timeline,
+		"话题":   input.Topics,
+		"孩子原话": quotes,
+	}
+
+	if input.Comparison != nil {
+		changes := make(map[string]string, len(input.Comparison.Changes))
+		for _, change := range input.Comparison.Changes {
+			changes[change.Emotion.Label()] = fmt.Sprintf("%+.0f%%", change.Change*100)
+		}
+		data["与"+input.Period.PreviousLabel()+"相比的占比变化"] = changes
+	}
+	if len(input.NegativeDays) > 0 {
+		days := make([]string, 0, len(input.NegativeDays))
+		for _, day := range input.NegativeDays {
+			days = append(days, fmt.Sprintf("%s（%s，负面情绪占%.0f%%）", day.Date.Format("2006-01-02"), day.TopEmotion.Label(), day.NegativeShare*100))
+		}
+		data["负面情绪偏多的日子"] = days
+	}
+	return data
+}
+
+// rankEmotions 按出现次数从多到少排列情绪
+func rankEmotions(stats model.EmotionStats) []model.EmotionType {
+	ranked := make([]model.EmotionType, 0, len(stats))
+	for _, emotion := range model.EmotionTypes {
+		if stats[emotion] > 0 {
+			ranked = append(ranked, emotion)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return stats[ranked[i]] > stats[ranked[j]]
+	})
+	return ranked
+}
+
+// buildEmotionTimeline 按时间顺序找出情绪变化，忽略置信度较低的记录
+func buildEmotionTimeline(records []model.EmotionRecord) []model.EmotionShift {
+	sorted := append([]model.EmotionRecord(nil), records...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	var timeline []model.EmotionShift
+	var current model.EmotionType
+	for _, record := range sorted {
+		if record.Confidence < minShiftConfidence {
+			continue
+		}
+		if current != "" && record.Emotion != current {
+			timeline = append(timeline, model.EmotionShift{
+				Time: record.CreatedAt,
+				From: current,
+				To:   record.Emotion,
+			})
+		}
+		current = record.Emotion
+	}
+	return timeline
+}
+
+// extractTopics 统计孩子说的话中出现最多的话题
+func extractTopics(messages []*model.ChatMessage) []string {
+	counts := make(map[string]int)
+	for _, msg := range messages {
+		for topic, keywords := range topicKeywords {
+			for _, keyword := range keywords {
+				if strings.Contains(msg.Content, keyword) {
+					counts[topic]++
+					break
+				}
+			}
+		}
+	}
+
+	topics := make([]string, 0, len(counts))
+	for topic := range counts {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		if counts[topics[i]] != counts[topics[j]] {
+			return counts[topics[i]] > counts[topics[j]]
+		}
+		return topics[i] < topics[j]
+	})
+	if len(topics) > maxTopics {
+		topics = topics[:maxTopics]
+	}
+	return topics
+}
+
+// selectQuotes 选出情绪最强烈的几句孩子原话，情绪记录和聊天记录通过语音消息ID关联
+func selectQuotes(records []model.EmotionRecord, messages []*model.ChatMessage) []model.EmotionQuote {
+	texts := make(map[string]string, len(messages))
+	for _, msg := range messages {
+		if msg.MessageID != "" {
+			texts[msg.MessageID] = msg.Content
+		}
+	}
+
+	var quotes []model.EmotionQuote
+	for _, record := range records {
+		if record.MessageID == nil || record.Emotion == model.EmotionNeutral {
+			continue
+		}
+		text, ok := texts[*record.MessageID]
+		if !ok {
+			continue
+		}
+		if runes := []rune(text); len(runes) > maxQuoteRunes {
+			text = string(runes[:maxQuoteRunes]) + "…"
+		}
+		quotes = append(quotes, model.EmotionQuote{
+			Time:      record.CreatedAt,
+			Text:      text,
+			Emotion:   record.Emotion,
+			Intensity: record.Intensity,
+		})
+	}
+
+	sort.SliceStable(quotes, func(i, j int) bool {
+		return quotes[i].Intensity > quotes[j].Intensity
+	})
+	if len(quotes) > maxQuotes {
+		quotes = quotes[:maxQuotes]
+	}
+	sort.Slice(quotes, func(i, j int) bool {
+		return quotes[i].Time.Before(quotes[j].Time)
+	})
+	return quotes
+}
+
+// timeOfDay 时间所在的时段
+func timeOfDay(t time.Time) string {
+	switch h := t.Hour(); {
+	case h < 6:
+		return "凌晨"
+	case h < 12:
+		return "上午"
+	case h < 14:
+		return "中午"
+	case h < 18:
+		return "下午"
+	default:
+		return "晚上"
+	}
+}
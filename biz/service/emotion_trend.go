@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+// ErrInvalidTrendQuery 情绪趋势的查询参数无效
+var ErrInvalidTrendQuery = errors.New("趋势查询参数无效")
+
+// maxTrendBuckets 一次趋势查询最多返回的时间段数，按小时统计时约为一个月
+const maxTrendBuckets = 744
+
+// defaultTrendSpans 未指定开始时间时各粒度默认查询的时长
+var defaultTrendSpans = map[model.TrendGranularity]func(end time.Time) time.Time{
+	model.TrendHourly: func(end time.Time) time.Time { return end.Add(-24 * time.Hour) },
+	model.TrendDaily:  func(end time.Time) time.Time { return end.AddDate(0, 0, -30) },
+	model.TrendWeekly: func(end time.Time) time.Time { return end.AddDate(0, 0, -7*12) },
+}
+
+// TrendQuery 情绪趋势查询，Start和End已按Location对齐到时间段边界
+type TrendQuery struct {
+	Start       time.Time
+	End         time.Time
+	Granularity model.TrendGranularity
+	Location    *time.Location
+}
+
+// ParseTrendQuery 解析趋势查询参数。时间支持RFC3339和YYYY-MM-DD两种格式，日期按timezone解析且结束日期包含当天；
+// granularity为空时按天统计，timezone为空时使用loc（通常为孩子所在家庭的时区），startTime为空时按粒度取默认时长，endTime为空时截止到现在
+func ParseTrendQuery(startTime, endTime, granularity, timezone string, loc *time.Location, now time.Time) (*TrendQuery, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	if timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("%w: 时区%q无效", ErrInvalidTrendQuery, timezone)
+		}
+	}
+
+	query := &TrendQuery{
+		Granularity: model.TrendGranularity(granularity),
+		Location:    loc,
+	}
+	if query.Granularity == "" {
+		query.Granularity = model.TrendDaily
+	}
+	if _, ok := defaultTrendSpans[query.Granularity]; !ok {
+		return nil, fmt.Errorf("%w: 统计粒度%q无效，可选hour、day、week", ErrInvalidTrendQuery, granularity)
+	}
+
+	end := now.In(loc)
+	if endTime != "" {
+		t, dateOnly, err := parseTrendTime(endTime, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 结束时间%q格式无效", ErrInvalidTrendQuery, endTime)
+		}
+		if end = t; dateOnly {
+			end = t.AddDate(0, 0, 1)
+		}
+	}
+
+	start := defaultTrendSpans[query.Granularity](end)
+	if startTime != "" {
+		t, _, err := parseTrendTime(startTime, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 开始时间%q格式无效", ErrInvalidTrendQuery, startTime)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("%w: 开始时间必须早于结束时间", ErrInvalidTrendQuery)
+	}
+
+	// 对齐到时间段边界，结束时间不在边界上时包含它所在的整个时间段
+	query.Start = trendBucketStart(query.Granularity, start)
+	query.End = trendBucketStart(query.Granularity, end)
+	if query.End.Before(end) {
+		query.End = nextTrendBucket(query.Granularity, query.End)
+	}
+
+	buckets := 0
+	for t := query.Start; t.Before(query.End); t = nextTrendBucket(query.Granularity, t) {
+		if buckets++; buckets > maxTrendBuckets {
+			return nil, fmt.Errorf("%w: 时间范围过大，最多返回%d个时间段", ErrInvalidTrendQuery, maxTrendBuckets)
+		}
+	}
+	return query, nil
+}
+
+// parseTrendTime 解析RFC3339时间或YYYY-MM-DD日期，返回的bool表示是否只有日期
+func parseTrendTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), false, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	return t, true, err
+}
+
+// trendBucketStart 时间所在时间段的开始时间
+func trendBucketStart(granularity model.TrendGranularity, t time.Time) time.Time {
+	switch granularity {
+	case model.TrendHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case model.TrendWeekly:
+		start, _ := periodRange(model.ReportWeekly, t)
+		return start
+	default:
+		start, _ := periodRange(model.ReportDaily, t)
+		return start
+	}
+}
+
+// nextTrendBucket 下一个时间段的开始时间
+func nextTrendBucket(granularity model.TrendGranularity, start time.Time) time.Time {
+	switch granularity {
+	case model.TrendHourly:
+		return start.Add(time.Hour)
+	case model.TrendWeekly:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// GetEmotionTrend 按时间段统计孩子的情绪分布和按置信度加权的平均值
+func (p *EmotionProcessor) GetEmotionTrend(ctx context.Context, userID uint64, query *TrendQuery) (*model.EmotionTrend, error) {
+	var records []model.EmotionRecord
+	if err := p.db.WithContext(ctx).Select("emotion", "confidence", "intensity", "scores", "created_at").
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, query.Start, query.End).
+		Order("created_at").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("查询情绪记录失败: %v", err)
+	}
+
+	return &model.EmotionTrend{
+		Start:       query.Start,
+		End:         query.End,
+		Granularity: query.Granularity,
+		Timezone:    query.Location.String(),
+		Buckets:     bucketEmotions(records, query),
+	}, nil
+}
+
+// bucketEmotions 将情绪记录按时间段汇总
+func bucketEmotions(records []model.EmotionRecord, query *TrendQuery) []model.EmotionTrendBucket {
+	var buckets []model.EmotionTrendBucket
+	for t := query.Start; t.Before(query.End); t = nextTrendBucket(query.Granularity, t) {
+		buckets = append(buckets, model.EmotionTrendBucket{
+			Start: t,
+			End:   nextTrendBucket(query.Granularity, t),
+			Stats: make(model.EmotionStats),
+		})
+	}
+
+	weights := make([]float64, len(buckets))
+	distributions := make([]map[model.EmotionType]float64, len(buckets))
+	for _, record := range records {
+		i := sort.Search(len(buckets), func(i int) bool {
+			return buckets[i].End.After(record.CreatedAt)
+		})
+		if i == len(buckets) || record.CreatedAt.Before(buckets[i].Start) {
+			continue
+		}
+
+		bucket := &buckets[i]
+		bucket.Count++
+		bucket.Stats[record.Emotion]++
+		bucket.Confidence += record.Confidence
+		bucket.Intensity += record.Confidence * record.Intensity
+		weights[i] += record.Confidence
+
+		// 旧记录没有概率分布，按识别出的情绪计算
+		scores := record.Scores
+		if len(scores) == 0 {
+			scores = map[model.EmotionType]float64{record.Emotion: 1}
+		}
+		if distributions[i] == nil {
+			distributions[i] = make(map[model.EmotionType]float64)
+		}
+		for emotion, score := range scores {
+			distributions[i][emotion] += record.Confidence * score
+		}
+	}
+
+	for i := range buckets {
+		bucket := &buckets[i]
+		bucket.BasicStats = bucket.Stats.Basic()
+		bucket.Distribution = make(map[model.EmotionType]float64)
+		bucket.BasicDistribution = make(map[model.EmotionType]float64)
+		if bucket.Count == 0 {
+			continue
+		}
+		bucket.Confidence /= float64(bucket.Count)
+		if weights[i] == 0 {
+			bucket.Intensity = 0
+			continue
+		}
+		bucket.Intensity /= weights[i]
+
+		total := 0.0
+		for _, score := range distributions[i] {
+			total += score
+		}
+		if total == 0 {
+			// 记录的概率分布全为0时没有可用的分布
+			continue
+		}
+		for emotion, score := range distributions[i] {
+			share := score / total
+			bucket.Distribution[emotion] = share
+			bucket.BasicDistribution[emotion.Basic()] += share
+			if emotion.IsNegative() {
+				bucket.NegativeShare += share
+			}
+		}
+		for _, emotion := range model.EmotionTypes {
+			if share := bucket.Distribution[emotion]; share > 0 && share > bucket.Distribution[bucket.Dominant] {
+				bucket.Dominant = emotion
+			}
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestParseTrendQuery(t *testing.T) {
+	now := time.Date(2024, 3, 14, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		start       string
+		end         string
+		granularity string
+		timezone    string
+		loc         *time.Location // 未指定时区时使用的时区，为nil时使用UTC
+		wantStart   time.Time
+		wantEnd     time.Time
+		wantGran    model.TrendGranularity
+		wantErr     bool
+	}{
+		{
+			name:      "默认按天统计最近30天",
+			timezone:  "UTC",
+			wantStart: time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+			wantGran:  model.TrendDaily,
+		},
+		{
+			name:        "按小时统计最近24小时",
+			granularity: "hour",
+			timezone:    "UTC",
+			wantStart:   time.Date(2024, 3, 13, 10, 0, 0, 0, time.UTC),
+			wantEnd:     time.Date(2024, 3, 14, 11, 0, 0, 0, time.UTC),
+			wantGran:    model.TrendHourly,
+		},
+		{
+			name:        "按周统计对齐到周一",
+			start:       "2024-03-06",
+			end:         "2024-03-14",
+			granularity: "week",
+			timezone:    "UTC",
+			wantStart:   time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+			wantEnd:     time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC),
+			wantGran:    model.TrendWeekly,
+		},
+		{
+			name:      "日期按时区解析且结束日期包含当天",
+			start:     "2024-03-01",
+			end:       "2024-03-07",
+			timezone:  "Asia/Shanghai",
+			wantStart: time.Date(2024, 2, 29, 16, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 3, 7, 16, 0, 0, 0, time.UTC),
+			wantGran:  model.TrendDaily,
+		},
+		{
+			name:      "RFC3339时间",
+			start:     "2024-03-01T12:00:00Z",
+			end:       "2024-03-03T00:00:00Z",
+			timezone:  "UTC",
+			wantStart: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC),
+			wantGran:  model.TrendDaily,
+		},
+		{name: "无效的粒度", granularity: "minute", wantErr: true},
+		{name: "无效的时区", timezone: "Mars/Base", wantErr: true},
+		{
+			name:      "未指定时区时使用家庭时区",
+			loc:       time.FixedZone("UTC+8", 8*60*60),
+			wantStart: time.Date(2024, 2, 12, 16, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2024, 3, 14, 16, 0, 0, 0, time.UTC),
+			wantGran:  model.TrendDaily,
+		},
+		{name: "无效的时间", start: "yesterday", wantErr: true},
+		{name: "开始时间晚于结束时间", start: "2024-03-10", end: "2024-03-01", timezone: "UTC", wantErr: true},
+		{name: "时间段过多", start: "2024-01-01", end: "2024-03-01", granularity: "hour", timezone: "UTC", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc := tt.loc
+			if loc == nil {
+				loc = time.UTC
+			}
+			query, err := ParseTrendQuery(tt.start, tt.end, tt.granularity, tt.timezone, loc, now)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidTrendQuery) {
+					t.Fatalf("err = %v, want ErrInvalidTrendQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTrendQuery: %v", err)
+			}
+			if !query.Start.Equal(tt.wantStart) || !query.End.Equal(tt.wantEnd) {
+				t.Errorf("range = [%v, %v), want [%v, %v)", query.Start, query.End, tt.wantStart, tt.wantEnd)
+			}
+			if query.Granularity != tt.wantGran {
+				t.Errorf("granularity = %s, want %s", query.Granularity, tt.wantGran)
+			}
+		})
+	}
+}
+
+func TestBucketEmotions(t *testing.T) {
+	day := func(d, hour int) time.Time {
+		return time.Date(2024, 3, d, hour, 0, 0, 0, time.UTC)
+	}
+	query := &TrendQuery{
+		Start:       day(1, 0),
+		End:         day(4, 0),
+		Granularity: model.TrendDaily,
+		Location:    time.UTC,
+	}
+	records := []model.EmotionRecord{
+		{Emotion: model.EmotionHappy, Confidence: 1, Intensity: 0.8, CreatedAt: day(1, 9)},
+		{Emotion: model.EmotionSad, Confidence: 0.5, Intensity: 0.2, CreatedAt: day(1, 20),
+			Scores: map[model.EmotionType]float64{model.EmotionSad: 0.6, model.EmotionNeutral: 0.4}},
+		{Emotion: model.EmotionFear, Confidence: 0.8, Intensity: 0.5, CreatedAt: day(3, 23)},
+		{Emotion: model.EmotionHappy, Confidence: 1, Intensity: 1, CreatedAt: day(4, 0)}, // 超出查询范围
+	}
+
+	buckets := bucketEmotions(records, query)
+	if len(buckets) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(buckets))
+	}
+
+	approx := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	tests := []struct {
+		name      string
+		bucket    model.EmotionTrendBucket
+		count     int
+		intensity float64
+		negative  float64
+		dominant  model.EmotionType
+	}{
+		{name: "两条记录", bucket: buckets[0], count: 2, intensity: (0.8 + 0.5*0.2) / 1.5, negative: 0.3 / 1.5, dominant: model.EmotionHappy},
+		{name: "没有记录", bucket: buckets[1], count: 0},
+		{name: "细分的负面情绪", bucket: buckets[2], count: 1, intensity: 0.5, negative: 1, dominant: model.EmotionFear},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.bucket
+			if !b.Start.Equal(day(1+i, 0)) || !b.End.Equal(day(2+i, 0)) {
+				t.Errorf("range = [%v, %v)", b.Start, b.End)
+			}
+			if b.Count != tt.count {
+				t.Errorf("count = %d, want %d", b.Count, tt.count)
+			}
+			if !approx(b.Intensity, tt.intensity) {
+				t.Errorf("intensity = %v, want %v", b.Intensity, tt.intensity)
+			}
+			if !approx(b.NegativeShare, tt.negative) {
+				t.Errorf("negative share = %v, want %v", b.NegativeShare, tt.negative)
+			}
+			if b.Dominant != tt.dominant {
+				t.Errorf("dominant = %q, want %q", b.Dominant, tt.dominant)
+			}
+			total := 0.0
+			for _, share := range b.Distribution {
+				total += share
+			}
+			if tt.count > 0 && !approx(total, 1) {
+				t.Errorf("distribution sums to %v, want 1", total)
+			}
+		})
+	}
+
+	if got := buckets[2].BasicStats[model.EmotionSad]; got != 1 {
+		t.Errorf("fear counted as %d sad in basic stats, want 1", got)
+	}
+}
+
+func TestBucketEmotionsZeroScores(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	query := &TrendQuery{Start: start, End: start.AddDate(0, 0, 1), Granularity: model.TrendDaily, Location: time.UTC}
+	records := []model.EmotionRecord{
+		{Emotion: model.EmotionSad, Confidence: 0.6, Intensity: 0.5, CreatedAt: start.Add(9 * time.Hour),
+			Scores: map[model.EmotionType]float64{model.EmotionSad: 0, model.EmotionNeutral: 0}},
+	}
+
+	buckets := bucketEmotions(records, query)
+	if len(buckets) != 1 {
+		t.Fatalf("got %d buckets, want 1", len(buckets))
+	}
+	b := buckets[0]
+	if b.Count != 1 || math.IsNaN(b.NegativeShare) || len(b.Distribution) != 0 {
+		t.Errorf("bucket = count %d, negative share %v, distribution %v, want one record without a distribution", b.Count, b.NegativeShare, b.Distribution)
+	}
+}
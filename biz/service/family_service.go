@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+var (
+	// ErrFamilyNotFound 用户还没有加入家庭
+	ErrFamilyNotFound = errors.New("家庭不存在")
+	// ErrInvalidFamilySettings 家庭设置无效
+	ErrInvalidFamilySettings = errors.New("家庭设置无效")
+	// ErrReadOnlyGuardian 只能查看报告的监护人不能进行管理操作
+	ErrReadOnlyGuardian = errors.New("只有拥有完全管理权限的监护人可以进行该操作")
+)
+
+// FamilySettings 家庭的报告时间设置
+type FamilySettings struct {
+	Timezone   string `json:"timezone"`    // IANA时区，如Asia/Shanghai
+	ReportTime string `json:"report_time"` // 每天生成情绪报告的时间，HH:MM
+	PushTime   string `json:"push_time"`   // 每天推送情绪报告的时间，HH:MM
+	QuietStart string `json:"quiet_start"` // 免打扰开始时间，HH:MM，和QuietEnd同时为空表示不设置
+	QuietEnd   string `json:"quiet_end"`   // 免打扰结束时间，HH:MM
+}
+
+// FamilyService 家庭服务
+type FamilyService struct {
+	db *gorm.DB
+}
+
+// NewFamilyService 创建家庭服务
+func NewFamilyService(db *gorm.DB) *FamilyService {
+	return &FamilyService{db: db}
+}
+
+// WithTx 返回在tx所在事务中执行的家庭服务
+func (s *FamilyService) WithTx(tx *gorm.DB) *FamilyService {
+	return &FamilyService{db: tx}
+}
+
+// CreateFamily 创建家庭，创建者作为家长加入
+func (s *FamilyService) CreateFamily(ctx context.Context, name string, parentID uint64) (*model.Family, error) {
+	family := model.DefaultFamily()
+	family.Name = name
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(family).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.FamilyMember{
+			FamilyID:   family.ID,
+			UserID:     parentID,
+			Role:       model.FamilyRoleParent,
+			Permission: model.GuardianManage,
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建家庭失败: %v", err)
+	}
+	return family, nil
+}
+
+// AddMember 将用户加入家庭，已经是成员时更新角色
+func (s *FamilyService) AddMember(ctx context.Context, familyID, userID uint64, role model.FamilyRole) error {
+	member := model.FamilyMember{FamilyID: familyID, UserID: userID}
+	err := s.db.WithContext(ctx).Where(&member).
+		Assign(model.FamilyMember{Role: role}).
+		FirstOrCreate(&member).Error
+	if err != nil {
+		return fmt.Errorf("添加家庭成员失败: %v", err)
+	}
+	return nil
+}
+
+// AddGuardian 将家长作为监护人加入家庭，已经是成员时更新权限级别
+func (s *FamilyService) AddGuardian(ctx context.Context, familyID, userID uint64, permission model.GuardianPermission) error {
+	member := model.FamilyMember{FamilyID: familyID, UserID: userID}
+	err := s.db.WithContext(ctx).Where(&member).
+		Assign(model.FamilyMember{Role: model.FamilyRoleParent, Permission: permission}).
+		FirstOrCreate(&member).Error
+	if err != nil {
+		return fmt.Errorf("添加监护人失败: %v", err)
+	}
+	return nil
+}
+
+// RemoveMember 将用户移出所在的所有家庭
+func (s *FamilyService) RemoveMember(ctx context.Context, userID uint64) error {
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&model.FamilyMember{}).Error; err != nil {
+		return fmt.Errorf("移除家庭成员失败: %v", err)
+	}
+	return nil
+}
+
+// GetFamilyByUser 获取用户所在的家庭，一个用户只属于一个家庭
+func (s *FamilyService) GetFamilyByUser(ctx context.Context, userID uint64) (*model.Family, error) {
+	var family model.Family
+	err := s.db.WithContext(ctx).
+		Joins("JOIN family_members ON family_members.family_id = families.id").
+		Where("family_members.user_id = ?", userID).
+		Order("family_members.id").
+		First(&family).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFamilyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询家庭失败: %v", err)
+	}
+	return &family, nil
+}
+
+// ManagedFamilyByUser 获取用户所在并且有完全管理权限的家庭，用户还没有家庭时为其创建一个
+func (s *FamilyService) ManagedFamilyByUser(ctx context.Context, userID uint64) (*model.Family, error) {
+	family, err := s.GetFamilyByUser(ctx, userID)
+	if errors.Is(err, ErrFamilyNotFound) {
+		return s.CreateFamily(ctx, "", userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var member model.FamilyMember
+	err = s.db.WithContext(ctx).Where("family_id = ? AND user_id = ?", family.ID, userID).First(&member).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询家庭成员失败: %v", err)
+	}
+	if member.Role != model.FamilyRoleParent || member.Permission != model.GuardianManage {
+		return nil, ErrReadOnlyGuardian
+	}
+	return family, nil
+}
+
+// GuardianIDs 获取孩子的所有监护人，即孩子所在家庭中的家长
+func (s *FamilyService) GuardianIDs(ctx context.Context, childID uint64) ([]uint64, error) {
+	var userIDs []uint64
+	err := s.db.WithContext(ctx).Model(&model.FamilyMember{}).
+		Where("role = ?", model.FamilyRoleParent).
+		Where("family_id IN (?)", s.db.Model(&model.FamilyMember{}).Select("family_id").
+			Where("user_id = ? AND role = ?", childID, model.FamilyRoleChild)).
+		Distinct().Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询监护人失败: %v", err)
+	}
+	return userIDs, nil
+}
+
+// GuardedChildIDs 获取用户监护的所有孩子
+func (s *FamilyService) GuardedChildIDs(ctx context.Context, guardianID uint64) ([]uint64, error) {
+	var childIDs []uint64
+	err := guardedChildren(s.db.WithContext(ctx), guardianID, false).Pluck("user_id", &childIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询监护的孩子失败: %v", err)
+	}
+	return childIDs, nil
+}
+
+// guardedChildren 用户作为监护人可以访问的孩子ID查询，可以作为子查询使用；
+// manage为true时只包括用户有完全管理权限的家庭中的孩子
+func guardedChildren(db *gorm.DB, guardianID uint64, manage bool) *gorm.DB {
+	families := db.Model(&model.FamilyMember{}).Select("family_id").
+		Where("user_id = ? AND role = ?", guardianID, model.FamilyRoleParent)
+	if manage {
+		families = families.Where("permission = ?", model.GuardianManage)
+	}
+	return db.Model(&model.FamilyMember{}).Select("user_id").
+		Where("role = ? AND family_id IN (?)", model.FamilyRoleChild, families)
+}
+
+// ListFamilies 获取所有家庭
+func (s *FamilyService) ListFamilies(ctx context.Context) ([]model.Family, error) {
+	var families []model.Family
+	if err := s.db.WithContext(ctx).Find(&families).Error; err != nil {
+		return nil, fmt.Errorf("查询家庭列表失败: %v", err)
+	}
+	return families, nil
+}
+
+// ChildIDs 获取家庭中所有孩子的用户ID
+func (s *FamilyService) ChildIDs(ctx context.Context, familyID uint64) ([]uint64, error) {
+	var userIDs []uint64
+	err := s.db.WithContext(ctx).Model(&model.FamilyMember{}).
+		Where("family_id = ? AND role = ?", familyID, model.FamilyRoleChild).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询家庭成员失败: %v", err)
+	}
+	return userIDs, nil
+}
+
+// UpdateSettings 更新用户所在家庭的报告时间设置，用户还没有家庭时为其创建一个
+func (s *FamilyService) UpdateSettings(ctx context.Context, userID uint64, settings *FamilySettings) (*model.Family, error) {
+	if err := validateFamilySettings(settings); err != nil {
+		return nil, err
+	}
+
+	family, err := s.ManagedFamilyByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	family.Timezone = settings.Timezone
+	family.ReportTime = settings.ReportTime
+	family.PushTime = settings.PushTime
+	family.QuietStart = settings.QuietStart
+	family.QuietEnd = settings.QuietEnd
+	if err := s.db.WithContext(ctx).Save(family).Error; err != nil {
+		return nil, fmt.Errorf("更新家庭设置失败: %v", err)
+	}
+	return family, nil
+}
+
+// validateFamilySettings 校验时区和各时间点，未填写的项使用默认值
+func validateFamilySettings(settings *FamilySettings) error {
+	if settings.Timezone == "" {
+		settings.Timezone = model.DefaultFamilyTimezone
+	}
+	if settings.ReportTime == "" {
+		settings.ReportTime = model.DefaultFamilyReportTime
+	}
+	if settings.PushTime == "" {
+		settings.PushTime = model.DefaultFamilyPushTime
+	}
+
+	if _, err := time.LoadLocation(settings.Timezone); err != nil {
+		return fmt.Errorf("%w: 时区%q无效", ErrInvalidFamilySettings, settings.Timezone)
+	}
+	report, err := parseClock(settings.ReportTime)
+	if err != nil {
+		return fmt.Errorf("%w: 报告生成时间%q无效，应为HH:MM", ErrInvalidFamilySettings, settings.ReportTime)
+	}
+	push, err := parseClock(settings.PushTime)
+	if err != nil {
+		return fmt.Errorf("%w: 推送时间%q无效，应为HH:MM", ErrInvalidFamilySettings, settings.PushTime)
+	}
+	if push < report {
+		return fmt.Errorf("%w: 推送时间不能早于报告生成时间", ErrInvalidFamilySettings)
+	}
+	if (settings.QuietStart == "") != (settings.QuietEnd == "") {
+		return fmt.Errorf("%w: 免打扰开始和结束时间需要同时设置", ErrInvalidFamilySettings)
+	}
+	if settings.QuietStart != "" {
+		if _, err := parseClock(settings.QuietStart); err != nil {
+			return fmt.Errorf("%w: 免打扰开始时间%q无效，应为HH:MM", ErrInvalidFamilySettings, settings.QuietStart)
+		}
+		if _, err := parseClock(settings.QuietEnd); err != nil {
+			return fmt.Errorf("%w: 免打扰结束时间%q无效，应为HH:MM", ErrInvalidFamilySettings, settings.QuietEnd)
+		}
+	}
+	return nil
+}
+
+// parseClock 将HH:MM解析为当天的第几分钟
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// familyClock 家庭设置的时间点，设置无效时使用默认值
+func familyClock(value, fallback string) int {
+	if minute, err := parseClock(value); err == nil {
+		return minute
+	}
+	minute, _ := parseClock(fallback)
+	return minute
+}
+
+// inQuietHours 当天第minute分钟是否在家庭的免打扰时段内，时段可以跨过午夜
+func inQuietHours(family *model.Family, minute int) bool {
+	start, err := parseClock(family.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(family.QuietEnd)
+	if err != nil || start == end {
+		return false
+	}
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// reportDue 家庭当地时间local是否已过当天生成报告的时间
+func reportDue(family *model.Family, local time.Time) bool {
+	return local.Hour()*60+local.Minute() >= familyClock(family.ReportTime, model.DefaultFamilyReportTime)
+}
+
+// pushDue 家庭当地时间local是否已过当天推送报告的时间且不在免打扰时段内，推送时间在免打扰时段内时推迟到免打扰结束
+func pushDue(family *model.Family, local time.Time) bool {
+	now := local.Hour()*60 + local.Minute()
+	push := familyClock(family.PushTime, model.DefaultFamilyPushTime)
+	if inQuietHours(family, push) {
+		push = familyClock(family.QuietEnd, model.DefaultFamilyPushTime)
+	}
+	return now >= push && !inQuietHours(family, now)
+}
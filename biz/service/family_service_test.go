@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestInQuietHours(t *testing.T) {
+	clock := func(hour, minute int) int { return hour*60 + minute }
+	tests := []struct {
+		name   string
+		start  string
+		end    string
+		minute int
+		want   bool
+	}{
+		{name: "未设置", minute: clock(23, 0), want: false},
+		{name: "开始和结束相同", start: "22:00", end: "22:00", minute: clock(22, 0), want: false},
+		{name: "设置无效", start: "25:00", end: "07:00", minute: clock(23, 0), want: false},
+		{name: "当天时段内", start: "12:00", end: "14:00", minute: clock(13, 0), want: true},
+		{name: "当天时段开始", start: "12:00", end: "14:00", minute: clock(12, 0), want: true},
+		{name: "当天时段结束", start: "12:00", end: "14:00", minute: clock(14, 0), want: false},
+		{name: "跨午夜的时段前半夜", start: "21:30", end: "07:00", minute: clock(23, 59), want: true},
+		{name: "跨午夜的时段后半夜", start: "21:30", end: "07:00", minute: clock(6, 59), want: true},
+		{name: "跨午夜的时段之外", start: "21:30", end: "07:00", minute: clock(20, 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			family := &model.Family{QuietStart: tt.start, QuietEnd: tt.end}
+			if got := inQuietHours(family, tt.minute); got != tt.want {
+				t.Errorf("inQuietHours(%s-%s, %d) = %v, want %v", tt.start, tt.end, tt.minute, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushDue(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 14, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		family model.Family
+		local  time.Time
+		want   bool
+	}{
+		{name: "默认推送时间之前", family: model.Family{}, local: at(19, 59), want: false},
+		{name: "默认推送时间", family: model.Family{}, local: at(20, 0), want: true},
+		{name: "设置的推送时间", family: model.Family{PushTime: "08:30"}, local: at(8, 30), want: true},
+		{name: "推送时间在免打扰时段内时推迟到时段结束", family: model.Family{PushTime: "21:00", QuietStart: "20:30", QuietEnd: "22:00"}, local: at(21, 30), want: false},
+		{name: "免打扰结束后推送", family: model.Family{PushTime: "21:00", QuietStart: "20:30", QuietEnd: "22:00"}, local: at(22, 0), want: true},
+		{name: "错过推送时间后进入免打扰时段", family: model.Family{PushTime: "20:00", QuietStart: "22:00", QuietEnd: "07:00"}, local: at(23, 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pushDue(&tt.family, tt.local); got != tt.want {
+				t.Errorf("pushDue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/websocket"
+)
+
+var (
+	// ErrInvalidInvitation 邀请的邮箱或权限级别无效
+	ErrInvalidInvitation = errors.New("邀请信息无效")
+	// ErrInvitationNotFound 邀请不存在、已过期、已撤销或已被接受
+	ErrInvitationNotFound = errors.New("邀请不存在或已失效")
+	// ErrAlreadyGuardian 用户已经是家庭的监护人
+	ErrAlreadyGuardian = errors.New("已经是该家庭的监护人")
+	// ErrInOtherFamily 用户已经加入了有其他成员的家庭，一个用户只能属于一个家庭
+	ErrInOtherFamily = errors.New("已经加入其他家庭，请先退出原来的家庭")
+	// ErrGuardianNotFound 监护人不在家庭中
+	ErrGuardianNotFound = errors.New("监护人不存在")
+	// ErrLastFamilyManager 家庭中最后一位有完全管理权限的监护人不能被移除或降级
+	ErrLastFamilyManager = errors.New("家庭至少需要一位拥有完全管理权限的监护人")
+)
+
+// GuardianConfig 监护人邀请配置
+type GuardianConfig struct {
+	InviteURL     string        // 邀请链接前缀，后面拼接邀请令牌
+	InvitationTTL time.Duration // 邀请的有效期
+}
+
+// DefaultGuardianConfig 默认监护人邀请配置
+var DefaultGuardianConfig = GuardianConfig{
+	InviteURL:     "sweekar://invite?token=",
+	InvitationTTL: 7 * 24 * time.Hour,
+}
+
+// InvitationInput 家长发出邀请时提交的内容
+type InvitationInput struct {
+	Email      string                   `json:"email"`      // 被邀请人的邮箱，为空时只生成链接
+	Permission model.GuardianPermission `json:"permission"` // 接受后获得的权限级别
+}
+
+// InvitationResult 发出的邀请，链接只在创建时返回一次
+type InvitationResult struct {
+	Invitation *model.FamilyInvitation `json:"invitation"`
+	Link       string                  `json:"link"`       // 邀请链接
+	EmailSent  bool                    `json:"email_sent"` // 是否已将链接发送到被邀请人的邮箱
+}
+
+// Guardian 家庭中的监护人
+type Guardian struct {
+	UserID     uint64                   `json:"user_id"`
+	Username   *string                  `json:"username"`
+	Email      string                   `json:"email"`
+	Permission model.GuardianPermission `json:"permission"`
+	JoinedAt   time.Time                `json:"joined_at"` // 加入家庭的时间
+}
+
+// GuardianService 监护人管理：家长邀请其他家长作为监护人加入家庭，家庭中的家长都能查看孩子的报告并收到推送和提醒
+type GuardianService struct {
+	db       *gorm.DB
+	families *FamilyService
+	pool     *websocket.Pool
+	mailer   NotificationSender
+	config   GuardianConfig
+}
+
+// NewGuardianService 创建监护人服务，mailer用于发送邀请邮件，为nil时只生成邀请链接
+func NewGuardianService(db *gorm.DB, families *FamilyService, pool *websocket.Pool, config *GuardianConfig, mailer NotificationSender) *GuardianService {
+	if config == nil {
+		config = &DefaultGuardianConfig
+	}
+	return &GuardianService{
+		db:       db,
+		families: families,
+		pool:     pool,
+		mailer:   mailer,
+		config:   *config,
+	}
+}
+
+// Invite 邀请其他家长作为监护人加入家庭，只有拥有完全管理权限的监护人可以邀请
+func (s *GuardianService) Invite(ctx context.Context, inviterID uint64, input *InvitationInput) (*InvitationResult, error) {
+	if !input.Permission.Valid() {
+		return nil, fmt.Errorf("%w: 权限级别只能是view_reports或manage", ErrInvalidInvitation)
+	}
+	var email string
+	if input.Email != "" {
+		address, err := mail.ParseAddress(input.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 邮箱格式不正确", ErrInvalidInvitation)
+		}
+		email = address.Address
+	}
+
+	family, err := s.families.ManagedFamilyByUser(ctx, inviterID)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	invitation := &model.FamilyInvitation{
+		FamilyID:   family.ID,
+		InviterID:  inviterID,
+		Email:      email,
+		Permission: input.Permission,
+		TokenHash:  hashToken(token),
+		ExpiresAt:  time.Now().Add(s.config.InvitationTTL),
+	}
+	if err := s.db.WithContext(ctx).Create(invitation).Error; err != nil {
+		return nil, fmt.Errorf("创建邀请失败: %v", err)
+	}
+
+	result := &InvitationResult{
+		Invitation: invitation,
+		Link:       s.config.InviteURL + token,
+	}
+	if email != "" && s.mailer != nil {
+		if err := s.sendInvitation(ctx, inviterID, invitation, result.Link); err != nil {
+			logs.Error("发送监护人邀请邮件失败: %v", err)
+		} else {
+			result.EmailSent = true
+		}
+	}
+	return result, nil
+}
+
+// sendInvitation 将邀请链接发送到被邀请人的邮箱
+func (s *GuardianService) sendInvitation(ctx context.Context, inviterID uint64, invitation *model.FamilyInvitation, link string) error {
+	var inviter model.User
+	if err := s.db.WithContext(ctx).First(&inviter, inviterID).Error; err != nil {
+		return fmt.Errorf("查询邀请人失败: %v", err)
+	}
+	name := inviter.Email
+	if inviter.Username != nil {
+		name = *inviter.Username
+	}
+	level := "查看孩子的情绪报告和提醒"
+	if invitation.Permission == model.GuardianManage {
+		level = "查看报告并管理孩子和家庭设置"
+	}
+
+	notification := &model.Notification{
+		Title: "Sweekar 监护人邀请",
+		Content: fmt.Sprintf("%s 邀请您成为孩子的监护人，接受后可以%s。\n请在%s前打开以下链接接受邀请：\n%s",
+			name, level, invitation.ExpiresAt.Format("2006-01-02 15:04"), link),
+	}
+	return s.mailer.Send(ctx, notification, &model.NotificationSettings{Email: invitation.Email})
+}
+
+// ListInvitations 获取家庭中还未接受的邀请
+func (s *GuardianService) ListInvitations(ctx context.Context, userID uint64) ([]model.FamilyInvitation, error) {
+	family, err := s.families.ManagedFamilyByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var invitations []model.FamilyInvitation
+	err = s.db.WithContext(ctx).
+		Where("family_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", family.ID, time.Now()).
+		Order("id DESC").Find(&invitations).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询邀请失败: %v", err)
+	}
+	return invitations, nil
+}
+
+// RevokeInvitation 撤销还未接受的邀请
+func (s *GuardianService) RevokeInvitation(ctx context.Context, userID, id uint64) error {
+	family, err := s.families.ManagedFamilyByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.WithContext(ctx).Model(&model.FamilyInvitation{}).
+		Where("id = ? AND family_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id, family.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("撤销邀请失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// AcceptInvitation 家长接受邀请，以邀请的权限级别加入家庭；
+// 发送到邮箱的邀请只能由该邮箱的账号接受
+func (s *GuardianService) AcceptInvitation(ctx context.Context, userID uint64, token string) (*model.Family, error) {
+	var user model.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	if user.Role != model.UserRoleParent {
+		return nil, ErrNotParent
+	}
+
+	now := time.Now()
+	var invitation model.FamilyInvitation
+	err := s.db.WithContext(ctx).
+		Where("token_hash = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", hashToken(token), now).
+		First(&invitation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询邀请失败: %v", err)
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, ErrInvitationNotFound
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := leaveEmptyFamilies(tx, userID, invitation.FamilyID); err != nil {
+			return err
+		}
+
+		result := tx.Model(&model.FamilyInvitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ID).
+			Updates(map[string]interface{}{"accepted_by": userID, "accepted_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvitationNotFound
+		}
+		return s.families.WithTx(tx).AddGuardian(ctx, invitation.FamilyID, userID, invitation.Permission)
+	})
+	if errors.Is(err, ErrInvitationNotFound) || errors.Is(err, ErrAlreadyGuardian) || errors.Is(err, ErrInOtherFamily) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("接受邀请失败: %v", err)
+	}
+
+	// 在线的连接立即开始接收家庭中孩子的推送
+	childIDs, err := s.families.ChildIDs(ctx, invitation.FamilyID)
+	if err != nil {
+		logs.Error("查询家庭孩子失败: %v", err)
+	}
+	for _, childID := range childIDs {
+		s.pool.AddGuardian(childID, userID)
+	}
+
+	var family model.Family
+	if err := s.db.WithContext(ctx).First(&family, invitation.FamilyID).Error; err != nil {
+		return nil, fmt.Errorf("查询家庭失败: %v", err)
+	}
+	return &family, nil
+}
+
+// leaveEmptyFamilies 一个用户只能属于一个家庭，加入familyID之前解散用户所在的只有自己一个人的家庭；
+// 用户所在的家庭还有其他成员时不能加入
+func leaveEmptyFamilies(tx *gorm.DB, userID, familyID uint64) error {
+	var familyIDs []uint64
+	if err := tx.Model(&model.FamilyMember{}).Where("user_id = ?", userID).Pluck("family_id", &familyIDs).Error; err != nil {
+		return err
+	}
+	if len(familyIDs) == 0 {
+		return nil
+	}
+	for _, id := range familyIDs {
+		if id == familyID {
+			return ErrAlreadyGuardian
+		}
+	}
+
+	var others int64
+	err := tx.Model(&model.FamilyMember{}).
+		Where("family_id IN ? AND user_id <> ?", familyIDs, userID).
+		Count(&others).Error
+	if err != nil {
+		return err
+	}
+	if others > 0 {
+		return ErrInOtherFamily
+	}
+
+	if err := tx.Where("family_id IN ?", familyIDs).Delete(&model.FamilyMember{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("family_id IN ?", familyIDs).Delete(&model.NotificationSettings{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("id IN ?", familyIDs).Delete(&model.Family{}).Error
+}
+
+// ListGuardians 获取用户所在家庭的所有监护人
+func (s *GuardianService) ListGuardians(ctx context.Context, userID uint64) ([]Guardian, error) {
+	family, err := s.families.GetFamilyByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var guardians []Guardian
+	err = s.db.WithContext(ctx).Model(&model.FamilyMember{}).
+		Select("family_members.user_id, users.username, users.email, family_members.permission, family_members.created_at AS joined_at").
+		Joins("JOIN users ON users.id = family_members.user_id AND users.deleted_at IS NULL").
+		Where("family_members.family_id = ? AND family_members.role = ?", family.ID, model.FamilyRoleParent).
+		Order("family_members.id").
+		Scan(&guardians).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询监护人失败: %v", err)
+	}
+	return guardians, nil
+}
+
+// UpdateGuardian 修改监护人的权限级别
+func (s *GuardianService) UpdateGuardian(ctx context.Context, managerID, guardianID uint64, permission model.GuardianPermission) error {
+	if !permission.Valid() {
+		return fmt.Errorf("%w: 权限级别只能是view_reports或manage", ErrInvalidInvitation)
+	}
+	family, err := s.families.ManagedFamilyByUser(ctx, managerID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		member, err := s.guardianMember(tx, family.ID, guardianID)
+		if err != nil {
+			return err
+		}
+		if member.Permission == model.GuardianManage && permission != model.GuardianManage {
+			if err := s.ensureOtherManager(tx, family.ID, guardianID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(member).Update("permission", permission).Error
+	})
+	if errors.Is(err, ErrGuardianNotFound) || errors.Is(err, ErrLastFamilyManager) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("修改监护人权限失败: %v", err)
+	}
+	return nil
+}
+
+// RemoveGuardian 将监护人移出家庭，之后不再收到家庭中孩子的推送；监护人也可以移除自己以退出家庭
+func (s *GuardianService) RemoveGuardian(ctx context.Context, managerID, guardianID uint64) error {
+	var family *model.Family
+	var err error
+	if managerID == guardianID {
+		family, err = s.families.GetFamilyByUser(ctx, managerID)
+	} else {
+		family, err = s.families.ManagedFamilyByUser(ctx, managerID)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		member, err := s.guardianMember(tx, family.ID, guardianID)
+		if err != nil {
+			return err
+		}
+		if member.Permission == model.GuardianManage {
+			if err := s.ensureOtherManager(tx, family.ID, guardianID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(member).Error
+	})
+	if errors.Is(err, ErrGuardianNotFound) || errors.Is(err, ErrLastFamilyManager) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("移除监护人失败: %v", err)
+	}
+
+	childIDs, err := s.families.ChildIDs(ctx, family.ID)
+	if err != nil {
+		logs.Error("查询家庭孩子失败: %v", err)
+	}
+	for _, childID := range childIDs {
+		s.pool.RemoveGuardian(childID, guardianID)
+	}
+	return nil
+}
+
+// guardianMember 查询家庭中的监护人
+func (s *GuardianService) guardianMember(tx *gorm.DB, familyID, guardianID uint64) (*model.FamilyMember, error) {
+	var member model.FamilyMember
+	err := tx.Where("family_id = ? AND user_id = ? AND role = ?", familyID, guardianID, model.FamilyRoleParent).
+		First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrGuardianNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// ensureOtherManager 确认家庭中除guardianID外还有拥有完全管理权限的监护人
+func (s *GuardianService) ensureOtherManager(tx *gorm.DB, familyID, guardianID uint64) error {
+	var count int64
+	err := tx.Model(&model.FamilyMember{}).
+		Where("family_id = ? AND user_id <> ? AND role = ? AND permission = ?",
+			familyID, guardianID, model.FamilyRoleParent, model.GuardianManage).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrLastFamilyManager
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/websocket"
+)
+
+// guardianFixture 一个家长创建了孩子的家庭，另一个家长等待被邀请
+type guardianFixture struct {
+	guardians *GuardianService
+	pool      *websocket.Pool
+	mailer    *fakeSender
+	owner     *model.User
+	guest     *model.User
+	child     *model.User
+}
+
+func newGuardianFixture(t *testing.T) *guardianFixture {
+	t.Helper()
+	ctx := context.Background()
+	db := newTestDB(t)
+	families := NewFamilyService(db)
+	users := NewUserService(db, families)
+
+	f := &guardianFixture{
+		pool:   websocket.NewPool(),
+		mailer: &fakeSender{channel: model.ChannelEmail},
+	}
+	f.guardians = NewGuardianService(db, families, f.pool, nil, f.mailer)
+	register := func(name string) *model.User {
+		user := &model.User{Username: &name, Password: "secret123", Email: name + "@example.com"}
+		if err := users.Register(ctx, user); err != nil {
+			t.Fatalf("Register %s: %v", name, err)
+		}
+		return user
+	}
+	f.owner, f.guest = register("owner"), register("guest")
+	child, err := users.CreateChild(ctx, f.owner.ID, &ChildInput{Name: "小明"})
+	if err != nil {
+		t.Fatalf("CreateChild: %v", err)
+	}
+	f.child = child
+	return f
+}
+
+// join 邀请guest以permission加入家庭并接受
+func (f *guardianFixture) join(t *testing.T, permission model.GuardianPermission) {
+	t.Helper()
+	ctx := context.Background()
+	result, err := f.guardians.Invite(ctx, f.owner.ID, &InvitationInput{Permission: permission})
+	if err != nil {
+		t.Fatalf("Invite: %v", err)
+	}
+	if _, err := f.guardians.AcceptInvitation(ctx, f.guest.ID, strings.TrimPrefix(result.Link, DefaultGuardianConfig.InviteURL)); err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+}
+
+func TestGuardianInvitation(t *testing.T) {
+	ctx := context.Background()
+	f := newGuardianFixture(t)
+	// 被邀请人在两台设备上在线
+	phone, tablet := &websocket.Client{UserID: f.guest.ID}, &websocket.Client{UserID: f.guest.ID}
+	f.pool.Register(phone)
+	f.pool.Register(tablet)
+
+	result, err := f.guardians.Invite(ctx, f.owner.ID, &InvitationInput{Email: f.guest.Email, Permission: model.GuardianViewReports})
+	if err != nil {
+		t.Fatalf("Invite: %v", err)
+	}
+	if !result.EmailSent || len(f.mailer.settings) != 1 || f.mailer.settings[0].Email != f.guest.Email {
+		t.Fatalf("invitation email not sent to %s: %+v", f.guest.Email, f.mailer.settings)
+	}
+	if !strings.Contains(f.mailer.sent[0].Content, result.Link) {
+		t.Errorf("invitation email %q does not contain the link", f.mailer.sent[0].Content)
+	}
+	token := strings.TrimPrefix(result.Link, DefaultGuardianConfig.InviteURL)
+
+	// 发送到邮箱的邀请只能由该邮箱的账号接受
+	if _, err := f.guardians.AcceptInvitation(ctx, f.owner.ID, token); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("AcceptInvitation by another account = %v, want ErrInvitationNotFound", err)
+	}
+	if len(f.pool.GetGuardianClients(f.child.ID)) != 0 {
+		t.Fatal("guest receives pushes before accepting")
+	}
+	family, err := f.guardians.AcceptInvitation(ctx, f.guest.ID, token)
+	if err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+	if _, err := f.guardians.AcceptInvitation(ctx, f.guest.ID, token); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("second AcceptInvitation = %v, want ErrInvitationNotFound", err)
+	}
+
+	// 接受后在线的所有连接立即开始接收孩子的推送
+	if clients := f.pool.GetGuardianClients(f.child.ID); len(clients) != 2 {
+		t.Errorf("got %d guardian connections, want 2", len(clients))
+	}
+	guardians, err := f.guardians.ListGuardians(ctx, f.guest.ID)
+	if err != nil {
+		t.Fatalf("ListGuardians: %v", err)
+	}
+	if len(guardians) != 2 || guardians[1].UserID != f.guest.ID || guardians[1].Permission != model.GuardianViewReports {
+		t.Errorf("guardians of family %d = %+v, want owner and guest with view_reports", family.ID, guardians)
+	}
+
+	// 只能查看报告的监护人不能邀请
+	if _, err := f.guardians.Invite(ctx, f.guest.ID, &InvitationInput{Permission: model.GuardianViewReports}); !errors.Is(err, ErrReadOnlyGuardian) {
+		t.Errorf("Invite by read-only guardian = %v, want ErrReadOnlyGuardian", err)
+	}
+
+	// 断开一台设备不影响另一台设备接收推送
+	f.pool.Unregister(phone)
+	if clients := f.pool.GetGuardianClients(f.child.ID); len(clients) != 1 || clients[0] != tablet {
+		t.Errorf("guardian connections after unregister = %v, want only the tablet", clients)
+	}
+}
+
+func TestGuardianLastManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		guest   model.GuardianPermission // 为空时guest不加入家庭
+		change  func(f *guardianFixture) error
+		wantErr error
+	}{
+		{
+			name: "唯一的管理者不能降级",
+			change: func(f *guardianFixture) error {
+				return f.guardians.UpdateGuardian(context.Background(), f.owner.ID, f.owner.ID, model.GuardianViewReports)
+			},
+			wantErr: ErrLastFamilyManager,
+		},
+		{
+			name: "唯一的管理者不能退出",
+			change: func(f *guardianFixture) error {
+				return f.guardians.RemoveGuardian(context.Background(), f.owner.ID, f.owner.ID)
+			},
+			wantErr: ErrLastFamilyManager,
+		},
+		{
+			name:  "只读监护人不算管理者",
+			guest: model.GuardianViewReports,
+			change: func(f *guardianFixture) error {
+				return f.guardians.RemoveGuardian(context.Background(), f.owner.ID, f.owner.ID)
+			},
+			wantErr: ErrLastFamilyManager,
+		},
+		{
+			name:  "有其他管理者时可以降级",
+			guest: model.GuardianManage,
+			change: func(f *guardianFixture) error {
+				return f.guardians.UpdateGuardian(context.Background(), f.guest.ID, f.owner.ID, model.GuardianViewReports)
+			},
+		},
+		{
+			name:  "有其他管理者时可以退出",
+			guest: model.GuardianManage,
+			change: func(f *guardianFixture) error {
+				return f.guardians.RemoveGuardian(context.Background(), f.owner.ID, f.owner.ID)
+			},
+		},
+		{
+			name:  "只读监护人可以退出",
+			guest: model.GuardianViewReports,
+			change: func(f *guardianFixture) error {
+				return f.guardians.RemoveGuardian(context.Background(), f.guest.ID, f.guest.ID)
+			},
+		},
+		{
+			name:  "只读监护人不能移除其他监护人",
+			guest: model.GuardianViewReports,
+			change: func(f *guardianFixture) error {
+				return f.guardians.RemoveGuardian(context.Background(), f.guest.ID, f.owner.ID)
+			},
+			wantErr: ErrReadOnlyGuardian,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newGuardianFixture(t)
+			if tt.guest != "" {
+				f.join(t, tt.guest)
+			}
+			if err := tt.change(f); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/database"
+)
+
+// newTestDB 创建已迁移所有表的SQLite测试数据库
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/test.db"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// fakeSender 记录发送的通知，err不为nil时发送失败
+type fakeSender struct {
+	channel model.NotificationChannel
+	err     error
+
+	mu       sync.Mutex
+	sent     []model.Notification
+	settings []model.NotificationSettings
+}
+
+func (s *fakeSender) Channel() model.NotificationChannel {
+	return s.channel
+}
+
+func (s *fakeSender) Send(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, *notification)
+	s.settings = append(s.settings, *settings)
+	return nil
+}
@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// jobStaleAfter 执行中的任务超过该时长没有更新进度时视为中断
+	jobStaleAfter = 5 * time.Minute
+	// maxJobAttempts 任务最多执行的次数，超过后需要人工处理
+	maxJobAttempts = 3
+)
+
+var (
+	// ErrJobNotFound 任务未注册
+	ErrJobNotFound = errors.New("任务不存在")
+	// ErrJobRunNotFound 任务执行记录不存在
+	ErrJobRunNotFound = errors.New("任务执行记录不存在")
+)
+
+// JobFunc 处理任务中的一个用户，local为任务所属的当地日期
+type JobFunc func(ctx context.Context, local time.Time, userID uint64) error
+
+// JobRunner 执行按用户拆分的定时任务并记录每个用户的进度，同一任务在同一范围和日期只执行一次，
+// 中断或失败的任务由Resume从未完成的用户继续；任务的创建、接手和进度更新都带有领导者的防护令牌，
+// 失去领导权的实例在处理下一个用户前停止
+type JobRunner struct {
+	db   *gorm.DB
+	jobs map[string]JobFunc
+}
+
+// NewJobRunner 创建任务执行器
+func NewJobRunner(db *gorm.DB) *JobRunner {
+	return &JobRunner{
+		db:   db,
+		jobs: make(map[string]JobFunc),
+	}
+}
+
+// Register 注册任务的处理函数
+func (r *JobRunner) Register(job string, fn JobFunc) {
+	r.jobs[job] = fn
+}
+
+// Jobs 已注册的任务及其是否暂停
+func (r *JobRunner) Jobs(ctx context.Context) ([]model.JobControl, error) {
+	var controls []model.JobControl
+	if err := r.db.WithContext(ctx).Find(&controls).Error; err != nil {
+		return nil, fmt.Errorf("查询任务状态失败: %v", err)
+	}
+	byJob := make(map[string]model.JobControl, len(controls))
+	for _, control := range controls {
+		byJob[control.Job] = control
+	}
+
+	jobs := make([]model.JobControl, 0, len(r.jobs))
+	for job := range r.jobs {
+		control, ok := byJob[job]
+		if !ok {
+			control = model.JobControl{Job: job}
+		}
+		jobs = append(jobs, control)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Job < jobs[j].Job
+	})
+	return jobs, nil
+}
+
+// SetPaused 暂停或恢复任务，所有实例在下一次调度时生效
+func (r *JobRunner) SetPaused(ctx context.Context, job string, paused bool, operatorID uint64) error {
+	if _, ok := r.jobs[job]; !ok {
+		return ErrJobNotFound
+	}
+	err := r.db.WithContext(ctx).Save(&model.JobControl{
+		Job:       job,
+		Paused:    paused,
+		UpdatedBy: operatorID,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("更新任务状态失败: %v", err)
+	}
+	return nil
+}
+
+// Paused 暂停中的任务
+func (r *JobRunner) Paused(ctx context.Context) (map[string]bool, error) {
+	var jobs []string
+	err := r.db.WithContext(ctx).Model(&model.JobControl{}).Where("paused = ?", true).Pluck("job", &jobs).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询任务状态失败: %v", err)
+	}
+	paused := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		paused[job] = true
+	}
+	return paused, nil
+}
+
+// ListRuns 分页获取任务执行记录，job和status为空时不过滤，按开始时间从新到旧排列
+func (r *JobRunner) ListRuns(ctx context.Context, job string, status model.JobStatus, page, pageSize int) ([]model.JobRun, int64, error) {
+	query := r.db.WithContext(ctx).Model(&model.JobRun{})
+	if job != "" {
+		query = query.Where("job = ?", job)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询任务执行记录失败: %v", err)
+	}
+
+	var runs []model.JobRun
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&runs).Error; err != nil {
+		return nil, 0, fmt.Errorf("查询任务执行记录失败: %v", err)
+	}
+	return runs, total, nil
+}
+
+// Retry 重置失败任务的执行次数，由下一次调度从失败的用户继续
+func (r *JobRunner) Retry(ctx context.Context, runID uint64) error {
+	result := r.db.WithContext(ctx).Model(&model.JobRun{}).
+		Where("id = ? AND status = ?", runID, model.JobFailed).
+		Update("attempts", 0)
+	if result.Error != nil {
+		return fmt.Errorf("重试任务失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrJobRunNotFound
+	}
+	return nil
+}
+
+// Started 任务在local当天是否已经开始执行过
+func (r *JobRunner) Started(ctx context.Context, job, scope string, local time.Time) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&model.JobRun{}).
+		Where("job = ? AND scope = ? AND run_date = ?", job, scope, local.Format("2006-01-02")).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("查询任务 %s 失败: %v", job, err)
+	}
+	return count > 0, nil
+}
+
+// Run 为scope范围内的用户执行local当天的任务，任务已经执行过或正在由其他实例执行时直接返回
+func (r *JobRunner) Run(ctx context.Context, fence Fence, job, scope string, local time.Time, userIDs []uint64) error {
+	if _, ok := r.jobs[job]; !ok {
+		return fmt.Errorf("任务 %s 未注册", job)
+	}
+
+	now := time.Now()
+	run := model.JobRun{
+		Job:         job,
+		Scope:       scope,
+		RunDate:     local.Format("2006-01-02"),
+		Timezone:    local.Location().String(),
+		Status:      model.JobRunning,
+		Attempts:    1,
+		Total:       len(userIDs),
+		StartedAt:   now,
+		HeartbeatAt: now,
+	}
+	claimed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := fence.check(tx); err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		claimed = true
+
+		items := make([]model.JobRunItem, 0, len(userIDs))
+		for _, userID := range userIDs {
+			items = append(items, model.JobRunItem{
+				JobRunID: run.ID,
+				UserID:   userID,
+				Status:   model.JobPending,
+			})
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(items, 500).Error
+	})
+	if errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("创建任务 %s 失败: %w", job, err)
+	}
+	if err != nil {
+		return fmt.Errorf("创建任务 %s 失败: %v", job, err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	return r.execute(ctx, fence, &run)
+}
+
+// Resume 继续执行中断的任务和可以重试的失败任务，跳过暂停的任务
+func (r *JobRunner) Resume(ctx context.Context, fence Fence) error {
+	var runs []model.JobRun
+	err := r.db.WithContext(ctx).
+		Where("(status = ? AND heartbeat_at < ?) OR (status = ? AND attempts < ?)",
+			model.JobRunning, time.Now().Add(-jobStaleAfter), model.JobFailed, maxJobAttempts).
+		Order("id").Find(&runs).Error
+	if err != nil {
+		return fmt.Errorf("查询待继续的任务失败: %v", err)
+	}
+	paused, err := r.Paused(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range runs {
+		if paused[runs[i].Job] {
+			continue
+		}
+		claimed, err := r.claim(ctx, fence, &runs[i])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := r.execute(ctx, fence, &runs[i]); err != nil {
+			errs = append(errs, err)
+			if errors.Is(err, errLeaseLost) {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// claim 以条件更新的方式接手任务，避免多个实例同时继续同一个任务
+func (r *JobRunner) claim(ctx context.Context, fence Fence, run *model.JobRun) (bool, error) {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&model.JobRun{}).Scopes(fence.scope).
+		Where("id = ? AND attempts = ?", run.ID, run.Attempts).
+		Where("status = ? OR (status = ? AND heartbeat_at < ?)", model.JobFailed, model.JobRunning, now.Add(-jobStaleAfter)).
+		Updates(map[string]interface{}{
+			"status":       model.JobRunning,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"heartbeat_at": now,
+			"finished_at":  nil,
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("接手任务 %d 失败: %v", run.ID, result.Error)
+	}
+	run.Status = model.JobRunning
+	run.Attempts++
+	run.HeartbeatAt = now
+	return result.RowsAffected == 1, nil
+}
+
+// execute 依次处理任务中未成功的用户，每处理一个用户更新一次进度；
+// 进度更新因失去领导权没有生效时停止，任务由新的领导者在心跳超时后接手
+func (r *JobRunner) execute(ctx context.Context, fence Fence, run *model.JobRun) error {
+	fn, ok := r.jobs[run.Job]
+	if !ok {
+		return r.finish(ctx, fence, run, fmt.Errorf("任务 %s 未注册", run.Job))
+	}
+	loc, err := time.LoadLocation(run.Timezone)
+	if err != nil {
+		return r.finish(ctx, fence, run, fmt.Errorf("时区 %q 无效: %v", run.Timezone, err))
+	}
+	local, err := time.ParseInLocation("2006-01-02", run.RunDate, loc)
+	if err != nil {
+		return r.finish(ctx, fence, run, fmt.Errorf("任务日期 %q 无效: %v", run.RunDate, err))
+	}
+
+	var items []model.JobRunItem
+	if err := r.db.WithContext(ctx).Where("job_run_id = ? AND status <> ?", run.ID, model.JobSucceeded).
+		Order("user_id").Find(&items).Error; err != nil {
+		return r.finish(ctx, fence, run, fmt.Errorf("查询任务进度失败: %v", err))
+	}
+
+	var lastErr error
+	for i := range items {
+		item := &items[i]
+		item.Attempts++
+		item.Status, item.Error = model.JobSucceeded, ""
+		if err := fn(ctx, local, item.UserID); err != nil {
+			item.Status, item.Error = model.JobFailed, err.Error()
+			lastErr = fmt.Errorf("用户 %d: %v", item.UserID, err)
+		}
+		if err := r.progress(ctx, fence, run, item); err != nil {
+			if errors.Is(err, errLeaseLost) {
+				return fmt.Errorf("任务 %d 停止执行: %w", run.ID, err)
+			}
+			return r.finish(ctx, fence, run, err)
+		}
+	}
+	return r.finish(ctx, fence, run, lastErr)
+}
+
+// progress 在同一事务中记录用户的执行结果并更新任务心跳，租约已被其他实例接手时返回errLeaseLost
+func (r *JobRunner) progress(ctx context.Context, fence Fence, run *model.JobRun, item *model.JobRunItem) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.JobRun{}).Scopes(fence.scope).
+			Where("id = ?", run.ID).
+			Update("heartbeat_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 心跳时间没有变化时也不会更新，确认是否失去了领导权
+			if err := fence.check(tx); err != nil {
+				return err
+			}
+		}
+		return tx.Model(item).Updates(map[string]interface{}{
+			"status":   item.Status,
+			"error":    item.Error,
+			"attempts": item.Attempts,
+		}).Error
+	})
+	if err != nil && !errors.Is(err, errLeaseLost) {
+		return fmt.Errorf("更新任务进度失败: %v", err)
+	}
+	return err
+}
+
+// finish 汇总各用户的执行结果，记录任务的最终状态
+func (r *JobRunner) finish(ctx context.Context, fence Fence, run *model.JobRun, runErr error) error {
+	var counts []struct {
+		Status model.JobStatus
+		Count  int
+	}
+	if err := r.db.WithContext(ctx).Model(&model.JobRunItem{}).Select("status, COUNT(*) AS count").
+		Where("job_run_id = ?", run.ID).Group("status").Scan(&counts).Error; err != nil && runErr == nil {
+		runErr = fmt.Errorf("统计任务进度失败: %v", err)
+	}
+
+	run.Succeeded, run.Failed = 0, 0
+	for _, c := range counts {
+		switch c.Status {
+		case model.JobSucceeded:
+			run.Succeeded = c.Count
+		case model.JobFailed:
+			run.Failed = c.Count
+		}
+	}
+
+	now := time.Now()
+	run.Status, run.Error = model.JobSucceeded, ""
+	if runErr != nil {
+		run.Status, run.Error = model.JobFailed, runErr.Error()
+	}
+	run.HeartbeatAt, run.FinishedAt = now, &now
+	result := r.db.WithContext(ctx).Model(run).Scopes(fence.scope).
+		Select("status", "error", "succeeded", "failed", "heartbeat_at", "finished_at").
+		Updates(run)
+	if result.Error != nil {
+		return fmt.Errorf("更新任务 %d 状态失败: %v", run.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		if err := fence.check(r.db.WithContext(ctx)); err != nil {
+			return fmt.Errorf("任务 %d 停止执行: %w", run.ID, err)
+		}
+	}
+	if runErr != nil {
+		return fmt.Errorf("任务 %s(%s %s) 执行失败: %v", run.Job, run.Scope, run.RunDate, runErr)
+	}
+	return nil
+}
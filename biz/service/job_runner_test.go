@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestJobRunnerResume(t *testing.T) {
+	const job = "test_job"
+	stale := time.Now().Add(-2 * jobStaleAfter)
+	tests := []struct {
+		name       string
+		run        model.JobRun
+		items      map[uint64]model.JobStatus
+		paused     bool
+		wantCalls  []uint64
+		wantStatus model.JobStatus
+	}{
+		{
+			name:       "失败的任务从失败的用户继续",
+			run:        model.JobRun{Status: model.JobFailed, Attempts: 1, HeartbeatAt: time.Now()},
+			items:      map[uint64]model.JobStatus{1: model.JobSucceeded, 2: model.JobFailed, 3: model.JobSucceeded},
+			wantCalls:  []uint64{2},
+			wantStatus: model.JobSucceeded,
+		},
+		{
+			name:       "中断的任务从未完成的用户继续",
+			run:        model.JobRun{Status: model.JobRunning, Attempts: 1, HeartbeatAt: stale},
+			items:      map[uint64]model.JobStatus{1: model.JobSucceeded, 2: model.JobPending, 3: model.JobPending},
+			wantCalls:  []uint64{2, 3},
+			wantStatus: model.JobSucceeded,
+		},
+		{
+			name:       "仍在执行的任务不接手",
+			run:        model.JobRun{Status: model.JobRunning, Attempts: 1, HeartbeatAt: time.Now()},
+			items:      map[uint64]model.JobStatus{1: model.JobPending},
+			wantStatus: model.JobRunning,
+		},
+		{
+			name:       "超过最多执行次数的任务不再重试",
+			run:        model.JobRun{Status: model.JobFailed, Attempts: maxJobAttempts, HeartbeatAt: time.Now()},
+			items:      map[uint64]model.JobStatus{1: model.JobFailed},
+			wantStatus: model.JobFailed,
+		},
+		{
+			name:       "暂停的任务不继续",
+			run:        model.JobRun{Status: model.JobFailed, Attempts: 1, HeartbeatAt: time.Now()},
+			items:      map[uint64]model.JobStatus{1: model.JobFailed},
+			paused:     true,
+			wantStatus: model.JobFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			runner := NewJobRunner(db)
+			var calls []uint64
+			runner.Register(job, func(ctx context.Context, local time.Time, userID uint64) error {
+				calls = append(calls, userID)
+				return nil
+			})
+			if tt.paused {
+				if err := runner.SetPaused(ctx, job, true, 1); err != nil {
+					t.Fatalf("SetPaused: %v", err)
+				}
+			}
+
+			run := tt.run
+			run.Job, run.Scope, run.RunDate, run.Timezone = job, "family:1", "2024-03-14", "UTC"
+			run.Total, run.StartedAt = len(tt.items), run.HeartbeatAt
+			if err := db.Create(&run).Error; err != nil {
+				t.Fatalf("create run: %v", err)
+			}
+			for userID, status := range tt.items {
+				if err := db.Create(&model.JobRunItem{JobRunID: run.ID, UserID: userID, Status: status}).Error; err != nil {
+					t.Fatalf("create item: %v", err)
+				}
+			}
+
+			if err := runner.Resume(ctx, Fence{}); err != nil {
+				t.Fatalf("Resume: %v", err)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			var got model.JobRun
+			if err := db.First(&got, run.ID).Error; err != nil {
+				t.Fatalf("load run: %v", err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantStatus)
+			}
+			if tt.wantStatus == model.JobSucceeded && got.Succeeded != len(tt.items) {
+				t.Errorf("succeeded = %d, want %d", got.Succeeded, len(tt.items))
+			}
+		})
+	}
+}
+
+func TestJobRunnerRunOnce(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	runner := NewJobRunner(db)
+	fail := true
+	var calls []uint64
+	runner.Register("test_job", func(ctx context.Context, local time.Time, userID uint64) error {
+		calls = append(calls, userID)
+		if userID == 2 && fail {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	local := time.Date(2024, 3, 14, 20, 0, 0, 0, time.UTC)
+	if err := runner.Run(ctx, Fence{}, "test_job", "family:1", local, []uint64{1, 2, 3}); err == nil {
+		t.Fatal("Run succeeded, want the failure of user 2")
+	}
+	// 同一天再次调度不会重复执行
+	if err := runner.Run(ctx, Fence{}, "test_job", "family:1", local, []uint64{1, 2, 3}); err != nil {
+		t.Fatalf("second Run: %v", err)
+	}
+	fail = false
+	if err := runner.Resume(ctx, Fence{}); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+
+	if want := []uint64{1, 2, 3, 2}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestJobRunnerStopsAfterLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	lease := NewDBLease(db)
+	epoch, err := lease.Acquire(ctx, "test_lease", "old", time.Millisecond)
+	if err != nil || epoch != 1 {
+		t.Fatalf("Acquire = %d, %v, want epoch 1", epoch, err)
+	}
+	fence := Fence{Lease: "test_lease", Epoch: epoch}
+
+	runner := NewJobRunner(db)
+	var calls []uint64
+	runner.Register("test_job", func(ctx context.Context, local time.Time, userID uint64) error {
+		calls = append(calls, userID)
+		if userID == 1 {
+			// 处理第一个用户时停顿，租约过期后被其他实例接手
+			time.Sleep(5 * time.Millisecond)
+			epoch, err := lease.Acquire(ctx, "test_lease", "new", time.Minute)
+			if err != nil || epoch != 2 {
+				t.Fatalf("takeover Acquire = %d, %v, want epoch 2", epoch, err)
+			}
+		}
+		return nil
+	})
+
+	local := time.Date(2024, 3, 14, 20, 0, 0, 0, time.UTC)
+	err = runner.Run(ctx, fence, "test_job", "family:1", local, []uint64{1, 2, 3})
+	if !errors.Is(err, errLeaseLost) {
+		t.Fatalf("Run = %v, want errLeaseLost", err)
+	}
+	if want := []uint64{1}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+
+	var items []model.JobRunItem
+	if err := db.Order("user_id").Find(&items).Error; err != nil {
+		t.Fatalf("load items: %v", err)
+	}
+	for _, item := range items {
+		if item.Status != model.JobPending {
+			t.Errorf("user %d status = %s, want %s", item.UserID, item.Status, model.JobPending)
+		}
+	}
+	// 旧领导者不能再创建任务
+	if err := runner.Run(ctx, fence, "test_job", "family:2", local, []uint64{1}); !errors.Is(err, errLeaseLost) {
+		t.Errorf("Run with stale fence = %v, want errLeaseLost", err)
+	}
+}
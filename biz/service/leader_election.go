@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/sweekar/biz/model"
+)
+
+// defaultLeaseTTL 默认租约时长，领导者每过三分之一时长续约一次
+const defaultLeaseTTL = 30 * time.Second
+
+// errLeaseLost 租约已被其他实例接手，原领导者的写入不再生效
+var errLeaseLost = errors.New("已失去领导权")
+
+// Lease 选主使用的租约存储。Acquire在租约空闲、已过期或已由holder持有时获取或续约租约并返回当前任期，
+// 其他实例持有未过期的租约时返回0；任期在租约每次被其他实例接手时增加，续约时不变。Release释放holder持有的租约。
+// 可以用数据库行实现，也可以用Redis的SET NX PX加校验持有者的续约脚本、用INCR生成任期实现
+type Lease interface {
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (uint64, error)
+	Release(ctx context.Context, name, holder string) error
+}
+
+// Fence 领导者的防护令牌，即领导者获取租约时的任期。带令牌的条件更新只在租约仍处于该任期时生效，
+// 避免因停顿而失去领导权的旧领导者覆盖新领导者的写入；零值不做校验
+type Fence struct {
+	Lease string
+	Epoch uint64
+}
+
+// scope 为更新语句加上租约仍处于该任期的条件
+func (f Fence) scope(db *gorm.DB) *gorm.DB {
+	if f.Epoch == 0 {
+		return db
+	}
+	return db.Where("EXISTS (?)", db.Session(&gorm.Session{NewDB: true}).Model(&model.LeaderLease{}).
+		Select("1").Where("name = ? AND epoch = ?", f.Lease, f.Epoch))
+}
+
+// check 在tx所在的事务中确认租约仍处于该任期
+func (f Fence) check(tx *gorm.DB) error {
+	if f.Epoch == 0 {
+		return nil
+	}
+	var count int64
+	err := tx.Model(&model.LeaderLease{}).Where("name = ? AND epoch = ?", f.Lease, f.Epoch).Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("查询租约失败: %v", err)
+	}
+	if count == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// DBLease 基于数据库行的租约
+type DBLease struct {
+	db *gorm.DB
+}
+
+// NewDBLease 创建数据库租约
+func NewDBLease(db *gorm.DB) *DBLease {
+	return &DBLease{db: db}
+}
+
+// Acquire 获取或续约租约，返回当前任期，未获取到时返回0
+func (l *DBLease) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (uint64, error) {
+	now := time.Now()
+	result := l.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&model.LeaderLease{
+		Name:      name,
+		Holder:    holder,
+		Epoch:     1,
+		ExpiresAt: now.Add(ttl),
+	})
+	if result.Error != nil {
+		return 0, fmt.Errorf("创建租约失败: %v", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return 1, nil
+	}
+
+	// 租约已存在，续约自己的租约，任期不变
+	result = l.db.WithContext(ctx).Model(&model.LeaderLease{}).
+		Where("name = ? AND holder = ?", name, holder).
+		Update("expires_at", now.Add(ttl))
+	if result.Error != nil {
+		return 0, fmt.Errorf("续约租约失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// 接手已过期的租约，进入新的任期
+		result = l.db.WithContext(ctx).Model(&model.LeaderLease{}).
+			Where("name = ? AND holder <> ? AND expires_at <= ?", name, holder, now).
+			Updates(map[string]interface{}{
+				"holder":     holder,
+				"epoch":      gorm.Expr("epoch + 1"),
+				"expires_at": now.Add(ttl),
+			})
+		if result.Error != nil {
+			return 0, fmt.Errorf("接手租约失败: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return 0, nil
+		}
+	}
+
+	var lease model.LeaderLease
+	err := l.db.WithContext(ctx).Where("name = ? AND holder = ?", name, holder).Take(&lease).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("查询租约失败: %v", err)
+	}
+	return lease.Epoch, nil
+}
+
+// Release 释放租约，其他实例可以立即接手。只让租约过期而不删除，保留任期使接手的实例进入新的任期
+func (l *DBLease) Release(ctx context.Context, name, holder string) error {
+	err := l.db.WithContext(ctx).Model(&model.LeaderLease{}).
+		Where("name = ? AND holder = ?", name, holder).
+		Update("expires_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("释放租约失败: %v", err)
+	}
+	return nil
+}
+
+// LeaderElection 通过租约在多个实例中选出一个领导者，领导者定时续约，续约失败时立即退位，
+// 领导者宕机后其他实例在租约过期后接手
+type LeaderElection struct {
+	lease  Lease
+	name   string
+	holder string
+	ttl    time.Duration
+	epoch  atomic.Uint64 // 持有租约时的任期，未持有时为0
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewLeaderElection 创建选主，ttl为0时使用默认租约时长；持有者ID由主机名（k8s中为Pod名）和随机后缀组成
+func NewLeaderElection(lease Lease, name string, ttl time.Duration) *LeaderElection {
+	if ttl <= 0 {
+		ttl = defaultLeaseTTL
+	}
+	return &LeaderElection{
+		lease:  lease,
+		name:   name,
+		holder: newLeaseHolder(),
+		ttl:    ttl,
+	}
+}
+
+// newLeaseHolder 生成实例的持有者ID
+func newLeaseHolder() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// Start 开始参与选主
+func (e *LeaderElection) Start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan struct{})
+	go e.run(ctx, e.done)
+}
+
+// Stop 停止参与选主，是领导者时释放租约
+func (e *LeaderElection) Stop() {
+	e.mu.Lock()
+	cancel, done := e.cancel, e.done
+	e.cancel, e.done = nil, nil
+	e.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+	e.epoch.Store(0)
+
+	// 只会释放自己持有的租约，停止时正在续约的请求被取消也不影响释放
+	ctx, cancelRelease := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelRelease()
+	if err := e.lease.Release(ctx, e.name, e.holder); err != nil {
+		logs.Error("release lease %s error: %v", e.name, err)
+	}
+}
+
+// IsLeader 当前实例是否为领导者
+func (e *LeaderElection) IsLeader() bool {
+	return e.epoch.Load() != 0
+}
+
+// Fence 当前任期的防护令牌，不是领导者时返回false
+func (e *LeaderElection) Fence() (Fence, bool) {
+	epoch := e.epoch.Load()
+	return Fence{Lease: e.name, Epoch: epoch}, epoch != 0
+}
+
+// Holds 当前实例是否仍在fence对应的任期内担任领导者
+func (e *LeaderElection) Holds(fence Fence) bool {
+	return fence.Epoch != 0 && e.epoch.Load() == fence.Epoch
+}
+
+// run 定时获取或续约租约
+func (e *LeaderElection) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.renew(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew 获取或续约一次租约，单次请求不超过租约时长的三分之一，出错时按未持有租约处理
+func (e *LeaderElection) renew(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, e.ttl/3)
+	defer cancel()
+
+	epoch, err := e.lease.Acquire(ctx, e.name, e.holder, e.ttl)
+	if parent.Err() != nil {
+		// 正在停止，由Stop释放租约
+		return
+	}
+	if err != nil {
+		logs.Error("acquire lease %s error: %v", e.name, err)
+		epoch = 0
+	}
+	if was := e.epoch.Swap(epoch); was != 0 && was != epoch {
+		logs.Error("lost lease %s epoch %d, holder %s stepped down", e.name, was, e.holder)
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestDBLease(t *testing.T) {
+	type step struct {
+		holder    string
+		release   bool // 释放租约而不是获取
+		sleep     time.Duration
+		wantEpoch uint64
+	}
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		steps []step
+	}{
+		{
+			name: "首次获取进入第一个任期，续约任期不变",
+			ttl:  time.Minute,
+			steps: []step{
+				{holder: "a", wantEpoch: 1},
+				{holder: "a", wantEpoch: 1},
+			},
+		},
+		{
+			name: "租约未过期时其他实例获取不到",
+			ttl:  time.Minute,
+			steps: []step{
+				{holder: "a", wantEpoch: 1},
+				{holder: "b", wantEpoch: 0},
+			},
+		},
+		{
+			name: "租约过期后被其他实例接手，任期加一",
+			ttl:  time.Millisecond,
+			steps: []step{
+				{holder: "a", wantEpoch: 1},
+				{holder: "b", sleep: 5 * time.Millisecond, wantEpoch: 2},
+				{holder: "a", wantEpoch: 0},
+			},
+		},
+		{
+			name: "释放后其他实例立即接手，任期不会回到1",
+			ttl:  time.Minute,
+			steps: []step{
+				{holder: "a", wantEpoch: 1},
+				{holder: "a", release: true},
+				{holder: "b", wantEpoch: 2},
+				{holder: "b", release: true},
+				{holder: "a", wantEpoch: 3},
+			},
+		},
+		{
+			name: "只能释放自己持有的租约",
+			ttl:  time.Minute,
+			steps: []step{
+				{holder: "a", wantEpoch: 1},
+				{holder: "b", release: true},
+				{holder: "b", wantEpoch: 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			lease := NewDBLease(newTestDB(t))
+			for i, s := range tt.steps {
+				time.Sleep(s.sleep)
+				if s.release {
+					if err := lease.Release(ctx, "test_lease", s.holder); err != nil {
+						t.Fatalf("step %d Release: %v", i, err)
+					}
+					continue
+				}
+				epoch, err := lease.Acquire(ctx, "test_lease", s.holder, tt.ttl)
+				if err != nil {
+					t.Fatalf("step %d Acquire: %v", i, err)
+				}
+				if epoch != s.wantEpoch {
+					t.Errorf("step %d %s epoch = %d, want %d", i, s.holder, epoch, s.wantEpoch)
+				}
+			}
+		})
+	}
+}
+
+func TestFenceAfterRelease(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	lease := NewDBLease(db)
+	epoch, err := lease.Acquire(ctx, "test_lease", "old", time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	stale := Fence{Lease: "test_lease", Epoch: epoch}
+	if err := db.Create(&model.JobControl{Job: "test_job"}).Error; err != nil {
+		t.Fatalf("create control: %v", err)
+	}
+
+	// 旧领导者退位后新领导者接手，旧领导者仍在执行的写入不再生效
+	if err := lease.Release(ctx, "test_lease", "old"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	epoch, err = lease.Acquire(ctx, "test_lease", "new", time.Minute)
+	if err != nil {
+		t.Fatalf("takeover Acquire: %v", err)
+	}
+	current := Fence{Lease: "test_lease", Epoch: epoch}
+
+	if err := stale.check(db); err != errLeaseLost {
+		t.Errorf("stale check = %v, want errLeaseLost", err)
+	}
+	if err := current.check(db); err != nil {
+		t.Errorf("current check = %v, want nil", err)
+	}
+	for _, tt := range []struct {
+		fence Fence
+		want  int64
+	}{{stale, 0}, {current, 1}} {
+		result := db.Model(&model.JobControl{}).Scopes(tt.fence.scope).Where("job = ?", "test_job").Update("paused", true)
+		if result.Error != nil {
+			t.Fatalf("update with epoch %d: %v", tt.fence.Epoch, result.Error)
+		}
+		if result.RowsAffected != tt.want {
+			t.Errorf("update with epoch %d affected %d rows, want %d", tt.fence.Epoch, result.RowsAffected, tt.want)
+		}
+	}
+}
+
+func TestLeaderElectionStop(t *testing.T) {
+	lease := NewDBLease(newTestDB(t))
+	a := NewLeaderElection(lease, "test_lease", time.Minute)
+	b := NewLeaderElection(lease, "test_lease", time.Minute)
+
+	a.renew(context.Background())
+	b.renew(context.Background())
+	fence, ok := a.Fence()
+	if !ok || b.IsLeader() {
+		t.Fatalf("a leader = %v, b leader = %v, want only a", ok, b.IsLeader())
+	}
+
+	// Stop只在选主运行时释放租约
+	a.Start()
+	a.Stop()
+	b.renew(context.Background())
+	if a.IsLeader() || a.Holds(fence) {
+		t.Error("a still leader after Stop")
+	}
+	next, ok := b.Fence()
+	if !ok || next.Epoch != fence.Epoch+1 {
+		t.Errorf("b fence = %+v, %v, want epoch %d", next, ok, fence.Epoch+1)
+	}
+}
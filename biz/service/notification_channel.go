@@ -0,0 +1,210 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/websocket"
+)
+
+// errGuardiansOffline 孩子的监护人都不在线，稍后重试
+var errGuardiansOffline = errors.New("监护人都不在线")
+
+// NotificationSender 通知渠道，返回ErrChannelNotConfigured时不再重试
+type NotificationSender interface {
+	// Channel 渠道名
+	Channel() model.NotificationChannel
+	// Send 按家庭的通知设置发送一条通知
+	Send(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings) error
+}
+
+// WebSocketSender 通过家长端的WebSocket连接发送通知
+type WebSocketSender struct {
+	pool *websocket.Pool
+}
+
+// NewWebSocketSender 创建WebSocket通知渠道
+func NewWebSocketSender(pool *websocket.Pool) *WebSocketSender {
+	return &WebSocketSender{pool: pool}
+}
+
+// Channel 渠道名
+func (s *WebSocketSender) Channel() model.NotificationChannel {
+	return model.ChannelWebSocket
+}
+
+// Send 发送给孩子所有在线的监护人，至少一个监护人收到即为送达，都不在线时返回错误以便重试
+func (s *WebSocketSender) Send(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings) error {
+	if len(s.pool.GetGuardianClients(notification.UserID)) == 0 {
+		return errGuardiansOffline
+	}
+
+	// 通知类型即家长端的消息类型
+	msgData, err := json.Marshal(websocket.Message{
+		Type:    websocket.MessageType(notification.Kind),
+		Payload: json.RawMessage(notification.Payload),
+	})
+	if err != nil {
+		return fmt.Errorf("序列化通知失败: %v", err)
+	}
+	sent, err := s.pool.SendToGuardians(notification.UserID, msgData)
+	if sent == 0 {
+		if err == nil {
+			err = errGuardiansOffline
+		}
+		return err
+	}
+	if err != nil {
+		logs.Error("部分监护人推送通知失败: %v", err)
+	}
+	return nil
+}
+
+// SMTPConfig 发送通知邮件的SMTP服务器配置
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // 为空时不认证
+	Password string
+	From     string // 发件人地址
+}
+
+// EmailSender 通过SMTP发送通知邮件
+type EmailSender struct {
+	config SMTPConfig
+}
+
+// NewEmailSender 创建邮件通知渠道
+func NewEmailSender(config *SMTPConfig) *EmailSender {
+	return &EmailSender{config: *config}
+}
+
+// Channel 渠道名
+func (s *EmailSender) Channel() model.NotificationChannel {
+	return model.ChannelEmail
+}
+
+// Send 发送纯文本邮件到家庭设置的邮箱
+func (s *EmailSender) Send(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings) error {
+	if settings.Email == "" {
+		return fmt.Errorf("%w: 未设置接收通知的邮箱", ErrChannelNotConfigured)
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+	addr := s.config.Host + ":" + strconv.Itoa(s.config.Port)
+	if err := smtp.SendMail(addr, auth, s.config.From, []string{settings.Email}, s.message(notification, settings.Email)); err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	return nil
+}
+
+// message 组装邮件，标题和正文按UTF-8编码
+func (s *EmailSender) message(notification *model.Notification, to string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + s.config.From + "\r\n")
+	buf.WriteString("To: " + to + "\r\n")
+	buf.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", notification.Title) + "\r\n")
+	buf.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	// 每行不超过76个字符
+	body := base64.StdEncoding.EncodeToString([]byte(notification.Content))
+	for len(body) > 76 {
+		buf.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	buf.WriteString(body + "\r\n")
+	return buf.Bytes()
+}
+
+// webhookPayload Webhook请求内容
+type webhookPayload struct {
+	Event          string          `json:"event"`           // 通知类型
+	NotificationID uint64          `json:"notification_id"` // 通知ID，重试时不变，可用于去重
+	UserID         uint64          `json:"user_id"`         // 孩子的用户ID
+	Title          string          `json:"title"`           // 标题
+	Content        string          `json:"content"`         // 正文
+	Data           json.RawMessage `json:"data"`            // 原始消息内容，如情绪报告
+	SentAt         time.Time       `json:"sent_at"`         // 发送时间
+}
+
+// WebhookSender 将通知以JSON POST到家庭设置的Webhook地址，设置了密钥时在X-Sweekar-Signature头中携带HMAC-SHA256签名
+type WebhookSender struct {
+	client *http.Client
+}
+
+// NewWebhookSender 创建Webhook通知渠道
+func NewWebhookSender(timeout time.Duration) *WebhookSender {
+	return &WebhookSender{client: &http.Client{Timeout: timeout}}
+}
+
+// Channel 渠道名
+func (s *WebhookSender) Channel() model.NotificationChannel {
+	return model.ChannelWebhook
+}
+
+// Send 发送通知，响应不是2xx时返回错误以便重试
+func (s *WebhookSender) Send(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings) error {
+	if settings.WebhookURL == "" {
+		return fmt.Errorf("%w: 未设置Webhook地址", ErrChannelNotConfigured)
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		Event:          notification.Kind,
+		NotificationID: notification.ID,
+		UserID:         notification.UserID,
+		Title:          notification.Title,
+		Content:        notification.Content,
+		Data:           json.RawMessage(notification.Payload),
+		SentAt:         time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("序列化通知失败: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, settings.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: Webhook地址无效: %v", ErrChannelNotConfigured, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sweekar-Event", notification.Kind)
+	if settings.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(settings.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Sweekar-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求Webhook失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("Webhook返回%d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/mq"
+)
+
+const (
+	// reportPushTopic 情绪报告推送消息的主题
+	reportPushTopic = "emotion_report_push"
+	// notificationKindReport 情绪报告通知
+	notificationKindReport = "emotion_report"
+	// deliveryClaimTimeout 发送中的通知超过该时长没有结果时可以重新发送
+	deliveryClaimTimeout = 2 * time.Minute
+	// deliveryBatchSize 每次检查的待重试通知数
+	deliveryBatchSize = 100
+)
+
+// deliveryRetryDelays 各次重试前的等待时间，重试用完后渠道记为失败
+var deliveryRetryDelays = []time.Duration{time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+var (
+	// ErrInvalidNotificationSettings 通知设置无效
+	ErrInvalidNotificationSettings = errors.New("通知设置无效")
+	// ErrChannelNotConfigured 通知渠道缺少必要的设置，重试也不会成功
+	ErrChannelNotConfigured = errors.New("通知渠道未配置")
+)
+
+// NotificationConfig 通知服务配置
+type NotificationConfig struct {
+	Workers       int           // 消费推送消息的协程数
+	RetryInterval time.Duration // 检查待重试通知的间隔
+}
+
+// DefaultNotificationConfig 默认通知服务配置
+var DefaultNotificationConfig = NotificationConfig{
+	Workers:       4,
+	RetryInterval: 30 * time.Second,
+}
+
+// NotificationSettingsInput 家长提交的通知设置
+type NotificationSettingsInput struct {
+	Channels      []model.NotificationChannel `json:"channels"`       // 同时使用的渠道
+	Fallbacks     []model.NotificationChannel `json:"fallbacks"`      // 所有渠道都失败后依次尝试的备用渠道
+	Email         string                      `json:"email"`          // 接收通知的邮箱
+	WebhookURL    string                      `json:"webhook_url"`    // 接收通知的Webhook地址
+	WebhookSecret string                      `json:"webhook_secret"` // Webhook签名密钥，为空时不签名
+}
+
+// NotificationService 消费情绪报告推送消息，按家庭设置的渠道通知家长；
+// 失败的渠道按deliveryRetryDelays重试，所有渠道都失败后依次使用备用渠道，每个渠道的送达情况都有记录
+type NotificationService struct {
+	db       *gorm.DB
+	bus      mq.Bus
+	families *FamilyService
+	senders  map[model.NotificationChannel]NotificationSender
+	config   NotificationConfig
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewNotificationService 创建通知服务，bus需要能收到发件箱发送的推送消息，由调用方启动和停止
+func NewNotificationService(db *gorm.DB, bus mq.Bus, families *FamilyService, config *NotificationConfig, senders ...NotificationSender) *NotificationService {
+	if config == nil {
+		config = &DefaultNotificationConfig
+	}
+	s := &NotificationService{
+		db:       db,
+		bus:      bus,
+		families: families,
+		senders:  make(map[model.NotificationChannel]NotificationSender, len(senders)),
+		config:   *config,
+	}
+	for _, sender := range senders {
+		s.senders[sender.Channel()] = sender
+	}
+	return s
+}
+
+// Start 开始消费推送消息并定期重试失败的通知
+func (s *NotificationService) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	if err := s.bus.ConsumeMessage(ctx, reportPushTopic, s.config.Workers, s.handleReportPush); err != nil {
+		cancel()
+		return fmt.Errorf("订阅推送消息失败: %v", err)
+	}
+
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.retryLoop(ctx)
+	return nil
+}
+
+// Stop 停止重试通知
+func (s *NotificationService) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+// retryLoop 定期发送到了重试时间的通知
+func (s *NotificationService) retryLoop(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.RetryDue(ctx); err != nil {
+				logs.Error("重试通知失败: %v", err)
+			}
+		}
+	}
+}
+
+// handleReportPush 处理一条情绪报告推送消息
+func (s *NotificationService) handleReportPush(ctx context.Context, data []byte) error {
+	var report model.EmotionReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		// 无法解析的消息重试也不会成功
+		logs.Error("解析推送消息失败: %v", err)
+		return nil
+	}
+
+	// 重新推送的报告推送时间不同，会再通知一次
+	var pushedAt int64
+	if report.PushedAt != nil {
+		pushedAt = report.PushedAt.UnixNano()
+	}
+	return s.Notify(ctx, &model.Notification{
+		Key:     fmt.Sprintf("%s:%d:%d", notificationKindReport, report.ID, pushedAt),
+		UserID:  report.UserID,
+		Kind:    notificationKindReport,
+		Title:   fmt.Sprintf("孩子%s的情绪报告", report.Period.Label()),
+		Content: report.Summary,
+		Payload: data,
+	})
+}
+
+// Notify 按孩子所在家庭的设置发送通知，Key相同的通知只发送一次
+func (s *NotificationService) Notify(ctx context.Context, notification *model.Notification) error {
+	family, err := s.families.GetFamilyByUser(ctx, notification.UserID)
+	if err == nil {
+		notification.FamilyID = family.ID
+	} else if !errors.Is(err, ErrFamilyNotFound) {
+		return err
+	}
+	settings, err := s.settings(ctx, notification.FamilyID)
+	if err != nil {
+		return err
+	}
+	notification.Fallbacks = settings.Fallbacks
+
+	now := time.Now()
+	var deliveries []model.NotificationDelivery
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(notification)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		for _, channel := range settings.Channels {
+			deliveries = append(deliveries, model.NotificationDelivery{
+				NotificationID: notification.ID,
+				Channel:        channel,
+				Status:         model.DeliveryPending,
+				NextAttemptAt:  now,
+			})
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		return tx.Create(&deliveries).Error
+	})
+	if err != nil {
+		return fmt.Errorf("保存通知失败: %v", err)
+	}
+
+	// 重复的消息没有新建送达记录，未完成的渠道由RetryDue继续
+	for i := range deliveries {
+		if err := s.attempt(ctx, notification, settings, &deliveries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryDue 发送到了重试时间的通知
+func (s *NotificationService) RetryDue(ctx context.Context) error {
+	var deliveries []model.NotificationDelivery
+	err := s.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", model.DeliveryPending, time.Now()).
+		Order("id").Limit(deliveryBatchSize).Find(&deliveries).Error
+	if err != nil {
+		return fmt.Errorf("查询待重试通知失败: %v", err)
+	}
+
+	var errs []error
+	for i := range deliveries {
+		var notification model.Notification
+		if err := s.db.WithContext(ctx).First(&notification, deliveries[i].NotificationID).Error; err != nil {
+			errs = append(errs, fmt.Errorf("查询通知 %d 失败: %v", deliveries[i].NotificationID, err))
+			continue
+		}
+		settings, err := s.settings(ctx, notification.FamilyID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.attempt(ctx, &notification, settings, &deliveries[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attempt 发送一次通知并记录结果，渠道最终失败时尝试下一个备用渠道
+func (s *NotificationService) attempt(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings, delivery *model.NotificationDelivery) error {
+	// 以条件更新的方式接手，避免多个实例同时发送；发送中断时超时后重新发送
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&model.NotificationDelivery{}).
+		Where("id = ? AND attempts = ? AND status = ?", delivery.ID, delivery.Attempts, model.DeliveryPending).
+		Updates(map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"next_attempt_at": now.Add(deliveryClaimTimeout),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("接手通知 %d 失败: %v", delivery.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	delivery.Attempts++
+
+	var sendErr error
+	if sender, ok := s.senders[delivery.Channel]; ok {
+		sendErr = sender.Send(ctx, notification, settings)
+	} else {
+		sendErr = fmt.Errorf("%w: 不支持的渠道%s", ErrChannelNotConfigured, delivery.Channel)
+	}
+
+	now = time.Now()
+	switch {
+	case sendErr == nil:
+		delivery.Status, delivery.LastError, delivery.DeliveredAt = model.DeliverySent, "", &now
+	case errors.Is(sendErr, ErrChannelNotConfigured) || delivery.Attempts > len(deliveryRetryDelays):
+		delivery.Status, delivery.LastError = model.DeliveryFailed, sendErr.Error()
+	default:
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = now.Add(deliveryRetryDelays[delivery.Attempts-1])
+	}
+	if err := s.db.WithContext(ctx).Model(delivery).Updates(map[string]interface{}{
+		"status":          delivery.Status,
+		"last_error":      delivery.LastError,
+		"next_attempt_at": delivery.NextAttemptAt,
+		"delivered_at":    delivery.DeliveredAt,
+	}).Error; err != nil {
+		return fmt.Errorf("更新通知 %d 送达状态失败: %v", delivery.ID, err)
+	}
+
+	if delivery.Status != model.DeliveryFailed {
+		return nil
+	}
+	return s.fallback(ctx, notification, settings)
+}
+
+// fallback 通知在所有已使用的渠道上都失败时，使用下一个还没有用过的备用渠道
+func (s *NotificationService) fallback(ctx context.Context, notification *model.Notification, settings *model.NotificationSettings) error {
+	var deliveries []model.NotificationDelivery
+	if err := s.db.WithContext(ctx).Where("notification_id = ?", notification.ID).Find(&deliveries).Error; err != nil {
+		return fmt.Errorf("查询通知 %d 送达记录失败: %v", notification.ID, err)
+	}
+	used := make(map[model.NotificationChannel]bool, len(deliveries))
+	for _, delivery := range deliveries {
+		if delivery.Status != model.DeliveryFailed {
+			return nil
+		}
+		used[delivery.Channel] = true
+	}
+
+	for _, channel := range notification.Fallbacks {
+		if used[channel] {
+			continue
+		}
+		delivery := model.NotificationDelivery{
+			NotificationID: notification.ID,
+			Channel:        channel,
+			Fallback:       true,
+			Status:         model.DeliveryPending,
+			NextAttemptAt:  time.Now(),
+		}
+		result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery)
+		if result.Error != nil {
+			return fmt.Errorf("创建备用渠道送达记录失败: %v", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return s.attempt(ctx, notification, settings, &delivery)
+	}
+	return nil
+}
+
+// settings 家庭的通知设置，未设置时使用默认设置
+func (s *NotificationService) settings(ctx context.Context, familyID uint64) (*model.NotificationSettings, error) {
+	var settings model.NotificationSettings
+	err := s.db.WithContext(ctx).Where("family_id = ?", familyID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.DefaultNotificationSettings(familyID), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询通知设置失败: %v", err)
+	}
+	return &settings, nil
+}
+
+// GetSettings 获取用户所在家庭的通知设置
+func (s *NotificationService) GetSettings(ctx context.Context, userID uint64) (*model.NotificationSettings, error) {
+	family, err := s.families.GetFamilyByUser(ctx, userID)
+	if errors.Is(err, ErrFamilyNotFound) {
+		return model.DefaultNotificationSettings(0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.settings(ctx, family.ID)
+}
+
+// UpdateSettings 更新用户所在家庭的通知设置，用户还没有家庭时为其创建一个
+func (s *NotificationService) UpdateSettings(ctx context.Context, userID uint64, input *NotificationSettingsInput) (*model.NotificationSettings, error) {
+	if err := s.validateSettings(input); err != nil {
+		return nil, err
+	}
+
+	family, err := s.families.ManagedFamilyByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &model.NotificationSettings{
+		FamilyID:      family.ID,
+		Channels:      input.Channels,
+		Fallbacks:     input.Fallbacks,
+		Email:         input.Email,
+		WebhookURL:    input.WebhookURL,
+		WebhookSecret: input.WebhookSecret,
+	}
+	if err := s.db.WithContext(ctx).Save(settings).Error; err != nil {
+		return nil, fmt.Errorf("更新通知设置失败: %v", err)
+	}
+	return settings, nil
+}
+
+// validateSettings 校验渠道是否可用以及渠道需要的邮箱和Webhook地址
+func (s *NotificationService) validateSettings(input *NotificationSettingsInput) error {
+	if len(input.Channels) == 0 {
+		return fmt.Errorf("%w: 至少选择一个通知渠道", ErrInvalidNotificationSettings)
+	}
+
+	used := make(map[model.NotificationChannel]bool)
+	for _, channel := range append(append([]model.NotificationChannel{}, input.Channels...), input.Fallbacks...) {
+		if _, ok := s.senders[channel]; !ok {
+			return fmt.Errorf("%w: 不支持的通知渠道%q", ErrInvalidNotificationSettings, channel)
+		}
+		if used[channel] {
+			return fmt.Errorf("%w: 通知渠道%q重复", ErrInvalidNotificationSettings, channel)
+		}
+		used[channel] = true
+	}
+
+	if used[model.ChannelEmail] {
+		if _, err := mail.ParseAddress(input.Email); err != nil {
+			return fmt.Errorf("%w: 邮箱%q无效", ErrInvalidNotificationSettings, input.Email)
+		}
+	}
+	if used[model.ChannelWebhook] {
+		u, err := url.Parse(input.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: Webhook地址%q无效", ErrInvalidNotificationSettings, input.WebhookURL)
+		}
+	}
+	return nil
+}
+
+// ListNotifications 分页获取用户所在家庭收到的通知及各渠道的送达情况，按时间从新到旧排列
+func (s *NotificationService) ListNotifications(ctx context.Context, userID uint64, page, pageSize int) ([]model.Notification, int64, error) {
+	query := s.db.WithContext(ctx).Model(&model.Notification{})
+	family, err := s.families.GetFamilyByUser(ctx, userID)
+	switch {
+	case err == nil:
+		query = query.Where("family_id = ?", family.ID)
+	case errors.Is(err, ErrFamilyNotFound):
+		query = query.Where("family_id = 0 AND user_id = ?", userID)
+	default:
+		return nil, 0, err
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("统计通知失败: %v", err)
+	}
+
+	var notifications []model.Notification
+	if err := query.Preload("Deliveries", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&notifications).Error; err != nil {
+		return nil, 0, fmt.Errorf("获取通知失败: %v", err)
+	}
+	return notifications, total, nil
+}
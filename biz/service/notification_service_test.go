@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+// notificationFixture 一个家长和孩子，三个渠道都使用fakeSender
+type notificationFixture struct {
+	db            *gorm.DB
+	notifications *NotificationService
+	senders       map[model.NotificationChannel]*fakeSender
+	parent        *model.User
+	child         *model.User
+}
+
+func newNotificationFixture(t *testing.T, input *NotificationSettingsInput) *notificationFixture {
+	t.Helper()
+	ctx := context.Background()
+	db := newTestDB(t)
+	families := NewFamilyService(db)
+	users := NewUserService(db, families)
+
+	f := &notificationFixture{db: db, senders: make(map[model.NotificationChannel]*fakeSender)}
+	var senders []NotificationSender
+	for _, channel := range []model.NotificationChannel{model.ChannelWebSocket, model.ChannelEmail, model.ChannelWebhook} {
+		f.senders[channel] = &fakeSender{channel: channel}
+		senders = append(senders, f.senders[channel])
+	}
+	f.notifications = NewNotificationService(db, nil, families, nil, senders...)
+
+	name := "parent"
+	f.parent = &model.User{Username: &name, Password: "secret123", Email: "parent@example.com"}
+	if err := users.Register(ctx, f.parent); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	child, err := users.CreateChild(ctx, f.parent.ID, &ChildInput{Name: "小明"})
+	if err != nil {
+		t.Fatalf("CreateChild: %v", err)
+	}
+	f.child = child
+
+	input.Email, input.WebhookURL = "parent@example.com", "https://example.com/hook"
+	if _, err := f.notifications.UpdateSettings(ctx, f.parent.ID, input); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	return f
+}
+
+// notify 以key给孩子的家庭发送一条通知
+func (f *notificationFixture) notify(t *testing.T, key string) {
+	t.Helper()
+	err := f.notifications.Notify(context.Background(), &model.Notification{Key: key, UserID: f.child.ID, Kind: notificationKindReport, Title: "孩子本周的情绪报告"})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+}
+
+// deliveries 家长在通知列表中看到的第一条通知的送达记录
+func (f *notificationFixture) deliveries(t *testing.T) []model.NotificationDelivery {
+	t.Helper()
+	notifications, _, err := f.notifications.ListNotifications(context.Background(), f.parent.ID, 1, 10)
+	if err != nil {
+		t.Fatalf("ListNotifications: %v", err)
+	}
+	if len(notifications) == 0 {
+		t.Fatal("no notification")
+	}
+	return notifications[0].Deliveries
+}
+
+// due 把等待重试的送达记录提前到已到重试时间
+func (f *notificationFixture) due(t *testing.T) {
+	t.Helper()
+	err := f.db.Model(&model.NotificationDelivery{}).Where("status = ?", model.DeliveryPending).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error
+	if err != nil {
+		t.Fatalf("update next_attempt_at: %v", err)
+	}
+}
+
+func TestNotificationRetrySchedule(t *testing.T) {
+	ctx := context.Background()
+	f := newNotificationFixture(t, &NotificationSettingsInput{Channels: []model.NotificationChannel{model.ChannelEmail}})
+	f.senders[model.ChannelEmail].err = errors.New("smtp timeout")
+
+	// 每次失败后按deliveryRetryDelays推迟下一次发送
+	start := time.Now()
+	f.notify(t, "retry")
+	for i, delay := range deliveryRetryDelays {
+		if i > 0 {
+			// 还没到重试时间时不发送
+			if err := f.notifications.RetryDue(ctx); err != nil {
+				t.Fatalf("RetryDue: %v", err)
+			}
+			if got := f.deliveries(t)[0].Attempts; got != i {
+				t.Fatalf("attempts before due = %d, want %d", got, i)
+			}
+			f.due(t)
+			start = time.Now()
+			if err := f.notifications.RetryDue(ctx); err != nil {
+				t.Fatalf("RetryDue: %v", err)
+			}
+		}
+		delivery := f.deliveries(t)[0]
+		if delivery.Status != model.DeliveryPending || delivery.Attempts != i+1 || delivery.LastError != "smtp timeout" {
+			t.Fatalf("attempt %d: delivery = %+v, want pending with error", i+1, delivery)
+		}
+		if next := delivery.NextAttemptAt.Sub(start); next < delay || next > delay+time.Minute {
+			t.Errorf("attempt %d: next attempt after %v, want %v", i+1, next, delay)
+		}
+	}
+
+	// 重试用完后记为失败，不再发送
+	f.due(t)
+	if err := f.notifications.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue: %v", err)
+	}
+	delivery := f.deliveries(t)[0]
+	if delivery.Status != model.DeliveryFailed || delivery.Attempts != len(deliveryRetryDelays)+1 {
+		t.Fatalf("after retries: delivery = %+v, want failed", delivery)
+	}
+	f.due(t)
+	if err := f.notifications.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue: %v", err)
+	}
+	if got := f.deliveries(t)[0].Attempts; got != len(deliveryRetryDelays)+1 {
+		t.Errorf("failed delivery was retried: attempts = %d", got)
+	}
+}
+
+func TestNotificationRetrySucceeds(t *testing.T) {
+	ctx := context.Background()
+	f := newNotificationFixture(t, &NotificationSettingsInput{Channels: []model.NotificationChannel{model.ChannelWebhook}})
+	webhook := f.senders[model.ChannelWebhook]
+	webhook.err = errors.New("connection refused")
+
+	f.notify(t, "recover")
+	webhook.err = nil
+	f.due(t)
+	if err := f.notifications.RetryDue(ctx); err != nil {
+		t.Fatalf("RetryDue: %v", err)
+	}
+
+	delivery := f.deliveries(t)[0]
+	if delivery.Status != model.DeliverySent || delivery.Attempts != 2 || delivery.LastError != "" || delivery.DeliveredAt == nil {
+		t.Errorf("delivery = %+v, want sent on the second attempt", delivery)
+	}
+	if len(webhook.sent) != 1 || webhook.sent[0].Key != "recover" {
+		t.Errorf("webhook sent %+v, want the notification once", webhook.sent)
+	}
+
+	// 同一条消息重复投递时不再发送
+	f.notify(t, "recover")
+	if len(webhook.sent) != 1 {
+		t.Errorf("duplicate notification sent %d times", len(webhook.sent))
+	}
+}
+
+func TestNotificationFallback(t *testing.T) {
+	notConfigured := fmt.Errorf("%w: 测试", ErrChannelNotConfigured)
+	transient := errors.New("temporary failure")
+
+	tests := []struct {
+		name      string
+		channels  []model.NotificationChannel
+		fallbacks []model.NotificationChannel
+		errs      map[model.NotificationChannel]error
+		// want 按创建顺序排列的送达记录，只比较渠道、是否备用和状态
+		want []model.NotificationDelivery
+	}{
+		{
+			name:      "渠道成功时不使用备用渠道",
+			channels:  []model.NotificationChannel{model.ChannelWebSocket},
+			fallbacks: []model.NotificationChannel{model.ChannelEmail},
+			want: []model.NotificationDelivery{
+				{Channel: model.ChannelWebSocket, Status: model.DeliverySent},
+			},
+		},
+		{
+			name:      "渠道失败后按顺序使用备用渠道",
+			channels:  []model.NotificationChannel{model.ChannelWebSocket},
+			fallbacks: []model.NotificationChannel{model.ChannelEmail, model.ChannelWebhook},
+			errs:      map[model.NotificationChannel]error{model.ChannelWebSocket: notConfigured, model.ChannelEmail: notConfigured},
+			want: []model.NotificationDelivery{
+				{Channel: model.ChannelWebSocket, Status: model.DeliveryFailed},
+				{Channel: model.ChannelEmail, Fallback: true, Status: model.DeliveryFailed},
+				{Channel: model.ChannelWebhook, Fallback: true, Status: model.DeliverySent},
+			},
+		},
+		{
+			name:      "备用渠道成功后不再使用后面的备用渠道",
+			channels:  []model.NotificationChannel{model.ChannelWebSocket},
+			fallbacks: []model.NotificationChannel{model.ChannelWebhook, model.ChannelEmail},
+			errs:      map[model.NotificationChannel]error{model.ChannelWebSocket: notConfigured},
+			want: []model.NotificationDelivery{
+				{Channel: model.ChannelWebSocket, Status: model.DeliveryFailed},
+				{Channel: model.ChannelWebhook, Fallback: true, Status: model.DeliverySent},
+			},
+		},
+		{
+			name:      "还有渠道在重试时不使用备用渠道",
+			channels:  []model.NotificationChannel{model.ChannelWebSocket, model.ChannelEmail},
+			fallbacks: []model.NotificationChannel{model.ChannelWebhook},
+			errs:      map[model.NotificationChannel]error{model.ChannelWebSocket: notConfigured, model.ChannelEmail: transient},
+			want: []model.NotificationDelivery{
+				{Channel: model.ChannelWebSocket, Status: model.DeliveryFailed},
+				{Channel: model.ChannelEmail, Status: model.DeliveryPending},
+			},
+		},
+		{
+			name:      "所有渠道都失败",
+			channels:  []model.NotificationChannel{model.ChannelWebSocket},
+			fallbacks: []model.NotificationChannel{model.ChannelEmail},
+			errs:      map[model.NotificationChannel]error{model.ChannelWebSocket: notConfigured, model.ChannelEmail: notConfigured},
+			want: []model.NotificationDelivery{
+				{Channel: model.ChannelWebSocket, Status: model.DeliveryFailed},
+				{Channel: model.ChannelEmail, Fallback: true, Status: model.DeliveryFailed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newNotificationFixture(t, &NotificationSettingsInput{Channels: tt.channels, Fallbacks: tt.fallbacks})
+			for channel, err := range tt.errs {
+				f.senders[channel].err = err
+			}
+			f.notify(t, "fallback")
+
+			got := f.deliveries(t)
+			if len(got) != len(tt.want) {
+				t.Fatalf("deliveries = %+v, want %d", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].Channel != want.Channel || got[i].Fallback != want.Fallback || got[i].Status != want.Status {
+					t.Errorf("delivery %d = %s fallback %v %s, want %s fallback %v %s",
+						i, got[i].Channel, got[i].Fallback, got[i].Status, want.Channel, want.Fallback, want.Status)
+				}
+				if want.Status == model.DeliveryFailed && got[i].LastError != tt.errs[want.Channel].Error() {
+					t.Errorf("delivery %d last error = %q, want %q", i, got[i].LastError, tt.errs[want.Channel])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/mq"
+)
+
+const (
+	// outboxBatchSize 每次查询的消息数
+	outboxBatchSize = 100
+	// outboxMaxAttempts 消息最多尝试发送的次数，超过后标记为发送失败
+	outboxMaxAttempts = 8
+	// outboxBaseBackoff 第一次发送失败后的重试间隔，之后每次失败翻倍
+	outboxBaseBackoff = 30 * time.Second
+	// outboxMaxBackoff 重试间隔的上限
+	outboxMaxBackoff = time.Hour
+)
+
+// errOutboxWaiting 消息还没到重试时间
+var errOutboxWaiting = errors.New("等待重试")
+
+// enqueueOutbox 在tx所在的事务中写入待发送的消息，事务提交后由OutboxRelay发送；
+// key非空时同一key的消息按写入顺序发送
+func enqueueOutbox(tx *gorm.DB, topic, key string, body []byte) error {
+	return tx.Create(&model.OutboxMessage{
+		Topic:  topic,
+		Key:    key,
+		Body:   body,
+		Status: model.OutboxPending,
+	}).Error
+}
+
+// OutboxRelay 将待发送的消息发送到消息队列，消息至少发送一次；发送失败的消息按退避间隔重试，
+// 多次失败后标记为发送失败，不再阻塞同一key后面的消息
+type OutboxRelay struct {
+	db  *gorm.DB
+	bus mq.Bus
+}
+
+// NewOutboxRelay 创建消息发送器
+func NewOutboxRelay(db *gorm.DB, bus mq.Bus) *OutboxRelay {
+	return &OutboxRelay{
+		db:  db,
+		bus: bus,
+	}
+}
+
+// Relay 发送到了发送时间的消息，返回成功发送的条数；一条消息发送失败或在等待重试时，
+// 同一key后面的消息留到它发送成功或被放弃之后再发送，其他消息照常发送。
+// 每批消息发送前和每条消息的状态更新都校验领导者的防护令牌，失去领导权时停止发送
+func (r *OutboxRelay) Relay(ctx context.Context, fence Fence) (int, error) {
+	sent, failed := 0, 0
+	var lastErr error
+	blocked := make(map[string]bool)
+	var lastID uint64
+	for {
+		if err := fence.check(r.db.WithContext(ctx)); err != nil {
+			return sent, err
+		}
+
+		var messages []model.OutboxMessage
+		err := r.db.WithContext(ctx).
+			Where("status = ? AND id > ?", model.OutboxPending, lastID).
+			Order("id").Limit(outboxBatchSize).Find(&messages).Error
+		if err != nil {
+			return sent, fmt.Errorf("查询待发送消息失败: %v", err)
+		}
+
+		now := time.Now()
+		for i := range messages {
+			message := &messages[i]
+			lastID = message.ID
+			if message.Key != "" && blocked[message.Key] {
+				continue
+			}
+
+			err := errOutboxWaiting
+			if message.RetryAt == nil || !message.RetryAt.After(now) {
+				err = r.send(ctx, fence, message)
+			}
+			if err == nil {
+				sent++
+				continue
+			}
+			if errors.Is(err, errLeaseLost) {
+				return sent, err
+			}
+			if err != errOutboxWaiting {
+				failed++
+				lastErr = err
+			}
+			if message.Key != "" {
+				blocked[message.Key] = true
+			}
+		}
+		if len(messages) < outboxBatchSize {
+			break
+		}
+	}
+
+	if failed > 0 {
+		return sent, fmt.Errorf("%d 条消息发送失败，最近一次: %v", failed, lastErr)
+	}
+	return sent, nil
+}
+
+// send 发送一条消息并标记为已发送，发送失败时记录下次重试的时间
+func (r *OutboxRelay) send(ctx context.Context, fence Fence, message *model.OutboxMessage) error {
+	// 消息内容已经是JSON，原样发送
+	if err := r.bus.SendMessage(ctx, message.Topic, json.RawMessage(message.Body)); err != nil {
+		attempts := message.Attempts + 1
+		updates := map[string]interface{}{
+			"attempts":   attempts,
+			"last_error": err.Error(),
+		}
+		if attempts >= outboxMaxAttempts {
+			updates["status"] = model.OutboxFailed
+			logs.Error("outbox message %d failed after %d attempts: %v", message.ID, attempts, err)
+		} else {
+			updates["retry_at"] = time.Now().Add(outboxBackoff(attempts))
+		}
+		if updateErr := r.db.WithContext(ctx).Model(message).Scopes(fence.scope).Updates(updates).Error; updateErr != nil {
+			logs.Error("update outbox message %d error: %v", message.ID, updateErr)
+		}
+		return fmt.Errorf("发送消息 %d 失败: %v", message.ID, err)
+	}
+
+	// 消息已经发出，状态没有更新时下次会重复发送，由消费者按消息内容去重
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(message).Scopes(fence.scope).Updates(map[string]interface{}{
+		"status":   model.OutboxSent,
+		"attempts": gorm.Expr("attempts + 1"),
+		"sent_at":  now,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("更新消息 %d 状态失败: %v", message.ID, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// outboxBackoff 第attempts次发送失败后的重试间隔
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
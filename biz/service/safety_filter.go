@@ -0,0 +1,465 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fatedier/beego/logs"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+// ErrInterventionNotFound 安全干预记录不存在
+var ErrInterventionNotFound = errors.New("干预记录不存在")
+
+// redactedMark 隐去个人信息后的占位文本
+const redactedMark = "[已隐去]"
+
+// SafetyRule 关键词和正则规则，命中任意一条即判定为不安全
+type SafetyRule struct {
+	Category model.SafetyCategory
+	Severity model.AlertSeverity // 需要提醒家长时的严重程度，为空时不提醒
+	Keywords []string
+	Patterns []string
+	// Redact 为true时只隐去命中的片段，内容继续处理，用于个人信息
+	Redact bool
+	// SkipAdvice 为true时，正则命中的片段所在分句里有"不要""别"等劝阻词则不算命中，
+	// 用于安全提醒里同样会说到的危险行为，如"不要碰插座哦"
+	SkipAdvice bool
+}
+
+// adviceWords 劝阻词，分句中出现时说明角色是在提醒孩子不要这样做
+var adviceWords = []string{"不要", "不能", "不可以", "不许", "不准", "别"}
+
+// SafetyConfig 内容安全配置
+type SafetyConfig struct {
+	InputRules    []SafetyRule // 检查孩子说的话
+	OutputRules   []SafetyRule // 检查角色的回复
+	MaxRegenerate int          // 回复不安全时最多重新生成的次数
+	FallbackReply string       // 无法生成安全回复时使用的兜底回复
+	// OutputFailOpen 为true时分类器出错的回复按规则层的结果处理；默认视为不安全，直接使用兜底回复
+	OutputFailOpen bool
+}
+
+// DefaultSafetyConfig 面向3-6岁儿童的默认安全规则
+var DefaultSafetyConfig = SafetyConfig{
+	InputRules: []SafetyRule{
+		{
+			Category: model.SafetySelfHarm,
+			Severity: model.AlertSeverityHigh,
+			Keywords: []string{"不想活", "想死", "自杀", "伤害自己", "割手", "跳楼", "撞墙"},
+		},
+		{
+			Category: model.SafetyDistress,
+			Severity: model.AlertSeverityHigh,
+			Keywords: []string{"救命", "别打我", "打我", "不要碰我", "把我关起来", "不让我吃饭"},
+		},
+		{
+			Category: model.SafetyDistress,
+			Severity: model.AlertSeverityMedium,
+			Keywords: []string{"好害怕", "欺负我", "好疼", "受伤了", "流血了", "没人管我"},
+		},
+		{
+			Category: model.SafetyDistress,
+			Severity: model.AlertSeverityLow,
+			Keywords: []string{"没人陪我", "没有人陪我", "没人跟我玩", "好孤单", "一个人在家"},
+		},
+		{
+			Category: model.SafetyPersonalInfo,
+			Patterns: []string{
+				`1[3-9]\d{9}`,       // 手机号
+				`0\d{2,3}-?\d{7,8}`, // 固定电话
+				`\d{17}[\dXx]`,      // 身份证号
+				`\p{Han}{2,6}(路|街|巷|弄|道)\d+号`,         // 街道门牌
+				`\d+(号楼|栋|幢|单元|室)(\d+(号楼|栋|幢|单元|室))*`, // 楼号房号
+			},
+			Redact: true,
+		},
+	},
+	OutputRules: []SafetyRule{
+		{
+			Category: model.SafetyViolence,
+			Keywords: []string{"杀死", "砍死", "打死", "尸体", "开枪", "炸弹", "鬼怪", "僵尸"},
+		},
+		{
+			Category: model.SafetyViolence,
+			Patterns: []string{
+				`(打|砍|咬|割|捅|刺)(到|得|出|了)?\p{Han}{0,2}流血`,
+				`流血(才|就|真|很)?(好玩|有趣|好看)`,
+			},
+			SkipAdvice: true,
+		},
+		{
+			Category: model.SafetyAdult,
+			Keywords: []string{"色情", "性感", "脱衣服", "上床", "喝酒", "抽烟", "赌博"},
+		},
+		{
+			Category: model.SafetyProfanity,
+			Keywords: []string{"傻逼", "他妈的", "滚开", "去死", "蠢货", "闭嘴"},
+		},
+		{
+			Category: model.SafetyDangerous,
+			Keywords: []string{"玩火", "点火", "爬窗", "跟陌生人走", "保守秘密", "不要告诉爸爸妈妈"},
+		},
+		{
+			Category: model.SafetyDangerous,
+			Patterns: []string{
+				`(摸|碰|玩|抠|戳|舔)(一下|一摸|摸)?(电)?插座`,
+				`(偷偷|自己|随便)(拿|找)?(点|些)?药(来)?吃|(偷偷|自己|随便)吃(点|些)?药`,
+				`把药当(成)?糖`,
+				`(你|自己|偷偷|可以)一个人(出门|出去|跑出去)`,
+			},
+			SkipAdvice: true,
+		},
+		{
+			Category: model.SafetyPersonalInfo,
+			Keywords: []string{"你家住在哪", "你家地址", "电话号码是多少", "哪个幼儿园", "爸爸妈妈叫什么名字"},
+		},
+	},
+	MaxRegenerate: 1,
+	FallbackReply: "这个问题我们去问问爸爸妈妈好不好？我们来聊聊你今天玩了什么吧！",
+}
+
+// SafetyClassifier 内容安全分类器，可接入第三方审核服务或大模型
+type SafetyClassifier interface {
+	Classify(ctx context.Context, stage model.SafetyStage, text string) (*model.SafetyVerdict, error)
+}
+
+// compiledRule 预编译的安全规则
+type compiledRule struct {
+	SafetyRule
+	patterns []*regexp.Regexp
+}
+
+// RuleClassifier 基于关键词和正则的安全分类器
+type RuleClassifier struct {
+	input  []compiledRule
+	output []compiledRule
+}
+
+// NewRuleClassifier 创建规则分类器
+func NewRuleClassifier(inputRules, outputRules []SafetyRule) (*RuleClassifier, error) {
+	input, err := compileRules(inputRules)
+	if err != nil {
+		return nil, err
+	}
+	output, err := compileRules(outputRules)
+	if err != nil {
+		return nil, err
+	}
+	return &RuleClassifier{input: input, output: output}, nil
+}
+
+func compileRules(rules []SafetyRule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c := compiledRule{SafetyRule: rule}
+		for _, pattern := range rule.Patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("compile safety pattern %q error: %v", pattern, err)
+			}
+			c.patterns = append(c.patterns, re)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// Classify 按规则检查内容，返回第一条命中的规则
+func (c *RuleClassifier) Classify(ctx context.Context, stage model.SafetyStage, text string) (*model.SafetyVerdict, error) {
+	for _, rule := range c.rules(stage) {
+		if matched := rule.match(text); len(matched) > 0 {
+			return &model.SafetyVerdict{
+				Safe:     false,
+				Category: rule.Category,
+				Severity: rule.Severity,
+				Reason:   "命中" + string(rule.Category) + "规则",
+				Matched:  matched,
+			}, nil
+		}
+	}
+	return &model.SafetyVerdict{Safe: true}, nil
+}
+
+// Redact 隐去命中Redact规则的片段，返回处理后的文本和命中的片段
+func (c *RuleClassifier) Redact(stage model.SafetyStage, text string) (string, []string) {
+	var matched []string
+	for _, rule := range c.rules(stage) {
+		if !rule.Redact {
+			continue
+		}
+		for _, keyword := range rule.Keywords {
+			if strings.Contains(text, keyword) {
+				matched = append(matched, keyword)
+				text = strings.ReplaceAll(text, keyword, redactedMark)
+			}
+		}
+		for _, re := range rule.patterns {
+			matched = append(matched, re.FindAllString(text, -1)...)
+			text = re.ReplaceAllString(text, redactedMark)
+		}
+	}
+	return text, matched
+}
+
+func (c *RuleClassifier) rules(stage model.SafetyStage) []compiledRule {
+	if stage == model.SafetyStageInput {
+		return c.input
+	}
+	return c.output
+}
+
+// match 返回文本中命中规则的片段
+func (r *compiledRule) match(text string) []string {
+	var matched []string
+	for _, keyword := range r.Keywords {
+		if strings.Contains(text, keyword) {
+			matched = append(matched, keyword)
+		}
+	}
+	for _, re := range r.patterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if r.SkipAdvice && isAdvice(text[:loc[0]]) {
+				continue
+			}
+			matched = append(matched, text[loc[0]:loc[1]])
+		}
+	}
+	return matched
+}
+
+// isAdvice 判断命中片段之前、同一分句里的内容是否带有劝阻词
+func isAdvice(before string) bool {
+	clause := before
+	if i := strings.LastIndexAny(before, "，。！？；,.!?;\n"); i >= 0 {
+		clause = before[i:]
+	}
+	// "别人"里的"别"不是劝阻
+	clause = strings.ReplaceAll(clause, "别人", "")
+	for _, word := range adviceWords {
+		if strings.Contains(clause, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// LLMSafetyClassifier 使用大模型判断内容是否适合3-6岁儿童
+type LLMSafetyClassifier struct {
+	llm ChatModel
+}
+
+// NewLLMSafetyClassifier 创建大模型安全分类器
+func NewLLMSafetyClassifier(llm ChatModel) *LLMSafetyClassifier {
+	return &LLMSafetyClassifier{llm: llm}
+}
+
+// Classify 检查内容，大模型返回无法解析的结果时返回错误，由SafetyFilter按检查环节决定如何处理
+func (c *LLMSafetyClassifier) Classify(ctx context.Context, stage model.SafetyStage, text string) (*model.SafetyVerdict, error) {
+	prompt := "下面是陪伴角色准备对一位3到6岁儿童说的话，判断它是否包含暴力恐怖、成人内容、脏话、诱导危险行为或索要个人信息等不适合儿童的内容。"
+	if stage == model.SafetyStageInput {
+		prompt = "下面是一位3到6岁儿童对陪伴角色说的话，判断孩子是否表达了孤单、害怕、求助、被伤害(distress)或自伤想法(self_harm)，" +
+			"并按需要家长介入的紧急程度给出severity：low(孤单、低落)、medium(害怕、被欺负)、high(受到伤害、自伤)。"
+	}
+	prompt += `只输出JSON：{"safe":true或false,"category":"类别英文名","severity":"严重程度","reason":"简短原因"}。`
+
+	result, err := c.llm.Chat(ctx, []PromptMessage{
+		{Role: PromptRoleSystem, Content: prompt},
+		{Role: PromptRoleUser, Content: text},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("classify content error: %v", err)
+	}
+
+	// 兼容模型在JSON前后附加的说明文字
+	start, end := strings.Index(result, "{"), strings.LastIndex(result, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("classify result %q is not json", result)
+	}
+	var verdict model.SafetyVerdict
+	if err := json.Unmarshal([]byte(result[start:end+1]), &verdict); err != nil {
+		return nil, fmt.Errorf("parse classify result %q error: %v", result, err)
+	}
+	return &verdict, nil
+}
+
+// SafetyFilter 内容安全过滤，检查孩子说的话和角色的回复并记录干预
+type SafetyFilter struct {
+	db          *gorm.DB
+	config      SafetyConfig
+	rules       *RuleClassifier
+	classifiers []SafetyClassifier
+}
+
+// NewSafetyFilter 创建内容安全过滤器，规则层之后依次调用classifiers
+func NewSafetyFilter(db *gorm.DB, config *SafetyConfig, classifiers ...SafetyClassifier) (*SafetyFilter, error) {
+	if config == nil {
+		config = &DefaultSafetyConfig
+	}
+	rules, err := NewRuleClassifier(config.InputRules, config.OutputRules)
+	if err != nil {
+		return nil, err
+	}
+	return &SafetyFilter{
+		db:          db,
+		config:      *config,
+		rules:       rules,
+		classifiers: classifiers,
+	}, nil
+}
+
+// CheckInput 检查孩子说的话，返回隐去个人信息后的文本；发现求助、自伤等情况时返回的检查结果为不安全
+func (f *SafetyFilter) CheckInput(ctx context.Context, msg *model.VoiceMessage, text string) (string, *model.SafetyVerdict) {
+	redacted, matched := f.rules.Redact(model.SafetyStageInput, text)
+	if len(matched) > 0 {
+		f.Record(ctx, msg, model.SafetyStageInput, &model.SafetyVerdict{
+			Category: model.SafetyPersonalInfo,
+			Reason:   "孩子说出了个人信息",
+			Matched:  matched,
+		}, model.SafetyActionRedacted, text, redacted)
+	}
+
+	verdict := f.classify(ctx, model.SafetyStageInput, redacted)
+	if !verdict.Safe {
+		f.Record(ctx, msg, model.SafetyStageInput, verdict, model.SafetyActionFlagged, redacted, "")
+		return redacted, verdict
+	}
+	if len(matched) > 0 {
+		// 个人信息已隐去，仍需提示角色引导孩子保护隐私
+		return redacted, &model.SafetyVerdict{Safe: false, Category: model.SafetyPersonalInfo, Matched: matched}
+	}
+	return redacted, verdict
+}
+
+// CheckOutput 检查角色的回复
+func (f *SafetyFilter) CheckOutput(ctx context.Context, text string) *model.SafetyVerdict {
+	return f.classify(ctx, model.SafetyStageOutput, text)
+}
+
+// classify 依次调用规则层和分类器。检查角色的回复时分类器出错视为不安全，除非配置了OutputFailOpen；
+// 检查孩子说的话时分类器出错则跳过，不能因为分类器故障不回应孩子
+func (f *SafetyFilter) classify(ctx context.Context, stage model.SafetyStage, text string) *model.SafetyVerdict {
+	verdict, _ := f.rules.Classify(ctx, stage, text)
+	if !verdict.Safe {
+		return verdict
+	}
+
+	for _, classifier := range f.classifiers {
+		v, err := classifier.Classify(ctx, stage, text)
+		if err != nil {
+			logs.Error("safety classify error: %v", err)
+			if stage == model.SafetyStageOutput && !f.config.OutputFailOpen {
+				return &model.SafetyVerdict{
+					Safe:     false,
+					Category: model.SafetyUnverified,
+					Reason:   "安全分类器出错: " + err.Error(),
+				}
+			}
+			continue
+		}
+		if !v.Safe {
+			return v
+		}
+	}
+	return verdict
+}
+
+// MaxRegenerate 回复不安全时最多重新生成的次数
+func (f *SafetyFilter) MaxRegenerate() int {
+	return f.config.MaxRegenerate
+}
+
+// FallbackReply 兜底回复
+func (f *SafetyFilter) FallbackReply() string {
+	return f.config.FallbackReply
+}
+
+// Record 记录一次安全干预，记录失败只打印日志
+func (f *SafetyFilter) Record(ctx context.Context, msg *model.VoiceMessage, stage model.SafetyStage, verdict *model.SafetyVerdict, action model.SafetyAction, content, replacement string) {
+	userID, err := strconv.ParseUint(msg.UserID, 10, 64)
+	if err != nil {
+		logs.Error("parse user id %q error: %v", msg.UserID, err)
+		return
+	}
+
+	intervention := &model.SafetyIntervention{
+		UserID:      userID,
+		SessionID:   msg.SessionID,
+		MessageID:   msg.ID,
+		Stage:       stage,
+		Category:    verdict.Category,
+		Action:      action,
+		Content:     content,
+		Replacement: replacement,
+		Reason:      verdict.Reason,
+	}
+	if err := f.db.WithContext(ctx).Create(intervention).Error; err != nil {
+		logs.Error("save safety intervention error: %v", err)
+	}
+}
+
+// ListInterventions 分页获取孩子的安全干预记录，onlyUnreviewed为true时只返回家长未查看的记录
+func (f *SafetyFilter) ListInterventions(ctx context.Context, userID uint64, onlyUnreviewed bool, page, pageSize int) ([]model.SafetyIntervention, int64, error) {
+	query := f.db.WithContext(ctx).Model(&model.SafetyIntervention{}).Where("user_id = ?", userID)
+	if onlyUnreviewed {
+		query = query.Where("reviewed = ?", false)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("统计干预记录失败: %v", err)
+	}
+
+	var interventions []model.SafetyIntervention
+	if err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&interventions).Error; err != nil {
+		return nil, 0, fmt.Errorf("获取干预记录失败: %v", err)
+	}
+	return interventions, total, nil
+}
+
+// MarkReviewed 将干预记录标记为家长已查看
+func (f *SafetyFilter) MarkReviewed(ctx context.Context, userID, id uint64) error {
+	result := f.db.WithContext(ctx).Model(&model.SafetyIntervention{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("reviewed", true)
+	if result.Error != nil {
+		return fmt.Errorf("更新干预记录失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := f.db.WithContext(ctx).Model(&model.SafetyIntervention{}).
+			Where("id = ? AND user_id = ?", id, userID).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("获取干预记录失败: %v", err)
+		}
+		if count == 0 {
+			return ErrInterventionNotFound
+		}
+	}
+	return nil
+}
+
+// safetyGuidance 孩子的话触发安全检查时追加给角色的回复指引
+var safetyGuidance = map[model.SafetyCategory]string{
+	model.SafetyDistress:     "孩子可能遇到了困难或感到害怕。请先用温柔的话安慰孩子，告诉孩子可以马上告诉爸爸妈妈或身边信任的大人，不要追问细节，也不要转到其他话题。",
+	model.SafetySelfHarm:     "孩子说出了伤害自己的想法。请用温柔、平静的话表达你的关心，请孩子现在就去找爸爸妈妈或身边的大人说说，不要讨论具体做法。",
+	model.SafetyPersonalInfo: "孩子刚才说出了住址、电话等个人信息，这些内容已经隐去。请温和地提醒孩子这些信息只告诉爸爸妈妈，不要告诉别人，然后继续刚才的话题。",
+}
+
+// SafetyGuidance 返回检查结果对应的回复指引，没有指引时返回空字符串
+func SafetyGuidance(verdict *model.SafetyVerdict) string {
+	if verdict == nil || verdict.Safe {
+		return ""
+	}
+	return safetyGuidance[verdict.Category]
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestSafetyFilterClassifierError(t *testing.T) {
+	tests := []struct {
+		name         string
+		stage        model.SafetyStage
+		reply        string // 大模型分类器的输出
+		failOpen     bool
+		wantSafe     bool
+		wantCategory model.SafetyCategory
+	}{
+		{
+			name:     "分类器判断为安全",
+			stage:    model.SafetyStageOutput,
+			reply:    `{"safe":true}`,
+			wantSafe: true,
+		},
+		{
+			name:         "分类器判断为不安全",
+			stage:        model.SafetyStageOutput,
+			reply:        `判断结果：{"safe":false,"category":"violence","reason":"暴力内容"}`,
+			wantCategory: model.SafetyViolence,
+		},
+		{
+			name:         "回复的分类结果无法解析时视为不安全",
+			stage:        model.SafetyStageOutput,
+			reply:        "我觉得这句话没问题",
+			wantCategory: model.SafetyUnverified,
+		},
+		{
+			name:     "配置了OutputFailOpen时按规则层的结果处理",
+			stage:    model.SafetyStageOutput,
+			reply:    "我觉得这句话没问题",
+			failOpen: true,
+			wantSafe: true,
+		},
+		{
+			name:     "孩子说的话分类出错时跳过分类器",
+			stage:    model.SafetyStageInput,
+			reply:    `{"safe":`,
+			wantSafe: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultSafetyConfig
+			config.OutputFailOpen = tt.failOpen
+			filter, err := NewSafetyFilter(nil, &config, NewLLMSafetyClassifier(&FakeChatModel{Replies: []string{tt.reply}}))
+			if err != nil {
+				t.Fatalf("NewSafetyFilter: %v", err)
+			}
+
+			verdict := filter.classify(context.Background(), tt.stage, "我们一起去公园玩吧")
+			if verdict.Safe != tt.wantSafe || verdict.Category != tt.wantCategory {
+				t.Errorf("verdict = safe %v category %q, want safe %v category %q", verdict.Safe, verdict.Category, tt.wantSafe, tt.wantCategory)
+			}
+		})
+	}
+}
+
+func TestRuleClassifierOutputAdvice(t *testing.T) {
+	tests := []struct {
+		text         string
+		wantCategory model.SafetyCategory // 为空表示应判定为安全
+	}{
+		{text: "不要碰插座哦，会触电的"},
+		{text: "小手千万别去摸插座"},
+		{text: "生病了要听医生的话吃药"},
+		{text: "药不是糖果，不可以自己吃药哦"},
+		{text: "不能一个人出门哦，要和爸爸妈妈一起"},
+		{text: "摔倒流血了要马上告诉大人"},
+		{text: "不要打到别人流血哦，别人会很疼的"},
+		{text: "我们去摸插座吧", wantCategory: model.SafetyDangerous},
+		{text: "别害怕，你可以偷偷吃药", wantCategory: model.SafetyDangerous},
+		{text: "把药当成糖吃掉吧", wantCategory: model.SafetyDangerous},
+		{text: "帮别人开门以后，你可以一个人出门去玩", wantCategory: model.SafetyDangerous},
+		{text: "咬他一口，咬到流血才好玩", wantCategory: model.SafetyViolence},
+	}
+
+	rules, err := NewRuleClassifier(DefaultSafetyConfig.InputRules, DefaultSafetyConfig.OutputRules)
+	if err != nil {
+		t.Fatalf("NewRuleClassifier: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			verdict, err := rules.Classify(context.Background(), model.SafetyStageOutput, tt.text)
+			if err != nil {
+				t.Fatalf("Classify: %v", err)
+			}
+			if verdict.Safe != (tt.wantCategory == "") || verdict.Category != tt.wantCategory {
+				t.Errorf("verdict = safe %v category %q matched %v, want category %q", verdict.Safe, verdict.Category, verdict.Matched, tt.wantCategory)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// minPasswordLength 密码的最短长度
+	minPasswordLength = 8
+	// maxPasswordLength bcrypt只使用密码的前72字节
+	maxPasswordLength = 72
+	// maxChildNameLength 孩子名字的最大长度
+	maxChildNameLength = 32
+	// maxAvatarLength 头像地址的最大长度
+	maxAvatarLength = 512
+)
+
+var (
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("用户不存在")
+	// ErrUsernameTaken 用户名已被注册
+	ErrUsernameTaken = errors.New("用户名已被使用")
+	// ErrInvalidCredentials 用户名或密码错误
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	// ErrInvalidPassword 密码不符合要求
+	ErrInvalidPassword = errors.New("密码无效")
+	// ErrNotParent 只有家长可以管理孩子
+	ErrNotParent = errors.New("只有家长可以管理孩子")
+	// ErrChildNotFound 孩子不存在或不属于该家长
+	ErrChildNotFound = errors.New("孩子不存在")
+	// ErrInvalidChildProfile 孩子档案无效
+	ErrInvalidChildProfile = errors.New("孩子档案无效")
+)
+
+// ChildInput 家长提交的孩子档案
+type ChildInput struct {
+	Name        string `json:"name"`         // 名字或昵称
+	Birthday    string `json:"birthday"`     // 生日，YYYY-MM-DD，可以为空
+	Avatar      string `json:"avatar"`       // 头像地址
+	CharacterID uint64 `json:"character_id"` // 偏好的系统角色，0表示使用默认角色
+}
+
+// UserService 用户服务：家长账号和家长创建的孩子账号
+type UserService struct {
+	db       *gorm.DB
+	families *FamilyService
+}
+
+// NewUserService 创建用户服务
+func NewUserService(db *gorm.DB, families *FamilyService) *UserService {
+	return &UserService{
+		db:       db,
+		families: families,
+	}
+}
+
+// Register 注册家长账号，密码以bcrypt哈希保存
+func (s *UserService) Register(ctx context.Context, user *model.User) error {
+	if user.Username == nil || *user.Username == "" {
+		return fmt.Errorf("注册失败: 用户名不能为空")
+	}
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	user.Role = model.UserRoleParent
+	user.ParentID = 0
+
+	var count int64
+	if err := s.db.WithContext(ctx).Unscoped().Model(&model.User{}).
+		Where("username = ?", *user.Username).Count(&count).Error; err != nil {
+		return fmt.Errorf("注册失败: %v", err)
+	}
+	if count > 0 {
+		return ErrUsernameTaken
+	}
+
+	// 并发注册同一用户名时，后提交的一方由唯一索引拦下
+	if err := s.db.WithContext(ctx).Create(user).Error; err != nil {
+		if isDuplicateKey(s.db, err) {
+			return ErrUsernameTaken
+		}
+		return fmt.Errorf("注册失败: %v", err)
+	}
+	return nil
+}
+
+// isDuplicateKey 错误是否由违反唯一索引引起，由数据库驱动识别各自的错误码
+func isDuplicateKey(db *gorm.DB, err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	translator, ok := db.Dialector.(gorm.ErrorTranslator)
+	return ok && errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey)
+}
+
+// Authenticate 校验用户名和密码，孩子账号不能用密码登录
+func (s *UserService) Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+	var user model.User
+	err := s.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	if user.Role == model.UserRoleChild {
+		return nil, ErrInvalidCredentials
+	}
+
+	if isPasswordHash(user.Password) {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return nil, ErrInvalidCredentials
+		}
+		return &user, nil
+	}
+
+	// 早期注册的账号保存的是明文密码，登录成功后改为哈希保存
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("生成密码哈希失败: %v", err)
+	}
+	if err := s.db.WithContext(ctx).Model(&user).Update("password", string(hash)).Error; err != nil {
+		return nil, fmt.Errorf("更新密码失败: %v", err)
+	}
+	return &user, nil
+}
+
+// ChangePassword 修改密码，并作废用户所有的登录会话
+func (s *UserService) ChangePassword(ctx context.Context, id uint64, oldPassword, newPassword string) error {
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if user.Username == nil {
+		return ErrInvalidCredentials
+	}
+	if _, err := s.Authenticate(ctx, *user.Username, oldPassword); err != nil {
+		return err
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", hash).Error; err != nil {
+			return err
+		}
+		return revokeSessions(tx, user.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("修改密码失败: %v", err)
+	}
+	return nil
+}
+
+// GetUser 获取用户，孩子账号同时返回档案
+func (s *UserService) GetUser(ctx context.Context, id uint64) (*model.User, error) {
+	var user model.User
+	err := s.db.WithContext(ctx).Preload("Profile").First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询用户失败: %v", err)
+	}
+	return &user, nil
+}
+
+// UpdateUser 更新用户的邮箱
+func (s *UserService) UpdateUser(ctx context.Context, id uint64, email string) (*model.User, error) {
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.WithContext(ctx).Model(user).Update("email", email).Error; err != nil {
+		return nil, fmt.Errorf("更新用户失败: %v", err)
+	}
+	return user, nil
+}
+
+// CreateChild 家长创建孩子账号和档案，孩子加入家长所在的家庭，家长还没有家庭时为其创建一个；
+// 家长在家庭中只能查看报告时不能创建孩子
+func (s *UserService) CreateChild(ctx context.Context, parentID uint64, input *ChildInput) (*model.User, error) {
+	parent, err := s.GetUser(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.Role != model.UserRoleParent {
+		return nil, ErrNotParent
+	}
+
+	profile, err := s.childProfile(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	child := &model.User{
+		Role:     model.UserRoleChild,
+		ParentID: parentID,
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(child).Error; err != nil {
+			return err
+		}
+		profile.UserID = child.ID
+		if err := tx.Create(profile).Error; err != nil {
+			return err
+		}
+
+		families := s.families.WithTx(tx)
+		family, err := families.ManagedFamilyByUser(ctx, parentID)
+		if err != nil {
+			return err
+		}
+		return families.AddMember(ctx, family.ID, child.ID, model.FamilyRoleChild)
+	})
+	if errors.Is(err, ErrReadOnlyGuardian) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建孩子失败: %v", err)
+	}
+
+	child.Profile = profile
+	return child, nil
+}
+
+// ListChildren 获取家长监护的孩子，包括其他家长创建、邀请家长一起监护的孩子
+func (s *UserService) ListChildren(ctx context.Context, parentID uint64) ([]model.User, error) {
+	var children []model.User
+	err := s.db.WithContext(ctx).Preload("Profile").
+		Where("id IN (?) AND role = ?", guardedChildren(s.db, parentID, false), model.UserRoleChild).
+		Order("id").Find(&children).Error
+	if err != nil {
+		return nil, fmt.Errorf("查询孩子列表失败: %v", err)
+	}
+	return children, nil
+}
+
+// GetChild 获取家长监护的孩子
+func (s *UserService) GetChild(ctx context.Context, parentID, childID uint64) (*model.User, error) {
+	return s.guardedChild(ctx, parentID, childID, false)
+}
+
+// ManagedChild 获取家长有完全管理权限的孩子，只能查看报告的监护人返回ErrReadOnlyGuardian
+func (s *UserService) ManagedChild(ctx context.Context, parentID, childID uint64) (*model.User, error) {
+	child, err := s.guardedChild(ctx, parentID, childID, true)
+	if errors.Is(err, ErrChildNotFound) {
+		if _, err := s.GetChild(ctx, parentID, childID); err == nil {
+			return nil, ErrReadOnlyGuardian
+		}
+	}
+	return child, err
+}
+
+// guardedChild 查询家长监护的孩子，manage为true时要求有完全管理权限
+func (s *UserService) guardedChild(ctx context.Context, parentID, childID uint64, manage bool) (*model.User, error) {
+	var child model.User
+	err := s.db.WithContext(ctx).Preload("Profile").
+		Where("id = ? AND role = ?", childID, model.UserRoleChild).
+		Where("id IN (?)", guardedChildren(s.db, parentID, manage)).
+		First(&child).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChildNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询孩子失败: %v", err)
+	}
+	return &child, nil
+}
+
+// UpdateChild 更新孩子档案
+func (s *UserService) UpdateChild(ctx context.Context, parentID, childID uint64, input *ChildInput) (*model.User, error) {
+	child, err := s.ManagedChild(ctx, parentID, childID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := s.childProfile(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.UserID = child.ID
+	if err := s.db.WithContext(ctx).Save(profile).Error; err != nil {
+		return nil, fmt.Errorf("更新孩子档案失败: %v", err)
+	}
+	child.Profile = profile
+	return child, nil
+}
+
+// DeleteChild 删除孩子账号、移出家庭并作废孩子的登录会话，孩子的聊天和情绪记录保留，不再生成新的报告
+func (s *UserService) DeleteChild(ctx context.Context, parentID, childID uint64) error {
+	child, err := s.ManagedChild(ctx, parentID, childID)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(child).Error; err != nil {
+			return err
+		}
+		if err := revokeSessions(tx, child.ID); err != nil {
+			return err
+		}
+		return s.families.WithTx(tx).RemoveMember(ctx, child.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("删除孩子失败: %v", err)
+	}
+	return nil
+}
+
+// childProfile 校验家长提交的孩子档案
+func (s *UserService) childProfile(ctx context.Context, input *ChildInput) (*model.ChildProfile, error) {
+	profile := &model.ChildProfile{
+		Name:        strings.TrimSpace(input.Name),
+		Avatar:      strings.TrimSpace(input.Avatar),
+		CharacterID: input.CharacterID,
+	}
+	if profile.Name == "" {
+		return nil, fmt.Errorf("%w: 名字不能为空", ErrInvalidChildProfile)
+	}
+	if utf8.RuneCountInString(profile.Name) > maxChildNameLength {
+		return nil, fmt.Errorf("%w: 名字不能超过%d个字", ErrInvalidChildProfile, maxChildNameLength)
+	}
+	if len(profile.Avatar) > maxAvatarLength {
+		return nil, fmt.Errorf("%w: 头像地址太长", ErrInvalidChildProfile)
+	}
+
+	if input.Birthday != "" {
+		birthday, err := time.Parse("2006-01-02", input.Birthday)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 生日%q格式无效，应为YYYY-MM-DD", ErrInvalidChildProfile, input.Birthday)
+		}
+		if birthday.After(time.Now()) {
+			return nil, fmt.Errorf("%w: 生日不能晚于今天", ErrInvalidChildProfile)
+		}
+		profile.Birthday = &birthday
+	}
+
+	if profile.CharacterID != 0 {
+		var count int64
+		if err := s.db.WithContext(ctx).Model(&model.Character{}).Where("id = ?", profile.CharacterID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("查询角色失败: %v", err)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidChildProfile, ErrCharacterNotFound)
+		}
+	}
+	return profile, nil
+}
+
+// hashPassword 校验密码长度并生成bcrypt哈希
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("%w: 密码至少需要%d位", ErrInvalidPassword, minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return "", fmt.Errorf("%w: 密码不能超过%d个字节", ErrInvalidPassword, maxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("生成密码哈希失败: %v", err)
+	}
+	return string(hash), nil
+}
+
+// isPasswordHash 保存的密码是否为bcrypt哈希
+func isPasswordHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestRegisterUsernameTaken(t *testing.T) {
+	tests := []struct {
+		name string
+		race bool // 另一个请求在查重之后、创建之前注册了同一用户名
+	}{
+		{name: "用户名已存在"},
+		{name: "并发注册同一用户名", race: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			users := NewUserService(db, NewFamilyService(db))
+			username := func() *string { name := "parent"; return &name }
+
+			if tt.race {
+				raced := false
+				err := db.Callback().Create().Before("gorm:begin_transaction").Register("test:race", func(tx *gorm.DB) {
+					if raced {
+						return
+					}
+					raced = true
+					if err := users.Register(ctx, &model.User{Username: username(), Password: "secret123"}); err != nil {
+						t.Errorf("concurrent Register: %v", err)
+					}
+				})
+				if err != nil {
+					t.Fatalf("register callback: %v", err)
+				}
+			} else if err := users.Register(ctx, &model.User{Username: username(), Password: "secret123"}); err != nil {
+				t.Fatalf("first Register: %v", err)
+			}
+
+			err := users.Register(ctx, &model.User{Username: username(), Password: "secret456"})
+			if !errors.Is(err, ErrUsernameTaken) {
+				t.Errorf("Register = %v, want ErrUsernameTaken", err)
+			}
+		})
+	}
+}
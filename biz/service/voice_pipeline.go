@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"sync"
+
+	"github.com/sweekar/pkg/websocket"
 )
 
 // VoicePipelineService 语音处理流水线服务
@@ -17,9 +19,9 @@ type VoicePipelineService struct {
 }
 
 // NewVoicePipelineService 创建新的语音处理流水线服务
-func NewVoicePipelineService(config *VoiceProcessorConfig) (*VoicePipelineService, error) {
+func NewVoicePipelineService(config *VoiceProcessorConfig, characters *CharacterService, conversations *ConversationStore, safety *SafetyFilter, alerts *AlertService, emotions *EmotionProcessor, wsPool *websocket.Pool) (*VoicePipelineService, error) {
 	// 创建语音处理器
-	processor, err := NewVoiceProcessor(config)
+	processor, err := NewVoiceProcessor(config, characters, conversations, safety, alerts, emotions, wsPool)
 	if err != nil {
 		return nil, fmt.Errorf("create voice processor error: %v", err)
 	}
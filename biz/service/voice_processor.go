@@ -3,97 +3,160 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fatedier/beego/logs"
-	"github.com/sashabaranov/go-openai"
 
 	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/audio"
 	"github.com/sweekar/pkg/mq"
+	"github.com/sweekar/pkg/websocket"
 )
 
+// errSentenceBlocked 回复中出现不安全的句子，中止本次生成
+var errSentenceBlocked = errors.New("sentence blocked by safety filter")
+
+// VoiceProcessorConfig 语音处理器配置
+type VoiceProcessorConfig struct {
+	// 消息队列配置，MQDriver为memory时使用进程内消息总线
+	MQDriver      string
+	MQNameServers []string
+	MQGroupID     string
+	MQMaxRetries  int
+
+	// VAD配置
+	VADProvider string
+	VADConfig   *model.VADConfig
+	VADWorkers  int
+	VADTopic    string
+
+	// ASR配置
+	ASRProvider string
+	ASRConfig   *funasr.Config
+	ASRWorkers  int
+	ASRTopic    string
+
+	// LLM配置
+	LLMProvider string
+	LLMAPIKey   string
+	LLMModel    string
+	LLMWorkers  int
+	LLMTopic    string
+
+	// TTS配置
+	TTSProvider string
+	TTSConfig   *tts.Config
+	TTSWorkers  int
+	TTSTopic    string
+
+	// 家长提醒配置
+	AlertWorkers int
+	AlertTopic   string
+
+	// 情绪分析配置
+	EmotionWorkers int
+	EmotionTopic   string
+}
+
 // VoiceProcessor 语音处理器
 type VoiceProcessor struct {
-	mqClient *mq.RocketMQClient
+	mqClient mq.Bus
 
 	// VAD配置
-	vadModel   *whisper.Context
-	vadConfig  *model.VADConfig
+	vad        VADEngine
 	vadWorkers int
 	vadTopic   string
 
 	// ASR配置
-	asrClient  *funasr.Client
+	asr        Recognizer
 	asrWorkers int
 	asrTopic   string
 
 	// LLM配置
-	llmClient  *openai.Client
-	llmWorkers int
-	llmTopic   string
+	characters    *CharacterService
+	conversations *ConversationStore
+	safety        *SafetyFilter
+	llm           ChatModel
+	llmWorkers    int
+	llmTopic      string
 
 	// TTS配置
-	ttsClient  *tts.Client
+	tts        Synthesizer
 	ttsWorkers int
 	ttsTopic   string
 
+	// 家长提醒配置
+	alerts       *AlertService
+	alertWorkers int
+	alertTopic   string
+
+	// 情绪分析配置
+	emotions       *EmotionProcessor
+	emotionWorkers int
+	emotionTopic   string
+
 	// WebSocket配置
-	wsPool *websocket.Pool
+	wsPool    *websocket.Pool
+	sequencer *responseSequencer
+	replies   *replyJournal
+}
+
+// NewVoiceProcessor 创建语音处理器，各阶段的实现由配置选择
+func NewVoiceProcessor(config *VoiceProcessorConfig, characters *CharacterService, conversations *ConversationStore, safety *SafetyFilter, alerts *AlertService, emotions *EmotionProcessor, wsPool *websocket.Pool) (*VoiceProcessor, error) {
+	providers, err := NewVoiceProviders(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewVoiceProcessorWithProviders(config, providers, characters, conversations, safety, alerts, emotions, wsPool)
 }
 
-// NewVoiceProcessor 创建语音处理器
-func NewVoiceProcessor(config *VoiceProcessorConfig) (*VoiceProcessor, error) {
-	// 初始化RocketMQ客户端
-	mqClient := mq.NewRocketMQClient(&mq.RocketMQConfig{
+// NewVoiceProcessorWithProviders 使用指定的各阶段实现创建语音处理器
+func NewVoiceProcessorWithProviders(config *VoiceProcessorConfig, providers *VoiceProviders, characters *CharacterService, conversations *ConversationStore, safety *SafetyFilter, alerts *AlertService, emotions *EmotionProcessor, wsPool *websocket.Pool) (*VoiceProcessor, error) {
+	// 初始化消息总线
+	mqClient, err := mq.NewBus(config.MQDriver, &mq.RocketMQConfig{
 		NameServers: config.MQNameServers,
 		GroupID:     config.MQGroupID,
 		MaxRetries:  config.MQMaxRetries,
 	})
-
-	// 初始化VAD模型
-	vadModel, err := whisper.New(config.VADModelPath)
-	if err != nil {
-		return nil, fmt.Errorf("init vad model error: %v", err)
-	}
-
-	// 初始化ASR客户端
-	asrClient, err := funasr.NewClient(config.ASRConfig)
-	if err != nil {
-		return nil, fmt.Errorf("init asr client error: %v", err)
-	}
-
-	// 初始化LLM客户端
-	llmClient := openai.NewClient(config.LLMAPIKey)
-
-	// 初始化TTS客户端
-	ttsClient, err := tts.NewClient(config.TTSConfig)
 	if err != nil {
-		return nil, fmt.Errorf("init tts client error: %v", err)
+		return nil, err
 	}
 
 	return &VoiceProcessor{
-		mqClient:   mqClient,
-		vadModel:   vadModel,
-		vadConfig:  config.VADConfig,
-		vadWorkers: config.VADWorkers,
-		vadTopic:   config.VADTopic,
-		asrClient:  asrClient,
-		asrWorkers: config.ASRWorkers,
-		asrTopic:   config.ASRTopic,
-		llmClient:  llmClient,
-		llmWorkers: config.LLMWorkers,
-		llmTopic:   config.LLMTopic,
-		ttsClient:  ttsClient,
-		ttsWorkers: config.TTSWorkers,
-		ttsTopic:   config.TTSTopic,
-		wsPool:     websocket.NewPool(),
+		mqClient:       mqClient,
+		vad:            providers.VAD,
+		vadWorkers:     config.VADWorkers,
+		vadTopic:       config.VADTopic,
+		asr:            providers.ASR,
+		asrWorkers:     config.ASRWorkers,
+		asrTopic:       config.ASRTopic,
+		characters:     characters,
+		conversations:  conversations,
+		safety:         safety,
+		llm:            providers.LLM,
+		llmWorkers:     config.LLMWorkers,
+		llmTopic:       config.LLMTopic,
+		tts:            providers.TTS,
+		ttsWorkers:     config.TTSWorkers,
+		ttsTopic:       config.TTSTopic,
+		alerts:         alerts,
+		alertWorkers:   config.AlertWorkers,
+		alertTopic:     config.AlertTopic,
+		emotions:       emotions,
+		emotionWorkers: config.EmotionWorkers,
+		emotionTopic:   config.EmotionTopic,
+		wsPool:         wsPool,
+		sequencer:      newResponseSequencer(),
+		replies:        newReplyJournal(),
 	}, nil
 }
 
 // Start 启动语音处理器
 func (p *VoiceProcessor) Start(ctx context.Context) error {
-	// 启动RocketMQ客户端
+	// 启动消息总线
 	if err := p.mqClient.Start(ctx); err != nil {
 		return err
 	}
@@ -118,6 +181,16 @@ func (p *VoiceProcessor) Start(ctx context.Context) error {
 		return err
 	}
 
+	// 启动家长提醒消费者
+	if err := p.startAlertConsumer(ctx); err != nil {
+		return err
+	}
+
+	// 启动情绪分析消费者
+	if err := p.startEmotionConsumer(ctx); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -140,7 +213,7 @@ func (p *VoiceProcessor) startVADConsumer(ctx context.Context) error {
 		}
 
 		// 执行VAD处理
-		result := p.processVAD(&msg)
+		result := p.processVAD(ctx, &msg)
 		if result.IsSpeech {
 			// 发送到ASR队列
 			return p.mqClient.SendMessage(ctx, p.asrTopic, result)
@@ -158,7 +231,15 @@ func (p *VoiceProcessor) startASRConsumer(ctx context.Context) error {
 		}
 
 		// 执行ASR处理
-		result := p.processASR(&vadResult)
+		result := p.processASR(ctx, &vadResult)
+
+		// 识别结果同时发送到情绪分析队列，情绪分析不影响对话
+		if result.Text != "" {
+			if err := p.mqClient.SendMessage(ctx, p.emotionTopic, result); err != nil {
+				logs.Error("send emotion analysis error: %v", err)
+			}
+		}
+
 		// 发送到LLM队列
 		return p.mqClient.SendMessage(ctx, p.llmTopic, result)
 	})
@@ -172,10 +253,20 @@ func (p *VoiceProcessor) startLLMConsumer(ctx context.Context) error {
 			return err
 		}
 
-		// 执行LLM处理
-		result := p.processLLM(&asrResult)
-		// 发送到TTS队列
-		return p.mqClient.SendMessage(ctx, p.ttsTopic, result)
+		// 重复投递的消息已经完整回复过，不再重新生成
+		if _, final := p.replies.Sent(asrResult.ID); final {
+			return nil
+		}
+
+		// 执行LLM处理，回复按句发送到TTS队列
+		response, err := p.processLLM(ctx, &asrResult)
+		if err != nil {
+			return err
+		}
+
+		// 记录本轮对话，供后续轮次使用
+		p.conversations.AppendTurn(ctx, &asrResult.VoiceMessage, asrResult.Text, response)
+		return nil
 	})
 }
 
@@ -187,29 +278,68 @@ func (p *VoiceProcessor) startTTSConsumer(ctx context.Context) error {
 			return err
 		}
 
-		// 执行TTS处理
-		result := p.processTTS(&llmResult)
-		// TODO: 发送结果到WebSocket
+		// 执行TTS处理，结果在processTTS中推送给客户端
+		p.processTTS(ctx, &llmResult)
 		return nil
 	})
 }
 
-// processVAD 执行VAD处理
-func (p *VoiceProcessor) processVAD(msg *model.VoiceMessage) *model.VADResult {
-	// TODO: 实现VAD处理逻辑
-	return &model.VADResult{
-		VoiceMessage: *msg,
-		IsSpeech:     true,
-		SpeechStart:  0,
-		SpeechEnd:    time.Duration(len(msg.Data)) * time.Millisecond,
-		AudioSegment: msg.Data,
+// startAlertConsumer 启动家长提醒消费者
+func (p *VoiceProcessor) startAlertConsumer(ctx context.Context) error {
+	return p.mqClient.ConsumeMessage(ctx, p.alertTopic, p.alertWorkers, func(ctx context.Context, data []byte) error {
+		var alert model.SafetyAlert
+		if err := json.Unmarshal(data, &alert); err != nil {
+			return err
+		}
+
+		// 去重、保存并推送给家长
+		return p.alerts.HandleAlert(ctx, &alert)
+	})
+}
+
+// startEmotionConsumer 启动情绪分析消费者
+func (p *VoiceProcessor) startEmotionConsumer(ctx context.Context) error {
+	return p.mqClient.ConsumeMessage(ctx, p.emotionTopic, p.emotionWorkers, func(ctx context.Context, data []byte) error {
+		var asrResult model.ASRResult
+		if err := json.Unmarshal(data, &asrResult); err != nil {
+			return err
+		}
+
+		// 分析孩子说话的情绪并保存情绪记录
+		return p.emotions.AnalyzeVoiceEmotion(ctx, &asrResult)
+	})
+}
+
+// processVAD 执行VAD处理，服务端已经切分好的语音段直接作为一句话
+func (p *VoiceProcessor) processVAD(ctx context.Context, msg *model.VoiceMessage) *model.VADResult {
+	if msg.Segmented {
+		var end time.Duration
+		if msg.SampleRate > 0 {
+			end = time.Duration(len(msg.Data)/2) * time.Second / time.Duration(msg.SampleRate)
+		}
+		return &model.VADResult{
+			VoiceMessage: *msg,
+			IsSpeech:     len(msg.Data) > 0,
+			SpeechEnd:    end,
+			AudioSegment: msg.Data,
+		}
 	}
+
+	result, err := p.vad.Detect(ctx, msg)
+	if err != nil {
+		logs.Error("VAD detection error: %v", err)
+		return &model.VADResult{
+			VoiceMessage: *msg,
+			IsSpeech:     false,
+		}
+	}
+	return result
 }
 
 // processASR 执行ASR处理
-func (p *VoiceProcessor) processASR(vadResult *model.VADResult) *model.ASRResult {
-	// 调用FunASR进行语音识别
-	text, err := p.asrClient.Recognize(vadResult.AudioSegment)
+func (p *VoiceProcessor) processASR(ctx context.Context, vadResult *model.VADResult) *model.ASRResult {
+	// 调用语音识别服务
+	text, err := p.asr.Recognize(ctx, vadResult.AudioSegment)
 	if err != nil {
 		logs.Error("ASR recognition error: %v", err)
 		return &model.ASRResult{
@@ -218,76 +348,266 @@ func (p *VoiceProcessor) processASR(vadResult *model.VADResult) *model.ASRResult
 		}
 	}
 
+	// 提取语音段的韵律特征，供情绪分析使用
+	sampleRate := vadResult.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = audio.DefaultVADConfig().SampleRate
+	}
 	return &model.ASRResult{
 		VoiceMessage: vadResult.VoiceMessage,
 		Text:         text,
+		Prosody:      audio.ExtractProsody(vadResult.AudioSegment, sampleRate),
 	}
 }
 
-// processLLM 执行LLM处理
-func (p *VoiceProcessor) processLLM(asrResult *model.ASRResult) *model.LLMResult {
-	// 调用OpenAI API生成响应
-	resp, err := p.llmClient.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: openai.GPT3Dot5Turbo,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: asrResult.Text,
-				},
-			},
-		},
-	)
+// processLLM 执行LLM处理，流式生成回复并逐句发送到TTS队列，返回完整回复；
+// 消息队列重试时跳过已经发送过的句子，从中断的位置继续
+func (p *VoiceProcessor) processLLM(ctx context.Context, asrResult *model.ASRResult) (string, error) {
+	if asrResult.Text == "" {
+		// 没有识别出文字时也发送空的结束标记，客户端据此结束本轮等待
+		return "", p.sendReply(ctx, &model.LLMResult{VoiceMessage: asrResult.VoiceMessage, Final: true})
+	}
 
-	if err != nil {
-		logs.Error("LLM generation error: %v", err)
-		return &model.LLMResult{
+	// 检查孩子说的话，个人信息隐去后再交给大模型和聊天记录
+	text, verdict := p.safety.CheckInput(ctx, &asrResult.VoiceMessage, asrResult.Text)
+	asrResult.Text = text
+
+	// 孩子求助或表达受到伤害时实时提醒家长，不阻塞回复
+	if alert := NewSafetyAlert(&asrResult.VoiceMessage, text, verdict); alert != nil {
+		if err := p.mqClient.SendMessage(ctx, p.alertTopic, alert); err != nil {
+			logs.Error("send safety alert error: %v", err)
+		}
+	}
+
+	messages, voiceID := p.buildPrompt(ctx, asrResult, verdict)
+
+	// 重试时孩子已经听到的句子，新的句子从后面的序号继续
+	sent, _ := p.replies.Sent(asrResult.ID)
+	seq := len(sent)
+	send := func(sentence string, final bool) error {
+		result := &model.LLMResult{
 			VoiceMessage: asrResult.VoiceMessage,
-			Response:     "",
+			Response:     sentence,
+			VoiceID:      voiceID,
+			Seq:          seq,
+			Final:        final,
 		}
+		seq++
+		return p.sendReply(ctx, result)
 	}
 
-	return &model.LLMResult{
-		VoiceMessage: asrResult.VoiceMessage,
-		Response:     resp.Choices[0].Message.Content,
+	var response strings.Builder
+	for attempt := 0; ; attempt++ {
+		sentence, verdict, err := p.streamReply(ctx, messages, sent, &response, send)
+		if err != nil {
+			return "", err
+		}
+		if verdict == nil {
+			return response.String(), nil
+		}
+
+		// 还没有句子发出时丢弃回复重新生成，否则用兜底回复结束本轮；分类器出错时重新生成也无法确认安全，直接用兜底回复
+		if seq == 0 && attempt < p.safety.MaxRegenerate() && verdict.Category != model.SafetyUnverified {
+			p.safety.Record(ctx, &asrResult.VoiceMessage, model.SafetyStageOutput, verdict, model.SafetyActionRegenerated, sentence, "")
+			messages = append(messages, PromptMessage{
+				Role:    PromptRoleSystem,
+				Content: "你上一次的回答不适合3到6岁的孩子，请换一种温和、简单的说法重新回答，不要提到暴力、恐怖、危险行为或个人信息。",
+			})
+			continue
+		}
+
+		fallback := p.safety.FallbackReply()
+		p.safety.Record(ctx, &asrResult.VoiceMessage, model.SafetyStageOutput, verdict, model.SafetyActionReplaced, sentence, fallback)
+		response.WriteString(fallback)
+		if err := send(fallback, true); err != nil {
+			return "", err
+		}
+		return response.String(), nil
 	}
 }
 
-// processTTS 执行TTS处理
-func (p *VoiceProcessor) processTTS(llmResult *model.LLMResult) *model.TTSResult {
-	// 调用TTS服务进行语音合成
-	audio, err := p.ttsClient.Synthesize(llmResult.Response)
+// streamReply 流式生成一次回复，每完成一句检查后立即发送合成，已发送的句子写入response；
+// 前len(sent)句在之前的处理中已经发送，跳过合成并以已发送的文本写入response；
+// 遇到不安全的句子时中止生成，返回该句和检查结果，此时尚未发送结束标记
+func (p *VoiceProcessor) streamReply(ctx context.Context, messages []PromptMessage, sent []string, response *strings.Builder, send func(sentence string, final bool) error) (string, *model.SafetyVerdict, error) {
+	var blocked string
+	var blockedVerdict *model.SafetyVerdict
+	var sendErr error
+
+	// skip 跳过已经发送过的句子
+	produced := 0
+	skip := func() bool {
+		if produced >= len(sent) {
+			return false
+		}
+		response.WriteString(sent[produced])
+		produced++
+		return true
+	}
+
+	check := func(sentence string) bool {
+		if verdict := p.safety.CheckOutput(ctx, sentence); !verdict.Safe {
+			blocked, blockedVerdict = sentence, verdict
+			return false
+		}
+		return true
+	}
+
+	splitter := &sentenceSplitter{}
+	err := p.llm.ChatStream(ctx, messages, func(delta string) error {
+		for _, sentence := range splitter.Push(delta) {
+			if skip() {
+				continue
+			}
+			if !check(sentence) {
+				return errSentenceBlocked
+			}
+			if sendErr = send(sentence, false); sendErr != nil {
+				return sendErr
+			}
+			response.WriteString(sentence)
+		}
+		return nil
+	})
+	if sendErr != nil {
+		return "", nil, sendErr
+	}
+	if blockedVerdict != nil {
+		return blocked, blockedVerdict, nil
+	}
 	if err != nil {
-		logs.Error("TTS synthesis error: %v", err)
-		return &model.TTSResult{
-			VoiceMessage: llmResult.VoiceMessage,
-			Audio:        nil,
+		// 已生成的部分照常播放
+		logs.Error("LLM generation error: %v", err)
+	}
+
+	// 剩余文本作为最后一句，没有剩余文本或剩余文本已经发送过时发送空的结束标记
+	rest := splitter.Flush()
+	if rest != "" && skip() {
+		rest = ""
+	}
+	// 重新生成的回复比之前短时，之前发送的句子仍计入回复
+	for produced < len(sent) {
+		skip()
+	}
+	if rest != "" && !check(rest) {
+		return blocked, blockedVerdict, nil
+	}
+	if err := send(rest, true); err != nil {
+		return "", nil, err
+	}
+	response.WriteString(rest)
+	return "", nil, nil
+}
+
+// sendReply 将一句回复发送到TTS队列，并记录为已发送
+func (p *VoiceProcessor) sendReply(ctx context.Context, result *model.LLMResult) error {
+	if err := p.mqClient.SendMessage(ctx, p.ttsTopic, result); err != nil {
+		return err
+	}
+	p.replies.Record(result.ID, result.Response, result.Final)
+	return nil
+}
+
+// buildPrompt 构造对话请求：角色设定、会话历史、安全指引和孩子刚说的话，同时返回角色音色
+func (p *VoiceProcessor) buildPrompt(ctx context.Context, asrResult *model.ASRResult, verdict *model.SafetyVerdict) ([]PromptMessage, string) {
+	// 获取会话选择的角色，角色设定作为系统提示词
+	var messages []PromptMessage
+	voiceID := ""
+	character, err := p.characters.ResolveCharacter(ctx, asrResult.CharacterID)
+	if err != nil {
+		logs.Error("resolve character %d error: %v", asrResult.CharacterID, err)
+	} else {
+		voiceID = character.VoiceID
+		messages = append(messages, PromptMessage{
+			Role:    PromptRoleSystem,
+			Content: BuildSystemPrompt(character),
+		})
+	}
+
+	// 加入会话的历史摘要和最近几轮对话
+	summary, history := p.conversations.History(ctx, asrResult.UserID, asrResult.SessionID)
+	if summary != "" {
+		messages = append(messages, PromptMessage{
+			Role:    PromptRoleSystem,
+			Content: "之前聊天的摘要：" + summary,
+		})
+	}
+	for _, turn := range history {
+		role := PromptRoleAssistant
+		if turn.Role == model.RoleChild {
+			role = PromptRoleUser
 		}
+		messages = append(messages, PromptMessage{
+			Role:    role,
+			Content: turn.Content,
+		})
+	}
+	// 孩子的话触发安全检查时，提示角色如何回应
+	if guidance := SafetyGuidance(verdict); guidance != "" {
+		messages = append(messages, PromptMessage{
+			Role:    PromptRoleSystem,
+			Content: guidance,
+		})
 	}
+	messages = append(messages, PromptMessage{
+		Role:    PromptRoleUser,
+		Content: asrResult.Text,
+	})
+
+	return messages, voiceID
+}
 
+// processTTS 执行TTS处理
+func (p *VoiceProcessor) processTTS(ctx context.Context, llmResult *model.LLMResult) *model.TTSResult {
 	result := &model.TTSResult{
 		VoiceMessage: llmResult.VoiceMessage,
-		Audio:        audio,
+		Text:         llmResult.Response,
+		Seq:          llmResult.Seq,
+		Final:        llmResult.Final,
 	}
 
-	// 通过WebSocket推送TTS结果给客户端
-	if client := p.wsPool.GetClient(llmResult.UserID); client != nil {
-		msg := websocket.Message{
-			Type:    "voice_response",
-			Payload: result,
-		}
-		msgData, err := json.Marshal(msg)
+	// 调用TTS服务进行语音合成，使用角色对应的音色；合成失败时仍推送文本，避免客户端等待后续片段
+	if llmResult.Response != "" {
+		audio, err := p.tts.Synthesize(ctx, llmResult.Response, llmResult.VoiceID)
 		if err != nil {
-			logs.Error("序列化TTS结果失败: %v", err)
+			logs.Error("TTS synthesis error: %v", err)
 		} else {
-			client.Mu.Lock()
-			if err := client.Conn.WriteMessage(websocket.BinaryMessage, msgData); err != nil {
-				logs.Error("推送TTS结果失败: %v", err)
-			}
-			client.Mu.Unlock()
+			result.Audio = audio
 		}
 	}
 
+	// 通过WebSocket按顺序推送TTS结果给客户端
+	p.sequencer.Push(&model.VoiceResponseChunk{
+		MessageID: result.ID,
+		SessionID: result.SessionID,
+		Seq:       result.Seq,
+		Final:     result.Final,
+		Text:      result.Text,
+		Audio:     result.Audio,
+	}, p.pushResponse(result.UserID))
+
 	return result
 }
+
+// pushResponse 返回向用户推送语音回复片段的函数
+func (p *VoiceProcessor) pushResponse(userID string) func(*model.VoiceResponseChunk) {
+	return func(chunk *model.VoiceResponseChunk) {
+		id, err := strconv.ParseUint(userID, 10, 64)
+		if err != nil {
+			logs.Error("parse user id %q error: %v", userID, err)
+			return
+		}
+
+		msgData, err := json.Marshal(websocket.Message{
+			Type:    websocket.VoiceResponse,
+			Payload: chunk,
+		})
+		if err != nil {
+			logs.Error("序列化TTS结果失败: %v", err)
+			return
+		}
+		if err := p.wsPool.SendToClient(id, msgData); err != nil {
+			logs.Error("推送TTS结果失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gorillaws "github.com/gorilla/websocket"
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/mq"
+	"github.com/sweekar/pkg/websocket"
+)
+
+// pipelineHarness 使用进程内消息总线和假实现的完整语音流水线，孩子通过WebSocket接收回复
+type pipelineHarness struct {
+	db        *gorm.DB
+	processor *VoiceProcessor
+	conn      *gorillaws.Conn
+}
+
+func newPipelineHarness(t *testing.T, childID uint64, llm ChatModel) *pipelineHarness {
+	t.Helper()
+	db := newTestDB(t)
+	if err := db.Create(&model.Character{Name: "小兔子", VoiceID: "rabbit", IsDefault: true}).Error; err != nil {
+		t.Fatalf("create character: %v", err)
+	}
+
+	// 孩子的WebSocket连接
+	pool := websocket.NewPool()
+	registered := make(chan struct{})
+	upgrader := gorillaws.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		pool.Register(&websocket.Client{Conn: conn, UserID: childID})
+		close(registered)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := gorillaws.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial websocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	<-registered
+
+	safety, err := NewSafetyFilter(db, nil)
+	if err != nil {
+		t.Fatalf("NewSafetyFilter: %v", err)
+	}
+	config := &VoiceProcessorConfig{
+		MQDriver:     mq.DriverMemory,
+		VADTopic:     "voice_vad",
+		ASRTopic:     "voice_asr",
+		LLMTopic:     "voice_llm",
+		TTSTopic:     "voice_tts",
+		AlertTopic:   "voice_alert",
+		EmotionTopic: "voice_emotion",
+	}
+	providers := &VoiceProviders{
+		VAD: &FakeVAD{},
+		ASR: &FakeRecognizer{},
+		LLM: llm,
+		TTS: &FakeSynthesizer{},
+	}
+	processor, err := NewVoiceProcessorWithProviders(config, providers,
+		NewCharacterService(db),
+		NewConversationStore(nil, nil, nil),
+		safety,
+		NewAlertService(db, nil, pool),
+		NewEmotionProcessor(db, nil, nil, nil, nil, nil),
+		pool)
+	if err != nil {
+		t.Fatalf("NewVoiceProcessorWithProviders: %v", err)
+	}
+	if err := processor.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { processor.Stop(context.Background()) })
+
+	return &pipelineHarness{db: db, processor: processor, conn: conn}
+}
+
+// readReply 读取推送给孩子的一轮回复，直到收到结束标记
+func (h *pipelineHarness) readReply(t *testing.T) []model.VoiceResponseChunk {
+	t.Helper()
+	h.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var chunks []model.VoiceResponseChunk
+	for {
+		_, data, err := h.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read reply: %v (got %d chunks)", err, len(chunks))
+		}
+		var msg struct {
+			Type    websocket.MessageType    `json:"type"`
+			Payload model.VoiceResponseChunk `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("decode reply: %v", err)
+		}
+		if msg.Type != websocket.VoiceResponse {
+			continue
+		}
+		chunks = append(chunks, msg.Payload)
+		if msg.Payload.Final {
+			return chunks
+		}
+	}
+}
+
+// waitFor 等待异步处理的结果，超时后测试失败
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestVoicePipeline(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		reply       string
+		wantTexts   []string
+		wantEmotion model.EmotionType
+		wantAlert   model.SafetyCategory
+	}{
+		{
+			name:        "逐句合成并按顺序推送",
+			text:        "我今天好开心",
+			reply:       "真为你高兴！今天发生了什么好玩的事呀？",
+			wantTexts:   []string{"真为你高兴！", "今天发生了什么好玩的事呀？", ""},
+			wantEmotion: model.EmotionHappy,
+		},
+		{
+			name:        "孩子求助时提醒家长",
+			text:        "救命，有人打我",
+			reply:       "别害怕，我在这里陪着你。",
+			wantTexts:   []string{"别害怕，我在这里陪着你。", ""},
+			wantEmotion: model.EmotionNeutral,
+			wantAlert:   model.SafetyDistress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const childID = 7
+			llm := &FakeChatModel{Replies: []string{tt.reply}}
+			h := newPipelineHarness(t, childID, llm)
+
+			msg := &model.VoiceMessage{
+				ID:        "msg-1",
+				UserID:    "7",
+				SessionID: "session-1",
+				Data:      []byte(tt.text),
+				CreatedAt: time.Now(),
+			}
+			if err := h.processor.ProcessVoice(context.Background(), msg); err != nil {
+				t.Fatalf("ProcessVoice: %v", err)
+			}
+
+			chunks := h.readReply(t)
+			var texts []string
+			for i, chunk := range chunks {
+				texts = append(texts, chunk.Text)
+				if chunk.Seq != i || chunk.MessageID != msg.ID || chunk.SessionID != msg.SessionID {
+					t.Errorf("chunk %d = seq %d message %s session %s", i, chunk.Seq, chunk.MessageID, chunk.SessionID)
+				}
+				if chunk.Text != "" && string(chunk.Audio) != "rabbit:"+chunk.Text {
+					t.Errorf("chunk %d audio = %q, want the character's voice", i, chunk.Audio)
+				}
+			}
+			if strings.Join(texts, "|") != strings.Join(tt.wantTexts, "|") {
+				t.Errorf("texts = %q, want %q", texts, tt.wantTexts)
+			}
+
+			// 大模型收到角色设定和孩子说的话
+			prompt := llm.Requests[0]
+			if prompt[0].Role != PromptRoleSystem || prompt[len(prompt)-1].Content != tt.text {
+				t.Errorf("prompt = %+v", prompt)
+			}
+
+			var record model.EmotionRecord
+			waitFor(t, "emotion record", func() bool {
+				return h.db.Where("message_id = ?", msg.ID).First(&record).Error == nil
+			})
+			if record.UserID != childID || record.Emotion != tt.wantEmotion {
+				t.Errorf("emotion record = user %d emotion %s, want user %d emotion %s", record.UserID, record.Emotion, childID, tt.wantEmotion)
+			}
+
+			if tt.wantAlert == "" {
+				return
+			}
+			var alert model.SafetyAlert
+			waitFor(t, "safety alert", func() bool {
+				return h.db.Where("message_id = ?", msg.ID).First(&alert).Error == nil
+			})
+			if alert.Category != tt.wantAlert || alert.UserID != childID {
+				t.Errorf("alert = category %s user %d, want %s user %d", alert.Category, alert.UserID, tt.wantAlert, childID)
+			}
+		})
+	}
+}
+
+func TestVoicePipelineEmptyText(t *testing.T) {
+	h := newPipelineHarness(t, 7, &FakeChatModel{})
+
+	// 没有识别出文字时客户端也能收到结束标记
+	asrResult := &model.ASRResult{VoiceMessage: model.VoiceMessage{ID: "msg-1", UserID: "7", SessionID: "session-1"}}
+	if _, err := h.processor.processLLM(context.Background(), asrResult); err != nil {
+		t.Fatalf("processLLM: %v", err)
+	}
+	chunks := h.readReply(t)
+	if len(chunks) != 1 || chunks[0].Text != "" || chunks[0].Seq != 0 {
+		t.Errorf("chunks = %+v, want a single empty final chunk", chunks)
+	}
+}
+
+func TestStreamReplySkipsSentSentences(t *testing.T) {
+	tests := []struct {
+		name         string
+		sent         []string // 重试之前已经发送的句子
+		reply        string   // 重试时重新生成的回复
+		wantSent     []string
+		wantResponse string
+	}{
+		{
+			name:         "第一次处理",
+			reply:        "真为你高兴！今天玩了什么呀？",
+			wantSent:     []string{"真为你高兴！", "今天玩了什么呀？", ""},
+			wantResponse: "真为你高兴！今天玩了什么呀？",
+		},
+		{
+			name:         "跳过已经发送的句子",
+			sent:         []string{"真为你高兴！"},
+			reply:        "太好了呀！今天玩了什么呀？",
+			wantSent:     []string{"今天玩了什么呀？", ""},
+			wantResponse: "真为你高兴！今天玩了什么呀？",
+		},
+		{
+			name:         "重新生成的回复更短",
+			sent:         []string{"真为你高兴！", "今天玩了什么呀？"},
+			reply:        "真为你高兴！",
+			wantSent:     []string{""},
+			wantResponse: "真为你高兴！今天玩了什么呀？",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			safety, err := NewSafetyFilter(nil, nil)
+			if err != nil {
+				t.Fatalf("NewSafetyFilter: %v", err)
+			}
+			p := &VoiceProcessor{llm: &FakeChatModel{Replies: []string{tt.reply}}, safety: safety}
+
+			var sent []string
+			var response strings.Builder
+			_, verdict, err := p.streamReply(context.Background(), nil, tt.sent, &response, func(sentence string, final bool) error {
+				sent = append(sent, sentence)
+				return nil
+			})
+			if err != nil || verdict != nil {
+				t.Fatalf("streamReply = %v, %v", verdict, err)
+			}
+			if strings.Join(sent, "|") != strings.Join(tt.wantSent, "|") {
+				t.Errorf("sent = %q, want %q", sent, tt.wantSent)
+			}
+			if response.String() != tt.wantResponse {
+				t.Errorf("response = %q, want %q", response.String(), tt.wantResponse)
+			}
+		})
+	}
+}
+
+// countingVAD 记录调用次数，所有音频都视为静音
+type countingVAD struct {
+	calls int
+}
+
+func (v *countingVAD) Detect(ctx context.Context, msg *model.VoiceMessage) (*model.VADResult, error) {
+	v.calls++
+	return &model.VADResult{VoiceMessage: *msg}, nil
+}
+
+func TestProcessVADSegmented(t *testing.T) {
+	tests := []struct {
+		name       string
+		segmented  bool
+		data       []byte
+		wantCalls  int
+		wantSpeech bool
+	}{
+		{name: "服务端切分好的语音段不再检测", segmented: true, data: make([]byte, 3200), wantSpeech: true},
+		{name: "服务端切分好的空语音段", segmented: true},
+		{name: "上传的整段音频由VAD检测", data: make([]byte, 3200), wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vad := &countingVAD{}
+			p := &VoiceProcessor{vad: vad}
+			msg := &model.VoiceMessage{ID: "msg-1", SampleRate: 16000, Segmented: tt.segmented, Data: tt.data}
+
+			result := p.processVAD(context.Background(), msg)
+			if vad.calls != tt.wantCalls {
+				t.Errorf("VAD called %d times, want %d", vad.calls, tt.wantCalls)
+			}
+			if result.IsSpeech != tt.wantSpeech {
+				t.Errorf("IsSpeech = %v, want %v", result.IsSpeech, tt.wantSpeech)
+			}
+			if tt.wantSpeech && (len(result.AudioSegment) != len(tt.data) || result.SpeechEnd != 100*time.Millisecond) {
+				t.Errorf("segment = %d bytes ending at %v, want the whole %d bytes ending at 100ms", len(result.AudioSegment), result.SpeechEnd, len(tt.data))
+			}
+		})
+	}
+}
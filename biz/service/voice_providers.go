@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/sweekar/biz/model"
+)
+
+// 内置的语音流水线实现名称
+const (
+	ProviderEnergy = "energy"
+	ProviderFunASR = "funasr"
+	ProviderOpenAI = "openai"
+	ProviderTTS    = "tts"
+	ProviderFake   = "fake"
+)
+
+// 消息角色
+const (
+	PromptRoleSystem    = "system"
+	PromptRoleUser      = "user"
+	PromptRoleAssistant = "assistant"
+)
+
+// PromptMessage 发送给对话大模型的一条消息
+type PromptMessage struct {
+	Role    string
+	Content string
+}
+
+// VADEngine 语音活动检测
+type VADEngine interface {
+	Detect(ctx context.Context, msg *model.VoiceMessage) (*model.VADResult, error)
+}
+
+// Recognizer 语音识别
+type Recognizer interface {
+	Recognize(ctx context.Context, audio []byte) (string, error)
+}
+
+// ChatModel 对话大模型
+type ChatModel interface {
+	Chat(ctx context.Context, messages []PromptMessage) (string, error)
+	// ChatStream 流式生成回复，每生成一段文本调用一次onDelta，onDelta返回错误时停止生成
+	ChatStream(ctx context.Context, messages []PromptMessage, onDelta func(delta string) error) error
+}
+
+// Synthesizer 语音合成
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string, voiceID string) ([]byte, error)
+}
+
+// VoiceProviders 语音流水线各阶段使用的实现
+type VoiceProviders struct {
+	VAD VADEngine
+	ASR Recognizer
+	LLM ChatModel
+	TTS Synthesizer
+}
+
+// providerRegistry 按名称注册的实现工厂
+type providerRegistry[T any] struct {
+	kind      string
+	fallback  string
+	factories map[string]func(config *VoiceProcessorConfig) (T, error)
+	mutex     sync.RWMutex
+}
+
+func (r *providerRegistry[T]) register(name string, factory func(config *VoiceProcessorConfig) (T, error)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.factories[name] = factory
+}
+
+func (r *providerRegistry[T]) create(name string, config *VoiceProcessorConfig) (T, error) {
+	if name == "" {
+		name = r.fallback
+	}
+
+	r.mutex.RLock()
+	factory, ok := r.factories[name]
+	r.mutex.RUnlock()
+
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("unknown %s provider: %s", r.kind, name)
+	}
+
+	provider, err := factory(config)
+	if err != nil {
+		return provider, fmt.Errorf("init %s provider %s error: %v", r.kind, name, err)
+	}
+	return provider, nil
+}
+
+var (
+	vadProviders = &providerRegistry[VADEngine]{
+		kind:     "vad",
+		fallback: ProviderEnergy,
+		factories: map[string]func(*VoiceProcessorConfig) (VADEngine, error){
+			ProviderEnergy: newEnergyVAD,
+			ProviderFake:   func(*VoiceProcessorConfig) (VADEngine, error) { return &FakeVAD{}, nil },
+		},
+	}
+	asrProviders = &providerRegistry[Recognizer]{
+		kind:     "asr",
+		fallback: ProviderFunASR,
+		factories: map[string]func(*VoiceProcessorConfig) (Recognizer, error){
+			ProviderFunASR: newFunASRRecognizer,
+			ProviderFake:   func(*VoiceProcessorConfig) (Recognizer, error) { return &FakeRecognizer{}, nil },
+		},
+	}
+	llmProviders = &providerRegistry[ChatModel]{
+		kind:     "llm",
+		fallback: ProviderOpenAI,
+		factories: map[string]func(*VoiceProcessorConfig) (ChatModel, error){
+			ProviderOpenAI: newOpenAIChatModel,
+			ProviderFake:   func(*VoiceProcessorConfig) (ChatModel, error) { return &FakeChatModel{}, nil },
+		},
+	}
+	ttsProviders = &providerRegistry[Synthesizer]{
+		kind:     "tts",
+		fallback: ProviderTTS,
+		factories: map[string]func(*VoiceProcessorConfig) (Synthesizer, error){
+			ProviderTTS:  newTTSSynthesizer,
+			ProviderFake: func(*VoiceProcessorConfig) (Synthesizer, error) { return &FakeSynthesizer{}, nil },
+		},
+	}
+)
+
+// RegisterVADEngine 注册VAD实现
+func RegisterVADEngine(name string, factory func(config *VoiceProcessorConfig) (VADEngine, error)) {
+	vadProviders.register(name, factory)
+}
+
+// RegisterRecognizer 注册ASR实现
+func RegisterRecognizer(name string, factory func(config *VoiceProcessorConfig) (Recognizer, error)) {
+	asrProviders.register(name, factory)
+}
+
+// RegisterChatModel 注册LLM实现
+func RegisterChatModel(name string, factory func(config *VoiceProcessorConfig) (ChatModel, error)) {
+	llmProviders.register(name, factory)
+}
+
+// RegisterSynthesizer 注册TTS实现
+func RegisterSynthesizer(name string, factory func(config *VoiceProcessorConfig) (Synthesizer, error)) {
+	ttsProviders.register(name, factory)
+}
+
+// NewChatModel 按配置创建LLM实现
+func NewChatModel(config *VoiceProcessorConfig) (ChatModel, error) {
+	return llmProviders.create(config.LLMProvider, config)
+}
+
+// NewVoiceProviders 按配置创建语音流水线各阶段的实现
+func NewVoiceProviders(config *VoiceProcessorConfig) (*VoiceProviders, error) {
+	vad, err := vadProviders.create(config.VADProvider, config)
+	if err != nil {
+		return nil, err
+	}
+
+	asr, err := asrProviders.create(config.ASRProvider, config)
+	if err != nil {
+		return nil, err
+	}
+
+	llm, err := NewChatModel(config)
+	if err != nil {
+		return nil, err
+	}
+
+	synthesizer, err := ttsProviders.create(config.TTSProvider, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoiceProviders{
+		VAD: vad,
+		ASR: asr,
+		LLM: llm,
+		TTS: synthesizer,
+	}, nil
+}
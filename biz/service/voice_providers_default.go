@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/sweekar/biz/model"
+	"github.com/sweekar/pkg/audio"
+)
+
+// energyVAD 基于帧能量和过零率的VAD
+type energyVAD struct {
+	config *model.VADConfig
+}
+
+func newEnergyVAD(config *VoiceProcessorConfig) (VADEngine, error) {
+	return &energyVAD{config: config.VADConfig}, nil
+}
+
+// Detect 检测语音片段，裁掉首尾静音，没有语音时IsSpeech为false
+func (v *energyVAD) Detect(ctx context.Context, msg *model.VoiceMessage) (*model.VADResult, error) {
+	config := audio.DefaultVADConfig()
+	if v.config != nil {
+		config = *v.config
+	}
+	if msg.SampleRate > 0 {
+		config.SampleRate = msg.SampleRate
+	}
+	if config.SampleRate <= 0 {
+		config.SampleRate = audio.DefaultVADConfig().SampleRate
+	}
+
+	segments := audio.NewDetector(&config).Detect(msg.Data)
+	if len(segments) == 0 {
+		return &model.VADResult{
+			VoiceMessage: *msg,
+			IsSpeech:     false,
+		}, nil
+	}
+
+	// 一次上传中的多个语音段合并为一段，保留段间的停顿
+	first, last := segments[0], segments[len(segments)-1]
+	return &model.VADResult{
+		VoiceMessage: *msg,
+		IsSpeech:     true,
+		SpeechStart:  first.Start,
+		SpeechEnd:    last.End,
+		AudioSegment: pcmRange(msg.Data, config.SampleRate, first.Start, last.End),
+	}, nil
+}
+
+// pcmRange 截取16bit PCM数据中[start, end)时间范围的音频
+func pcmRange(data []byte, sampleRate int, start, end time.Duration) []byte {
+	offset := func(d time.Duration) int {
+		n := int(int64(d)*int64(sampleRate)/int64(time.Second)) * 2
+		return min(n, len(data))
+	}
+	return data[offset(start):offset(end)]
+}
+
+// funASRRecognizer 基于FunASR的语音识别
+type funASRRecognizer struct {
+	client *funasr.Client
+}
+
+func newFunASRRecognizer(config *VoiceProcessorConfig) (Recognizer, error) {
+	client, err := funasr.NewClient(config.ASRConfig)
+	if err != nil {
+		return nil, fmt.Errorf("init asr client error: %v", err)
+	}
+	return &funASRRecognizer{client: client}, nil
+}
+
+// Recognize 识别语音内容
+func (r *funASRRecognizer) Recognize(ctx context.Context, audio []byte) (string, error) {
+	return r.client.Recognize(audio)
+}
+
+// openAIChatModel 基于OpenAI接口的对话大模型
+type openAIChatModel struct {
+	client *openai.Client
+	model  string
+}
+
+func newOpenAIChatModel(config *VoiceProcessorConfig) (ChatModel, error) {
+	llmModel := config.LLMModel
+	if llmModel == "" {
+		llmModel = openai.GPT3Dot5Turbo
+	}
+	return &openAIChatModel{
+		client: openai.NewClient(config.LLMAPIKey),
+		model:  llmModel,
+	}, nil
+}
+
+// Chat 生成对话回复
+func (m *openAIChatModel) Chat(ctx context.Context, messages []PromptMessage) (string, error) {
+	resp, err := m.client.CreateChatCompletion(ctx, m.request(messages))
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("empty chat completion response")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// ChatStream 流式生成对话回复
+func (m *openAIChatModel) ChatStream(ctx context.Context, messages []PromptMessage, onDelta func(delta string) error) error {
+	stream, err := m.client.CreateChatCompletionStream(ctx, m.request(messages))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+		if err := onDelta(resp.Choices[0].Delta.Content); err != nil {
+			return err
+		}
+	}
+}
+
+// request 构造OpenAI对话请求
+func (m *openAIChatModel) request(messages []PromptMessage) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{
+		Model:    m.model,
+		Messages: make([]openai.ChatCompletionMessage, 0, len(messages)),
+	}
+	for _, msg := range messages {
+		req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+	return req
+}
+
+// ttsSynthesizer 基于TTS服务的语音合成
+type ttsSynthesizer struct {
+	client *tts.Client
+}
+
+func newTTSSynthesizer(config *VoiceProcessorConfig) (Synthesizer, error) {
+	client, err := tts.NewClient(config.TTSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("init tts client error: %v", err)
+	}
+	return &ttsSynthesizer{client: client}, nil
+}
+
+// Synthesize 合成语音
+func (s *ttsSynthesizer) Synthesize(ctx context.Context, text string, voiceID string) ([]byte, error) {
+	return s.client.Synthesize(text, voiceID)
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+// FakeVAD 将所有非空音频视为一段完整语音，用于测试和本地开发
+type FakeVAD struct{}
+
+// Detect 检测语音片段，按16kHz 16bit单声道计算语音时长
+func (v *FakeVAD) Detect(ctx context.Context, msg *model.VoiceMessage) (*model.VADResult, error) {
+	return &model.VADResult{
+		VoiceMessage: *msg,
+		IsSpeech:     len(msg.Data) > 0,
+		SpeechStart:  0,
+		SpeechEnd:    time.Duration(len(msg.Data)/32) * time.Millisecond,
+		AudioSegment: msg.Data,
+	}, nil
+}
+
+// FakeRecognizer 返回固定文本的语音识别，Text为空时把音频内容直接当作文本
+type FakeRecognizer struct {
+	Text string
+}
+
+// Recognize 识别语音内容
+func (r *FakeRecognizer) Recognize(ctx context.Context, audio []byte) (string, error) {
+	if r.Text != "" {
+		return r.Text, nil
+	}
+	return string(audio), nil
+}
+
+// FakeChatModel 按顺序返回预设回复的对话模型，回复用完后复述孩子说的最后一句话
+type FakeChatModel struct {
+	Replies []string
+
+	// Requests 记录每次调用收到的消息
+	Requests [][]PromptMessage
+	mutex    sync.Mutex
+}
+
+// Chat 生成对话回复
+func (m *FakeChatModel) Chat(ctx context.Context, messages []PromptMessage) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Requests = append(m.Requests, messages)
+	if len(m.Requests) <= len(m.Replies) {
+		return m.Replies[len(m.Requests)-1], nil
+	}
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == PromptRoleUser {
+			return "你说的是：" + messages[i].Content, nil
+		}
+	}
+	return "", nil
+}
+
+// ChatStream 流式生成对话回复，每次输出两个字
+func (m *FakeChatModel) ChatStream(ctx context.Context, messages []PromptMessage, onDelta func(delta string) error) error {
+	reply, err := m.Chat(ctx, messages)
+	if err != nil {
+		return err
+	}
+
+	runes := []rune(reply)
+	for i := 0; i < len(runes); i += 2 {
+		if err := onDelta(string(runes[i:min(i+2, len(runes))])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FakeSynthesizer 以"音色:文本"作为音频内容的语音合成
+type FakeSynthesizer struct{}
+
+// Synthesize 合成语音
+func (s *FakeSynthesizer) Synthesize(ctx context.Context, text string, voiceID string) ([]byte, error) {
+	return []byte(voiceID + ":" + text), nil
+}
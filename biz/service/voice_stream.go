@@ -0,0 +1,218 @@
+package service
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// minSentenceRunes 过短的句子与下一句合并，避免合成大量零碎音频
+	minSentenceRunes = 4
+	// maxSentenceRunes 句子超过该长度时在逗号处切分
+	maxSentenceRunes = 50
+	// sequenceTimeout 回复片段缺失时等待的最长时间
+	sequenceTimeout = 2 * time.Minute
+)
+
+// sentenceSplitter 将流式生成的文本切分为适合逐句合成的句子
+type sentenceSplitter struct {
+	buf []rune
+}
+
+// Push 追加生成的文本，返回已完整的句子
+func (s *sentenceSplitter) Push(delta string) []string {
+	s.buf = append(s.buf, []rune(delta)...)
+
+	var sentences []string
+	start := 0
+	for i := 0; i < len(s.buf); i++ {
+		length := i + 1 - start
+		switch {
+		case isSentenceEnd(s.buf[i]):
+			// 连续的句末标点和后引号归入同一句
+			for i+1 < len(s.buf) && (isSentenceEnd(s.buf[i+1]) || isClosingMark(s.buf[i+1])) {
+				i++
+			}
+			if length < minSentenceRunes {
+				continue
+			}
+		case isClauseEnd(s.buf[i]) && length >= maxSentenceRunes:
+		case length >= maxSentenceRunes*2:
+		default:
+			continue
+		}
+
+		if sentence := strings.TrimSpace(string(s.buf[start : i+1])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = i + 1
+	}
+
+	s.buf = append([]rune(nil), s.buf[start:]...)
+	return sentences
+}
+
+// Flush 返回剩余未结束的文本
+func (s *sentenceSplitter) Flush() string {
+	text := strings.TrimSpace(string(s.buf))
+	s.buf = nil
+	return text
+}
+
+func isSentenceEnd(r rune) bool {
+	return strings.ContainsRune("。！？!?；;…~～\n", r)
+}
+
+func isClauseEnd(r rune) bool {
+	return strings.ContainsRune("，,、：:", r)
+}
+
+func isClosingMark(r rune) bool {
+	return strings.ContainsRune("”’」』）)\"'", r)
+}
+
+// turnSequence 一轮回复的片段排序状态
+type turnSequence struct {
+	next      int
+	pending   map[int]*model.VoiceResponseChunk
+	finished  bool // 已经发送结束片段，保留到超时清理，避免重复投递的片段被当作新的一轮重新发送
+	updatedAt time.Time
+	mutex     sync.Mutex
+}
+
+// responseSequencer 保证同一轮回复的片段按序号推送，TTS并发合成时片段可能乱序完成
+type responseSequencer struct {
+	turns     map[string]*turnSequence
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+func newResponseSequencer() *responseSequencer {
+	return &responseSequencer{
+		turns:     make(map[string]*turnSequence),
+		lastSweep: time.Now(),
+	}
+}
+
+// Push 加入一个回复片段，并按顺序对所有可发送的片段调用send
+func (s *responseSequencer) Push(chunk *model.VoiceResponseChunk, send func(*model.VoiceResponseChunk)) {
+	turn := s.turn(chunk.MessageID)
+
+	turn.mutex.Lock()
+	defer turn.mutex.Unlock()
+
+	// 重试导致的重复片段
+	if turn.finished || chunk.Seq < turn.next {
+		return
+	}
+	turn.pending[chunk.Seq] = chunk
+	turn.updatedAt = time.Now()
+
+	for {
+		next, ok := turn.pending[turn.next]
+		if !ok {
+			return
+		}
+		delete(turn.pending, turn.next)
+		turn.next++
+		send(next)
+
+		// 结束的回复由turn在超时后清理，这里持有turn.mutex时不能再获取s.mutex
+		if next.Final {
+			turn.finished = true
+			turn.pending = nil
+			return
+		}
+	}
+}
+
+// turn 获取一轮回复的排序状态，并清理长时间未更新的回复
+func (s *responseSequencer) turn(messageID string) *turnSequence {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sequenceTimeout {
+		s.lastSweep = now
+		for id, t := range s.turns {
+			t.mutex.Lock()
+			stale := now.Sub(t.updatedAt) > sequenceTimeout
+			t.mutex.Unlock()
+			if stale {
+				delete(s.turns, id)
+			}
+		}
+	}
+
+	turn, ok := s.turns[messageID]
+	if !ok {
+		turn = &turnSequence{
+			pending:   make(map[int]*model.VoiceResponseChunk),
+			updatedAt: now,
+		}
+		s.turns[messageID] = turn
+	}
+	return turn
+}
+
+// sentReply 一条语音消息已经发送合成的回复
+type sentReply struct {
+	sentences []string
+	final     bool
+	updatedAt time.Time
+}
+
+// replyJournal 记录每条语音消息已经发送合成的句子，消息队列重试LLM处理时跳过孩子已经听到的句子，
+// 已经发送结束标记的消息不再重新生成
+type replyJournal struct {
+	replies   map[string]*sentReply
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+func newReplyJournal() *replyJournal {
+	return &replyJournal{
+		replies:   make(map[string]*sentReply),
+		lastSweep: time.Now(),
+	}
+}
+
+// Sent 返回消息已经发送的句子，以及是否已经发送了结束标记
+func (j *replyJournal) Sent(messageID string) ([]string, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	reply, ok := j.replies[messageID]
+	if !ok {
+		return nil, false
+	}
+	return append([]string(nil), reply.sentences...), reply.final
+}
+
+// Record 记录一句已经发送的回复，并清理长时间没有更新的记录
+func (j *replyJournal) Record(messageID, sentence string, final bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(j.lastSweep) > sequenceTimeout {
+		j.lastSweep = now
+		for id, reply := range j.replies {
+			if now.Sub(reply.updatedAt) > sequenceTimeout {
+				delete(j.replies, id)
+			}
+		}
+	}
+
+	reply, ok := j.replies[messageID]
+	if !ok {
+		reply = &sentReply{}
+		j.replies[messageID] = reply
+	}
+	reply.sentences = append(reply.sentences, sentence)
+	reply.final = reply.final || final
+	reply.updatedAt = now
+}
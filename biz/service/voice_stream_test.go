@@ -0,0 +1,156 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+func TestSentenceSplitter(t *testing.T) {
+	long := strings.Repeat("好", maxSentenceRunes)
+	tests := []struct {
+		name      string
+		deltas    []string
+		sentences []string
+		rest      string
+	}{
+		{
+			name:      "按句末标点切分",
+			deltas:    []string{"你好呀小朋友。今天过得怎么样？"},
+			sentences: []string{"你好呀小朋友。", "今天过得怎么样？"},
+		},
+		{
+			name:      "跨多个片段的句子",
+			deltas:    []string{"我们一起", "去看", "星星吧！还", "有月亮"},
+			sentences: []string{"我们一起去看星星吧！"},
+			rest:      "还有月亮",
+		},
+		{
+			name:      "过短的句子与下一句合并",
+			deltas:    []string{"好。我们开始讲故事吧。"},
+			sentences: []string{"好。我们开始讲故事吧。"},
+		},
+		{
+			name:      "连续的句末标点和后引号归入同一句",
+			deltas:    []string{"小兔子说：“真好玩！”然后跑走了。"},
+			sentences: []string{"小兔子说：“真好玩！”", "然后跑走了。"},
+		},
+		{
+			name:      "过长的句子在逗号处切分",
+			deltas:    []string{long + "，后面还有。"},
+			sentences: []string{long + "，", "后面还有。"},
+		},
+		{
+			name:      "没有标点的长文本强制切分",
+			deltas:    []string{long + long + "尾巴"},
+			sentences: []string{long + long},
+			rest:      "尾巴",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s sentenceSplitter
+			var sentences []string
+			for _, delta := range tt.deltas {
+				sentences = append(sentences, s.Push(delta)...)
+			}
+			if !reflect.DeepEqual(sentences, tt.sentences) {
+				t.Errorf("sentences = %q, want %q", sentences, tt.sentences)
+			}
+			if rest := s.Flush(); rest != tt.rest {
+				t.Errorf("rest = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestResponseSequencer(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []int // 到达顺序
+		want []int // 发送顺序
+	}{
+		{name: "顺序到达", seqs: []int{0, 1, 2}, want: []int{0, 1, 2}},
+		{name: "乱序到达", seqs: []int{2, 0, 1}, want: []int{0, 1, 2}},
+		{name: "重复片段", seqs: []int{0, 0, 1, 0, 2}, want: []int{0, 1, 2}},
+		{name: "缺失片段时等待", seqs: []int{0, 2}, want: []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newResponseSequencer()
+			var sent []int
+			for _, seq := range tt.seqs {
+				s.Push(&model.VoiceResponseChunk{MessageID: "m1", Seq: seq, Final: seq == 2}, func(c *model.VoiceResponseChunk) {
+					sent = append(sent, c.Seq)
+				})
+			}
+			if !reflect.DeepEqual(sent, tt.want) {
+				t.Errorf("sent = %v, want %v", sent, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseSequencerSeparatesMessages(t *testing.T) {
+	s := newResponseSequencer()
+	sent := make(map[string][]int)
+	push := func(messageID string, seq int, final bool) {
+		s.Push(&model.VoiceResponseChunk{MessageID: messageID, Seq: seq, Final: final}, func(c *model.VoiceResponseChunk) {
+			sent[c.MessageID] = append(sent[c.MessageID], c.Seq)
+		})
+	}
+
+	push("m1", 1, true)
+	push("m2", 0, true)
+	push("m1", 0, false)
+	// 结束后重复投递的片段不会被当作新的一轮重新发送
+	push("m2", 0, true)
+	push("m1", 0, false)
+
+	want := map[string][]int{"m1": {0, 1}, "m2": {0}}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+	for id, turn := range s.turns {
+		if !turn.finished {
+			t.Errorf("turn %s not finished after final chunk", id)
+		}
+	}
+}
+
+func TestResponseSequencerConcurrentSweep(t *testing.T) {
+	s := newResponseSequencer()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				// 让每次获取回复状态时都清理一次，与结束回复并发执行
+				s.mutex.Lock()
+				s.lastSweep = time.Time{}
+				s.mutex.Unlock()
+				messageID := fmt.Sprintf("m%d-%d", i, j)
+				s.Push(&model.VoiceResponseChunk{MessageID: messageID, Seq: 0, Final: true}, func(*model.VoiceResponseChunk) {})
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Push deadlocked with the sweep")
+	}
+}
@@ -3,10 +3,12 @@ package api
 import (
     "github.com/gin-gonic/gin"
     "github.com/sweekar/biz/handler"
+    "github.com/sweekar/biz/model"
+    "github.com/sweekar/biz/service"
     "github.com/sweekar/pkg/middleware"
 )
 
-func SetupRouter(userHandler *handler.UserHandler, chatHandler *handler.ChatHandler, emotionHandler *handler.EmotionHandler) *gin.Engine {
+func SetupRouter(userHandler *handler.UserHandler, chatHandler *handler.ChatHandler, emotionHandler *handler.EmotionHandler, characterHandler *handler.CharacterHandler, safetyHandler *handler.SafetyHandler, familyHandler *handler.FamilyHandler, adminHandler *handler.AdminHandler, notificationHandler *handler.NotificationHandler, deviceHandler *handler.DeviceHandler, guardianHandler *handler.GuardianHandler, authService *service.AuthService, userService *service.UserService) *gin.Engine {
     router := gin.Default()
 
     // 用户服务API
@@ -14,27 +16,118 @@ func SetupRouter(userHandler *handler.UserHandler, chatHandler *handler.ChatHand
     {
         userGroup.POST("/register", userHandler.Register)
         userGroup.POST("/login", userHandler.Login)
+        userGroup.POST("/refresh", userHandler.Refresh)
     }
 
+    // 孩子设备用配对码换取设备令牌
+    router.POST("/api/v1/devices/pair", deviceHandler.Pair)
+
+    // WebSocket连接，所有角色都可以连接：孩子进行语音聊天，家长接收报告和提醒；孩子设备的语音令牌只能访问该接口
+    router.GET("/api/v1/ws", middleware.AuthMiddleware(authService, service.TokenScopeVoice), chatHandler.HandleWebSocket)
+
     // 需要认证的API组
     authGroup := router.Group("/api/v1")
-    authGroup.Use(middleware.AuthMiddleware())
+    authGroup.Use(middleware.AuthMiddleware(authService))
     {
-        // WebSocket连接
-        authGroup.GET("/ws", chatHandler.HandleWebSocket)
+        // 用户信息API
+        profileGroup := authGroup.Group("/user")
+        {
+            profileGroup.GET("/profile", userHandler.GetProfile)
+            profileGroup.PUT("/profile", userHandler.UpdateProfile)
+            profileGroup.PUT("/password", userHandler.ChangePassword)
+            profileGroup.POST("/logout", userHandler.Logout)
+        }
+
+        // 以下为家长管理孩子的API
+        manageGroup := authGroup.Group("")
+        manageGroup.Use(middleware.RequirePermission(model.PermissionManageChildren))
+
+        // 孩子管理API
+        childGroup := manageGroup.Group("/children")
+        {
+            childGroup.GET("", userHandler.ListChildren)
+            childGroup.POST("", userHandler.CreateChild)
+            childGroup.GET("/:id", userHandler.GetChild)
+            childGroup.PUT("/:id", userHandler.UpdateChild)
+            childGroup.DELETE("/:id", userHandler.DeleteChild)
+            childGroup.POST("/:id/pairing-code", deviceHandler.CreatePairingCode)
+        }
+
+        // 孩子设备管理API
+        deviceGroup := manageGroup.Group("/devices")
+        {
+            deviceGroup.GET("", deviceHandler.ListDevices)
+            deviceGroup.DELETE("/:id", deviceHandler.RevokeDevice)
+        }
+
+        // 家庭设置API
+        familyGroup := manageGroup.Group("/family")
+        {
+            familyGroup.GET("/settings", familyHandler.GetSettings)
+            familyGroup.PUT("/settings", familyHandler.UpdateSettings)
+            familyGroup.GET("/notifications", notificationHandler.GetSettings)
+            familyGroup.PUT("/notifications", notificationHandler.UpdateSettings)
+            familyGroup.GET("/guardians", guardianHandler.ListGuardians)
+            familyGroup.PUT("/guardians/:id", guardianHandler.UpdateGuardian)
+            familyGroup.DELETE("/guardians/:id", guardianHandler.RemoveGuardian)
+            familyGroup.GET("/invitations", guardianHandler.ListInvitations)
+            familyGroup.POST("/invitations", guardianHandler.Invite)
+            familyGroup.DELETE("/invitations/:id", guardianHandler.RevokeInvitation)
+            familyGroup.POST("/invitations/accept", guardianHandler.AcceptInvitation)
+        }
+
+        // 通知记录API
+        authGroup.GET("/notifications", middleware.RequirePermission(model.PermissionViewChildren), notificationHandler.ListNotifications)
+
+        // 以下为家长查看孩子数据的API，用child_id参数指定自己监护的孩子
+        childDataGroup := authGroup.Group("")
+        childDataGroup.Use(middleware.RequirePermission(model.PermissionViewChildren), middleware.ChildAccess(userService))
 
         // 聊天服务API
-        chatGroup := authGroup.Group("/chat")
+        chatGroup := childDataGroup.Group("/chat")
         {
             chatGroup.GET("/history", chatHandler.GetChatHistory)
         }
 
         // 情绪分析服务API
-        emotionGroup := authGroup.Group("/emotion")
+        emotionGroup := childDataGroup.Group("/emotion")
         {
             emotionGroup.GET("/report", emotionHandler.GetEmotionReport)
+            emotionGroup.GET("/reports", emotionHandler.GetEmotionReports)
             emotionGroup.GET("/trend", emotionHandler.GetEmotionTrend)
         }
+
+        // 内容安全API
+        safetyGroup := childDataGroup.Group("/safety")
+        {
+            safetyGroup.GET("/interventions", safetyHandler.ListInterventions)
+            safetyGroup.PUT("/interventions/:id/review", safetyHandler.ReviewIntervention)
+            safetyGroup.GET("/alerts", safetyHandler.ListAlerts)
+            safetyGroup.PUT("/alerts/:id/ack", safetyHandler.AcknowledgeAlert)
+        }
+
+        // 系统角色API，管理员维护系统角色
+        characterGroup := authGroup.Group("/characters")
+        {
+            characterGroup.GET("", middleware.RequirePermission(model.PermissionViewCharacters), characterHandler.ListCharacters)
+            characterGroup.GET("/:id", middleware.RequirePermission(model.PermissionViewCharacters), characterHandler.GetCharacter)
+            characterGroup.POST("", middleware.RequirePermission(model.PermissionOperate), characterHandler.CreateCharacter)
+            characterGroup.PUT("/:id", middleware.RequirePermission(model.PermissionOperate), characterHandler.UpdateCharacter)
+            characterGroup.DELETE("/:id", middleware.RequirePermission(model.PermissionOperate), characterHandler.DeleteCharacter)
+        }
+
+        // 运维管理API
+        adminGroup := authGroup.Group("/admin")
+        adminGroup.Use(middleware.RequirePermission(model.PermissionOperate))
+        {
+            adminGroup.POST("/reports/backfill", adminHandler.Backfill)
+            adminGroup.POST("/reports/:id/push", adminHandler.RepushReport)
+            adminGroup.GET("/jobs", adminHandler.ListJobs)
+            adminGroup.PUT("/jobs/:job/pause", adminHandler.PauseJob)
+            adminGroup.PUT("/jobs/:job/resume", adminHandler.ResumeJob)
+            adminGroup.GET("/jobs/runs", adminHandler.ListJobRuns)
+            adminGroup.POST("/jobs/runs/:id/retry", adminHandler.RetryJobRun)
+        }
     }
 
     return router
@@ -0,0 +1,51 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// minDBFS 静音帧的能量下限
+const minDBFS = -100.0
+
+// DecodePCM16 解码16bit小端单声道PCM数据，末尾不足一个采样点的字节被忽略
+func DecodePCM16(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[i*2:]))
+	}
+	return samples
+}
+
+// FrameEnergy 计算帧的均方根能量，单位dBFS
+func FrameEnergy(samples []int16) float64 {
+	if len(samples) == 0 {
+		return minDBFS
+	}
+
+	var sum float64
+	for _, s := range samples {
+		v := float64(s) / 32768
+		sum += v * v
+	}
+	rms := math.Sqrt(sum / float64(len(samples)))
+	if rms == 0 {
+		return minDBFS
+	}
+	return math.Max(20*math.Log10(rms), minDBFS)
+}
+
+// ZeroCrossingRate 计算帧的过零率，取值0~1
+func ZeroCrossingRate(samples []int16) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] >= 0) != (samples[i] >= 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / float64(len(samples)-1)
+}
@@ -0,0 +1,184 @@
+package audio
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// prosodyHop 韵律分析的帧移
+	prosodyHop = 20 * time.Millisecond
+	// pitchWindow 基频估计的分析窗长，需覆盖最低基频的两个周期
+	pitchWindow = 40 * time.Millisecond
+	// 儿童语音的基频搜索范围
+	minPitch = 120.0
+	maxPitch = 600.0
+	// voicingThreshold 归一化自相关超过该值的帧视为浊音
+	voicingThreshold = 0.5
+	// speechMargin 语音帧能量需高出环境噪声的dB数
+	speechMargin = 10.0
+	// minPause 句中停顿的最短时长
+	minPause = 150 * time.Millisecond
+	// syllableProminence 能量峰值高出前一个谷值该dB数时计为一个音节
+	syllableProminence = 3.0
+)
+
+// ExtractProsody 从16bit PCM语音段中提取基频、能量、语速和停顿特征
+func ExtractProsody(data []byte, sampleRate int) *model.ProsodyFeatures {
+	samples := DecodePCM16(data)
+	hop := int(int64(sampleRate) * int64(prosodyHop) / int64(time.Second))
+	window := int(int64(sampleRate) * int64(pitchWindow) / int64(time.Second))
+	features := &model.ProsodyFeatures{
+		Duration: time.Duration(len(samples)) * time.Second / time.Duration(max(sampleRate, 1)),
+	}
+	if hop == 0 || len(samples) < hop {
+		return features
+	}
+
+	// 逐帧计算能量，按环境噪声判断语音帧
+	frames := len(samples) / hop
+	energies := make([]float64, frames)
+	for i := range energies {
+		energies[i] = FrameEnergy(samples[i*hop : (i+1)*hop])
+	}
+	noise := percentile(energies, 0.1)
+	threshold := math.Max(noise+speechMargin, DefaultVADConfig().EnergyThreshold-10)
+	speech := make([]bool, frames)
+	var speechEnergies []float64
+	for i, e := range energies {
+		if e >= threshold {
+			speech[i] = true
+			speechEnergies = append(speechEnergies, e)
+		}
+	}
+	if len(speechEnergies) == 0 {
+		return features
+	}
+	features.EnergyMean, features.EnergyStd = meanStd(speechEnergies)
+
+	// 语音帧的基频
+	var pitches []float64
+	for i := range speech {
+		if !speech[i] || i*hop+window > len(samples) {
+			continue
+		}
+		if f0 := estimatePitch(samples[i*hop:i*hop+window], sampleRate); f0 > 0 {
+			pitches = append(pitches, f0)
+		}
+	}
+	if len(pitches) > 0 {
+		features.PitchMean, features.PitchStd = meanStd(pitches)
+		features.PitchRange = percentile(pitches, 0.95) - percentile(pitches, 0.05)
+	}
+
+	// 首尾语音帧之间的静音段为句中停顿
+	first, last := -1, -1
+	for i, s := range speech {
+		if s {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	pauseFrames, run := 0, 0
+	minPauseFrames := int(minPause / prosodyHop)
+	for i := first; i <= last; i++ {
+		if !speech[i] {
+			run++
+			continue
+		}
+		if run >= minPauseFrames {
+			features.PauseCount++
+			pauseFrames += run
+		}
+		run = 0
+	}
+	spoken := last - first + 1
+	features.PauseRatio = float64(pauseFrames) / float64(spoken)
+
+	// 用能量包络中足够突出的峰值近似音节数
+	syllables := 0
+	valley := noise
+	for i := first; i <= last; i++ {
+		e := energies[i]
+		valley = math.Min(valley, e)
+		if !speech[i] {
+			continue
+		}
+		peak := (i == first || e >= energies[i-1]) && (i == last || e >= energies[i+1])
+		if peak && e-valley >= syllableProminence {
+			syllables++
+			valley = e
+		}
+	}
+	if speaking := time.Duration(spoken-pauseFrames) * prosodyHop; speaking > 0 {
+		features.SpeechRate = float64(syllables) / speaking.Seconds()
+	}
+	return features
+}
+
+// estimatePitch 用归一化自相关估计一帧的基频，清音或静音返回0
+func estimatePitch(frame []int16, sampleRate int) float64 {
+	minLag := int(float64(sampleRate) / maxPitch)
+	maxLag := int(float64(sampleRate) / minPitch)
+	if maxLag >= len(frame) {
+		maxLag = len(frame) - 1
+	}
+
+	x := make([]float64, len(frame))
+	mean := 0.0
+	for i, s := range frame {
+		x[i] = float64(s)
+		mean += x[i]
+	}
+	mean /= float64(len(x))
+	for i := range x {
+		x[i] -= mean
+	}
+
+	bestLag, best := 0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		var xy, xx, yy float64
+		for i := 0; i+lag < len(x); i++ {
+			xy += x[i] * x[i+lag]
+			xx += x[i] * x[i]
+			yy += x[i+lag] * x[i+lag]
+		}
+		if xx == 0 || yy == 0 {
+			continue
+		}
+		if r := xy / math.Sqrt(xx*yy); r > best {
+			bestLag, best = lag, r
+		}
+	}
+	if best < voicingThreshold || bestLag == 0 {
+		return 0
+	}
+	return float64(sampleRate) / float64(bestLag)
+}
+
+// meanStd 计算均值和标准差
+func meanStd(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
+
+// percentile 返回values中第p分位的值，p取值0~1
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1))]
+}
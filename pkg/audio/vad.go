@@ -0,0 +1,233 @@
+package audio
+
+import (
+	"math"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+const (
+	// noiseMargin 语音帧能量需高出环境噪声的dB数
+	noiseMargin = 12.0
+	// loudMargin 能量高出阈值该dB数时不再检查过零率
+	loudMargin = 10.0
+	// onsetFrames 连续多少个语音帧判定为语音开始
+	onsetFrames = 3
+)
+
+// DefaultVADConfig 默认VAD参数，适用于16kHz的儿童语音
+func DefaultVADConfig() model.VADConfig {
+	return model.VADConfig{
+		SampleRate:      16000,
+		FrameDuration:   20 * time.Millisecond,
+		EnergyThreshold: -40,
+		ZCRThreshold:    0.35,
+		MinSpeech:       200 * time.Millisecond,
+		Hangover:        200 * time.Millisecond,
+		SilenceTimeout:  700 * time.Millisecond,
+	}
+}
+
+// Segment 检测到的语音段
+type Segment struct {
+	Start time.Duration // 相对输入起点的开始时间
+	End   time.Duration // 相对输入起点的结束时间
+	Audio []byte        // 语音段的PCM数据，包含前后拖尾
+}
+
+// Detector 基于帧能量和过零率的流式VAD
+type Detector struct {
+	config        model.VADConfig
+	frameBytes    int
+	onsetFrames   int
+	minFrames     int
+	hangFrames    int
+	silenceFrames int
+
+	pending     []byte // 不足一帧的数据
+	buffer      []byte // 从bufferStart帧开始缓存的音频
+	bufferStart int
+	frame       int // 已处理的帧数
+
+	noiseFloor  float64
+	run         int // 连续语音帧数
+	inSpeech    bool
+	speechStart int
+	lastSpeech  int
+}
+
+// NewDetector 创建VAD检测器，未设置的参数使用默认值
+func NewDetector(config *model.VADConfig) *Detector {
+	c := DefaultVADConfig()
+	if config != nil {
+		if config.SampleRate > 0 {
+			c.SampleRate = config.SampleRate
+		}
+		if config.FrameDuration > 0 {
+			c.FrameDuration = config.FrameDuration
+		}
+		if config.EnergyThreshold != 0 {
+			c.EnergyThreshold = config.EnergyThreshold
+		}
+		if config.ZCRThreshold > 0 {
+			c.ZCRThreshold = config.ZCRThreshold
+		}
+		if config.MinSpeech > 0 {
+			c.MinSpeech = config.MinSpeech
+		}
+		if config.Hangover > 0 {
+			c.Hangover = config.Hangover
+		}
+		if config.SilenceTimeout > 0 {
+			c.SilenceTimeout = config.SilenceTimeout
+		}
+	}
+
+	// 帧长不足一个采样点时帧的字节数为0，Feed无法前进，使用默认帧长
+	if int64(c.SampleRate)*int64(c.FrameDuration) < int64(time.Second) {
+		c.FrameDuration = DefaultVADConfig().FrameDuration
+	}
+
+	frames := func(d time.Duration) int {
+		return int(math.Ceil(float64(d) / float64(c.FrameDuration)))
+	}
+
+	d := &Detector{
+		config:        c,
+		frameBytes:    max(int(int64(c.SampleRate)*int64(c.FrameDuration)/int64(time.Second)), 1) * 2,
+		minFrames:     frames(c.MinSpeech),
+		hangFrames:    frames(c.Hangover),
+		silenceFrames: max(frames(c.SilenceTimeout), 1),
+		noiseFloor:    c.EnergyThreshold - noiseMargin,
+	}
+	d.onsetFrames = max(min(onsetFrames, d.minFrames), 1)
+	return d
+}
+
+// Detect 检测一段完整音频中的所有语音段
+func (d *Detector) Detect(pcm []byte) []Segment {
+	return append(d.Feed(pcm), d.Flush()...)
+}
+
+// Feed 输入PCM数据，返回在本次输入中结束的语音段
+func (d *Detector) Feed(pcm []byte) []Segment {
+	var segments []Segment
+
+	data := append(d.pending, pcm...)
+	for len(data) >= d.frameBytes {
+		frame := data[:d.frameBytes]
+		data = data[d.frameBytes:]
+
+		d.buffer = append(d.buffer, frame...)
+		if seg := d.process(DecodePCM16(frame)); seg != nil {
+			segments = append(segments, *seg)
+		}
+		d.frame++
+	}
+	d.pending = append([]byte(nil), data...)
+
+	return segments
+}
+
+// Flush 结束输入，返回尚未结束的语音段并重置检测器
+func (d *Detector) Flush() []Segment {
+	var segments []Segment
+	if d.inSpeech {
+		if seg := d.emit(d.frame); seg != nil {
+			segments = append(segments, *seg)
+		}
+	}
+
+	d.pending = nil
+	d.buffer = nil
+	d.bufferStart = d.frame
+	d.run = 0
+	d.inSpeech = false
+	return segments
+}
+
+// InSpeech 当前是否处于语音段中
+func (d *Detector) InSpeech() bool {
+	return d.inSpeech
+}
+
+// process 处理一帧音频，语音段结束时返回该语音段
+func (d *Detector) process(samples []int16) *Segment {
+	speech := d.isSpeech(samples)
+
+	if !d.inSpeech {
+		if !speech {
+			d.run = 0
+			// 只保留可能作为下一段语音前导的音频
+			if keep := d.onsetFrames + d.hangFrames; d.frame+1-d.bufferStart > keep {
+				d.drop(d.frame + 1 - keep)
+			}
+			return nil
+		}
+
+		d.run++
+		if d.run >= d.onsetFrames {
+			d.inSpeech = true
+			d.speechStart = d.frame - d.run + 1
+			d.lastSpeech = d.frame
+		}
+		return nil
+	}
+
+	if speech {
+		d.lastSpeech = d.frame
+		return nil
+	}
+	if d.frame-d.lastSpeech < d.silenceFrames {
+		return nil
+	}
+
+	seg := d.emit(d.frame + 1)
+	d.inSpeech = false
+	d.run = 0
+	return seg
+}
+
+// emit 生成当前语音段，limit为已缓存音频的结束帧；过短的语音段被丢弃
+func (d *Detector) emit(limit int) *Segment {
+	start := max(d.speechStart-d.hangFrames, d.bufferStart)
+	end := min(d.lastSpeech+1+d.hangFrames, limit)
+
+	var seg *Segment
+	if d.lastSpeech+1-d.speechStart >= d.minFrames {
+		audio := d.buffer[(start-d.bufferStart)*d.frameBytes : (end-d.bufferStart)*d.frameBytes]
+		seg = &Segment{
+			Start: time.Duration(start) * d.config.FrameDuration,
+			End:   time.Duration(end) * d.config.FrameDuration,
+			Audio: append([]byte(nil), audio...),
+		}
+	}
+
+	d.drop(end)
+	return seg
+}
+
+// drop 丢弃frame之前缓存的音频
+func (d *Detector) drop(frame int) {
+	if frame <= d.bufferStart {
+		return
+	}
+	n := min((frame-d.bufferStart)*d.frameBytes, len(d.buffer))
+	d.buffer = append(d.buffer[:0], d.buffer[n:]...)
+	d.bufferStart = frame
+}
+
+// isSpeech 判断一帧是否为语音，并在非语音帧上更新环境噪声估计
+func (d *Detector) isSpeech(samples []int16) bool {
+	energy := FrameEnergy(samples)
+	threshold := math.Max(d.config.EnergyThreshold, d.noiseFloor+noiseMargin)
+
+	speech := energy >= threshold+loudMargin ||
+		(energy >= threshold && ZeroCrossingRate(samples) <= d.config.ZCRThreshold)
+
+	if !speech {
+		d.noiseFloor = 0.9*d.noiseFloor + 0.1*energy
+	}
+	return speech
+}
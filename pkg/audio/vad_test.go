@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sweekar/biz/model"
+)
+
+const testSampleRate = 16000
+
+// tone 生成一段200Hz的正弦波，模拟说话的声音
+func tone(d time.Duration) []byte {
+	n := int(d * testSampleRate / time.Second)
+	data := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		s := int16(0.3 * 32767 * math.Sin(2*math.Pi*200*float64(i)/testSampleRate))
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(s))
+	}
+	return data
+}
+
+// silence 生成一段静音
+func silence(d time.Duration) []byte {
+	return make([]byte, int(d*testSampleRate/time.Second)*2)
+}
+
+func concat(parts ...[]byte) []byte {
+	var data []byte
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	return data
+}
+
+func TestDetectorDetect(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		pcm      []byte
+		segments [][2]time.Duration // 每个语音段的开始和结束时间
+	}{
+		{
+			name: "静音",
+			pcm:  silence(2 * time.Second),
+		},
+		{
+			name:     "一句话",
+			pcm:      concat(silence(500*ms), tone(time.Second), silence(time.Second)),
+			segments: [][2]time.Duration{{300 * ms, 1700 * ms}},
+		},
+		{
+			name: "短于最短语音长度的声音",
+			pcm:  concat(silence(500*ms), tone(100*ms), silence(time.Second)),
+		},
+		{
+			name:     "停顿较长的两句话",
+			pcm:      concat(silence(500*ms), tone(time.Second), silence(time.Second), tone(500*ms), silence(time.Second)),
+			segments: [][2]time.Duration{{300 * ms, 1700 * ms}, {2300 * ms, 3200 * ms}},
+		},
+		{
+			name:     "句中短暂停顿",
+			pcm:      concat(silence(500*ms), tone(500*ms), silence(300*ms), tone(500*ms), silence(time.Second)),
+			segments: [][2]time.Duration{{300 * ms, 2000 * ms}},
+		},
+		{
+			name:     "输入结束时仍在说话",
+			pcm:      concat(silence(500*ms), tone(time.Second)),
+			segments: [][2]time.Duration{{300 * ms, 1500 * ms}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := NewDetector(nil).Detect(tt.pcm)
+			if len(segments) != len(tt.segments) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(tt.segments))
+			}
+			for i, seg := range segments {
+				if seg.Start != tt.segments[i][0] || seg.End != tt.segments[i][1] {
+					t.Errorf("segment %d = [%v, %v], want [%v, %v]", i, seg.Start, seg.End, tt.segments[i][0], tt.segments[i][1])
+				}
+				if want := int((seg.End - seg.Start) * testSampleRate / time.Second * 2); len(seg.Audio) != want {
+					t.Errorf("segment %d has %d bytes of audio, want %d", i, len(seg.Audio), want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectorFeedInChunks(t *testing.T) {
+	pcm := concat(silence(500*time.Millisecond), tone(time.Second), silence(time.Second), tone(time.Second))
+	want := NewDetector(nil).Detect(pcm)
+
+	// 按不对齐帧长的小块输入，结果应与一次性检测相同
+	d := NewDetector(nil)
+	var got []Segment
+	for len(pcm) > 0 {
+		n := min(333, len(pcm))
+		got = append(got, d.Feed(pcm[:n])...)
+		pcm = pcm[n:]
+	}
+	got = append(got, d.Flush()...)
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].Start != want[i].Start || got[i].End != want[i].End || len(got[i].Audio) != len(want[i].Audio) {
+			t.Errorf("segment %d = [%v, %v], want [%v, %v]", i, got[i].Start, got[i].End, want[i].Start, want[i].End)
+		}
+	}
+}
+
+func TestNewDetectorFrameTooShort(t *testing.T) {
+	pcm := concat(silence(500*time.Millisecond), tone(time.Second), silence(time.Second))
+	want := NewDetector(nil).Detect(pcm)
+
+	// 帧长不足一个采样点时使用默认帧长，而不是卡在Feed中
+	done := make(chan []Segment)
+	go func() {
+		done <- NewDetector(&model.VADConfig{SampleRate: testSampleRate, FrameDuration: time.Microsecond}).Detect(pcm)
+	}()
+	select {
+	case got := <-done:
+		if len(got) != len(want) {
+			t.Errorf("got %d segments, want %d", len(got), len(want))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Detect did not return")
+	}
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/sweekar/biz/model"
+)
+
+// models 保存在MySQL中的表，聊天记录保存在MongoDB中
+var models = []interface{}{
+	&model.User{},
+	&model.ChildProfile{},
+	&model.AuthSession{},
+	&model.RefreshToken{},
+	&model.PairingCode{},
+	&model.Device{},
+	&model.Family{},
+	&model.FamilyMember{},
+	&model.FamilyInvitation{},
+	&model.Character{},
+	&model.EmotionRecord{},
+	&model.EmotionReport{},
+	&model.SafetyIntervention{},
+	&model.SafetyAlert{},
+	&model.JobRun{},
+	&model.JobRunItem{},
+	&model.JobControl{},
+	&model.OutboxMessage{},
+	&model.LeaderLease{},
+	&model.NotificationSettings{},
+	&model.Notification{},
+	&model.NotificationDelivery{},
+}
+
+// Migrate 创建或更新所有表，先清理会导致新唯一索引创建失败的旧数据
+func Migrate(db *gorm.DB) error {
+	if err := dedupeEmotionReports(db); err != nil {
+		return fmt.Errorf("dedupe emotion reports error: %v", err)
+	}
+	if err := dedupeEmotionRecords(db); err != nil {
+		return fmt.Errorf("dedupe emotion records error: %v", err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		return fmt.Errorf("migrate mysql error: %v", err)
+	}
+	return nil
+}
+
+// dedupeEmotionReports 创建报告唯一索引之前删除重复的报告，同一用户同一周期同一天只保留最后生成的一份；
+// 旧表没有周期字段时先按默认的日报补上
+func dedupeEmotionReports(db *gorm.DB) error {
+	migrator := db.Migrator()
+	report := &model.EmotionReport{}
+	if !migrator.HasTable(report) || migrator.HasIndex(report, "idx_report_user_period_date") {
+		return nil
+	}
+	if !migrator.HasColumn(report, "Period") {
+		if err := migrator.AddColumn(report, "Period"); err != nil {
+			return err
+		}
+	}
+
+	// MySQL不能在删除语句的子查询中直接引用同一张表，套一层派生表
+	return db.Exec(`DELETE FROM emotion_reports WHERE id NOT IN (
+		SELECT id FROM (SELECT MAX(id) AS id FROM emotion_reports GROUP BY user_id, period, date) AS kept
+	)`).Error
+}
+
+// dedupeEmotionRecords 创建情绪记录唯一索引之前，将文字聊天记录的空消息ID改为NULL，
+// 并删除消息队列重复投递产生的记录，同一用户同一条语音消息只保留最早的一条；
+// 旧表没有消息ID字段时不会有重复，由AutoMigrate补上字段和索引
+func dedupeEmotionRecords(db *gorm.DB) error {
+	migrator := db.Migrator()
+	record := &model.EmotionRecord{}
+	if !migrator.HasTable(record) || !migrator.HasColumn(record, "MessageID") ||
+		migrator.HasIndex(record, "idx_emotion_user_message") {
+		return nil
+	}
+
+	if err := db.Model(record).Where("message_id = ?", "").Update("message_id", nil).Error; err != nil {
+		return err
+	}
+	return db.Exec(`DELETE FROM emotion_records WHERE message_id IS NOT NULL AND id NOT IN (
+		SELECT id FROM (SELECT MIN(id) AS id FROM emotion_records WHERE message_id IS NOT NULL GROUP BY user_id, message_id) AS kept
+	)`).Error
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/sweekar/biz/model"
+)
+
+// baselineEmotionRecord 添加消息ID之前的情绪记录表
+type baselineEmotionRecord struct {
+	ID         uint64 `gorm:"primaryKey"`
+	UserID     uint64 `gorm:"index"`
+	ChatID     uint64 `gorm:"index"`
+	Emotion    string
+	Confidence float64
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+func (baselineEmotionRecord) TableName() string {
+	return "emotion_records"
+}
+
+func TestMigrateBaselineEmotionRecords(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/test.db"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	if err := db.AutoMigrate(&baselineEmotionRecord{}); err != nil {
+		t.Fatalf("create baseline table: %v", err)
+	}
+	for chatID := uint64(1); chatID <= 2; chatID++ {
+		if err := db.Create(&baselineEmotionRecord{UserID: 7, ChatID: chatID, Emotion: "happy"}).Error; err != nil {
+			t.Fatalf("create record: %v", err)
+		}
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	var count int64
+	if err := db.Model(&model.EmotionRecord{}).Where("message_id IS NULL").Count(&count).Error; err != nil {
+		t.Fatalf("count records: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("got %d records without message ID, want 2", count)
+	}
+	if !db.Migrator().HasIndex(&model.EmotionRecord{}, "idx_emotion_user_message") {
+		t.Error("unique index on user and message ID not created")
+	}
+}
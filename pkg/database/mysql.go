@@ -0,0 +1,77 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// NodeConfig 单个MySQL节点配置，对应configs/database.yaml中的master和slaves
+type NodeConfig struct {
+	Host            string `yaml:"host"`
+	Port            int    `yaml:"port"`
+	Database        string `yaml:"database"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	MaxIdleConns    int    `yaml:"max_idle_conns"`
+	MaxOpenConns    int    `yaml:"max_open_conns"`
+	ConnMaxLifetime int    `yaml:"conn_max_lifetime"` // 连接最长存活时间，单位秒
+}
+
+// MySQLConfig MySQL主从配置，写操作走主库，读操作在从库间轮询
+type MySQLConfig struct {
+	Master NodeConfig   `yaml:"master"`
+	Slaves []NodeConfig `yaml:"slaves"`
+}
+
+// dsn 节点的连接串，时间按UTC读写
+func (c *NodeConfig) dsn() string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=true&loc=UTC",
+		c.Username, c.Password, c.Host, c.Port, c.Database)
+}
+
+// Open 连接MySQL主库并迁移表结构，配置了从库时启用读写分离
+func Open(config *MySQLConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(mysql.Open(config.Master.dsn()), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("open mysql master error: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("get mysql master error: %v", err)
+	}
+	sqlDB.SetMaxIdleConns(config.Master.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(config.Master.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(time.Duration(config.Master.ConnMaxLifetime) * time.Second)
+
+	// 启用读写分离之前在主库上迁移，多个实例同时启动时迁移语句可以重复执行
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+
+	if len(config.Slaves) == 0 {
+		return db, nil
+	}
+
+	// 连接池参数各节点相同，使用第一个从库的配置
+	replicas := make([]gorm.Dialector, 0, len(config.Slaves))
+	for i := range config.Slaves {
+		replicas = append(replicas, mysql.Open(config.Slaves[i].dsn()))
+	}
+	slave := config.Slaves[0]
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}).
+		SetMaxIdleConns(slave.MaxIdleConns).
+		SetMaxOpenConns(slave.MaxOpenConns).
+		SetConnMaxLifetime(time.Duration(slave.ConnMaxLifetime) * time.Second)
+	if err := db.Use(resolver); err != nil {
+		return nil, fmt.Errorf("register mysql slaves error: %v", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+    "errors"
+    "net/http"
+    "slices"
+    "strconv"
+    "strings"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/service"
+)
+
+// AuthMiddleware 校验访问令牌，并在上下文中设置user_id、role、family_id和session_id；
+// 浏览器建立WebSocket连接时无法设置请求头，可以通过token查询参数传递令牌。
+// 限定了权限范围的令牌（如孩子设备的语音令牌）只能访问scopes中列出的范围
+func AuthMiddleware(authService *service.AuthService, scopes ...string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        token := bearerToken(c)
+        if token == "" {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "message": "未授权"})
+            return
+        }
+
+        claims, err := authService.ValidateAccessToken(c.Request.Context(), token)
+        if err != nil {
+            if errors.Is(err, service.ErrInvalidToken) {
+                c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "message": err.Error()})
+                return
+            }
+            c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+            return
+        }
+
+        if claims.Scope != "" && !slices.Contains(scopes, claims.Scope) {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 403, "message": "令牌无权访问该接口"})
+            return
+        }
+        // 设备令牌只能在配对的设备上使用
+        if claims.DeviceID != "" && deviceID(c) != claims.DeviceID {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "message": "令牌与设备不匹配"})
+            return
+        }
+
+        c.Set("user_id", strconv.FormatUint(claims.UserID, 10))
+        c.Set("role", string(claims.Role))
+        c.Set("family_id", claims.FamilyID)
+        c.Set("session_id", claims.SessionID)
+        c.Set("scope", claims.Scope)
+        c.Next()
+    }
+}
+
+// bearerToken 从Authorization请求头中获取令牌，WebSocket握手请求也可以使用token查询参数
+func bearerToken(c *gin.Context) string {
+    header := c.GetHeader("Authorization")
+    if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+        return strings.TrimSpace(token)
+    }
+    if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+        return c.Query("token")
+    }
+    return ""
+}
+
+// deviceID 从X-Device-ID请求头中获取设备标识，WebSocket握手请求也可以使用device_id查询参数
+func deviceID(c *gin.Context) string {
+    if id := c.GetHeader("X-Device-ID"); id != "" {
+        return id
+    }
+    if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+        return c.Query("device_id")
+    }
+    return ""
+}
\ No newline at end of file
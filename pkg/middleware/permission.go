@@ -0,0 +1,50 @@
+package middleware
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "github.com/gin-gonic/gin"
+    "github.com/sweekar/biz/model"
+    "github.com/sweekar/biz/service"
+)
+
+// RequirePermission 只允许角色拥有permission的用户访问，需要在AuthMiddleware之后使用
+func RequirePermission(permission model.Permission) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if !model.UserRole(c.GetString("role")).Can(permission) {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": 403, "message": "无权限"})
+            return
+        }
+        c.Next()
+    }
+}
+
+// ChildAccess 校验child_id参数指定的孩子属于当前家长，并在上下文中设置child_id，
+// 需要在RequirePermission(model.PermissionViewChildren)之后使用
+func ChildAccess(userService *service.UserService) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        parentID, err := strconv.ParseUint(c.GetString("user_id"), 10, 64)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": 401, "message": "未授权"})
+            return
+        }
+        childID, err := strconv.ParseUint(c.Query("child_id"), 10, 64)
+        if err != nil {
+            c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": 400, "message": "孩子ID无效"})
+            return
+        }
+
+        if _, err := userService.GetChild(c.Request.Context(), parentID, childID); err != nil {
+            if errors.Is(err, service.ErrChildNotFound) {
+                c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"code": 404, "message": err.Error()})
+                return
+            }
+            c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": 500, "message": err.Error()})
+            return
+        }
+
+        c.Set("child_id", childID)
+        c.Next()
+    }
+}
\ No newline at end of file
@@ -0,0 +1,37 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+)
+
+// 消息总线驱动
+const (
+	DriverRocketMQ = "rocketmq"
+	DriverMemory   = "memory"
+)
+
+// Bus 消息总线，语音流水线各阶段通过它传递消息
+type Bus interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	SendMessage(ctx context.Context, topic string, msg interface{}) error
+	ConsumeMessage(ctx context.Context, topic string, numWorkers int, handler func(context.Context, []byte) error) error
+}
+
+var (
+	_ Bus = (*RocketMQClient)(nil)
+	_ Bus = (*MemoryBus)(nil)
+)
+
+// NewBus 按驱动创建消息总线，未指定驱动时使用RocketMQ
+func NewBus(driver string, config *RocketMQConfig) (Bus, error) {
+	switch driver {
+	case "", DriverRocketMQ:
+		return NewRocketMQClient(config), nil
+	case DriverMemory:
+		return NewMemoryBus(&MemoryBusConfig{MaxRetries: config.MaxRetries}), nil
+	default:
+		return nil, fmt.Errorf("unknown mq driver: %s", driver)
+	}
+}
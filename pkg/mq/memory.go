@@ -0,0 +1,169 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fatedier/beego/logs"
+)
+
+// MemoryBusConfig 进程内消息总线配置
+type MemoryBusConfig struct {
+	MaxRetries   int           // 消费失败后的最大重试次数
+	RetryBackoff time.Duration // 重试间隔，按重试次数线性增加
+	QueueSize    int           // 每个主题的队列长度
+}
+
+// memoryMessage 进程内消息
+type memoryMessage struct {
+	body     []byte
+	attempts int
+}
+
+// MemoryBus 基于channel的进程内消息总线，用于本地开发和测试
+type MemoryBus struct {
+	config *MemoryBusConfig
+	topics map[string]chan *memoryMessage
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mutex  sync.RWMutex
+}
+
+// NewMemoryBus 创建进程内消息总线
+func NewMemoryBus(config *MemoryBusConfig) *MemoryBus {
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MemoryBus{
+		config: config,
+		topics: make(map[string]chan *memoryMessage),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start 启动消息总线
+func (b *MemoryBus) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop 停止消息总线，等待正在处理的消息完成
+func (b *MemoryBus) Stop(ctx context.Context) error {
+	b.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("stop memory bus error: %v", ctx.Err())
+	}
+}
+
+// SendMessage 发送消息，主题队列已满时阻塞
+func (b *MemoryBus) SendMessage(ctx context.Context, topic string, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("marshal message error: %v", err)
+	}
+
+	return b.enqueue(ctx, topic, &memoryMessage{body: body})
+}
+
+// ConsumeMessage 消费消息，每个主题启动numWorkers个工作协程
+func (b *MemoryBus) ConsumeMessage(ctx context.Context, topic string, numWorkers int, handler func(context.Context, []byte) error) error {
+	if b.ctx.Err() != nil {
+		return fmt.Errorf("memory bus stopped")
+	}
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+
+	queue := b.queue(topic)
+	for i := 0; i < numWorkers; i++ {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for {
+				select {
+				case <-b.ctx.Done():
+					return
+				case msg := <-queue:
+					b.handle(topic, msg, handler)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// handle 处理一条消息，失败时延迟重新投递
+func (b *MemoryBus) handle(topic string, msg *memoryMessage, handler func(context.Context, []byte) error) {
+	err := handler(b.ctx, msg.body)
+	if err == nil {
+		return
+	}
+
+	msg.attempts++
+	if msg.attempts > b.config.MaxRetries {
+		logs.Error("消息重试次数超过上限，丢弃消息: topic=%s, err=%v", topic, err)
+		return
+	}
+
+	b.wg.Add(1)
+	time.AfterFunc(time.Duration(msg.attempts)*b.config.RetryBackoff, func() {
+		defer b.wg.Done()
+		if err := b.enqueue(b.ctx, topic, msg); err != nil {
+			logs.Error("重新投递消息失败: topic=%s, err=%v", topic, err)
+		}
+	})
+}
+
+// enqueue 将消息放入主题队列
+func (b *MemoryBus) enqueue(ctx context.Context, topic string, msg *memoryMessage) error {
+	queue := b.queue(topic)
+
+	select {
+	case queue <- msg:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("send message error: %v", ctx.Err())
+	case <-b.ctx.Done():
+		return fmt.Errorf("send message error: memory bus stopped")
+	}
+}
+
+// queue 获取主题队列，不存在时创建
+func (b *MemoryBus) queue(topic string) chan *memoryMessage {
+	b.mutex.RLock()
+	queue, ok := b.topics[topic]
+	b.mutex.RUnlock()
+	if ok {
+		return queue
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if queue, ok = b.topics[topic]; !ok {
+		queue = make(chan *memoryMessage, b.config.QueueSize)
+		b.topics[topic] = queue
+	}
+	return queue
+}
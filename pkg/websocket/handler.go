@@ -2,21 +2,42 @@ package websocket
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sweekar/biz/model"
-	"github.com/sweekar/biz/service"
+	"github.com/sweekar/pkg/audio"
 )
 
 // MessageType 消息类型
 type MessageType string
 
 const (
-	VoiceChat    MessageType = "voice_chat"    // 语音聊天消息
-	VoiceResponse MessageType = "voice_response" // 语音响应消息
+	VoiceStart           MessageType = "voice_start"    // 开始发送语音流
+	VoiceEnd             MessageType = "voice_end"      // 结束发送语音流
+	VoiceStarted         MessageType = "voice_started"  // 语音流已就绪
+	VoiceResponse        MessageType = "voice_response" // 语音响应消息
+	SafetyAlertMessage   MessageType = "safety_alert"   // 推送给家长的安全提醒
+	EmotionReportMessage MessageType = "emotion_report" // 推送给家长的情绪报告
+	ErrorMessage         MessageType = "error"          // 错误消息
+)
+
+// 支持的音频编码
+const (
+	CodecPCM16 = "pcm16" // 16bit小端单声道PCM
+)
+
+const (
+	defaultSampleRate = 16000
+	// maxUtterance 单句话的最长时长，超过后强制切分
+	maxUtterance = 30 * time.Second
 )
 
 // Message WebSocket消息结构
@@ -25,35 +46,89 @@ type Message struct {
 	Payload interface{}    `json:"payload"`
 }
 
+// VoiceStartPayload voice_start消息内容
+type VoiceStartPayload struct {
+	SessionID   string `json:"session_id"`   // 继续已有会话时携带，为空时沿用连接的会话
+	Codec       string `json:"codec"`        // 音频编码，目前仅支持pcm16
+	SampleRate  int    `json:"sample_rate"`  // 采样率，默认16000
+	CharacterID uint64 `json:"character_id"` // 选择的系统角色
+}
+
+// VoiceProcessor 语音处理服务
+type VoiceProcessor interface {
+	ProcessVoice(ctx context.Context, msg *model.VoiceMessage) error
+}
+
+// SessionStore 查询会话的归属，客户端继续已有会话时用来校验会话属于当前用户
+type SessionStore interface {
+	SessionOwnedBy(ctx context.Context, userID uint64, sessionID string) (bool, error)
+}
+
+// voiceStream 连接上正在进行的语音流
+type voiceStream struct {
+	sessionID   string
+	sampleRate  int
+	characterID uint64
+	detector    *audio.Detector
+	speechBytes int // 当前语音段已接收的字节数
+}
+
 // Handler WebSocket消息处理器
 type Handler struct {
 	pool *Pool
-	voiceProcessor *service.VoiceProcessor
+	voiceProcessor VoiceProcessor
+	sessions       SessionStore
+	vadConfig      model.VADConfig
+	upgrader       websocket.Upgrader
 }
 
-// NewHandler 创建新的消息处理器
-func NewHandler(pool *Pool, voiceProcessor *service.VoiceProcessor) *Handler {
+// NewHandler 创建新的消息处理器，vadConfig为切分语音流使用的VAD参数，为nil时使用默认值，采样率由客户端指定
+func NewHandler(pool *Pool, voiceProcessor VoiceProcessor, sessions SessionStore, vadConfig *model.VADConfig) *Handler {
+	config := audio.DefaultVADConfig()
+	if vadConfig != nil {
+		config = *vadConfig
+	}
 	return &Handler{
 		pool:           pool,
 		voiceProcessor: voiceProcessor,
+		sessions:       sessions,
+		vadConfig:      config,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
 	}
 }
 
-// HandleConnection 处理新的WebSocket连接
-func (h *Handler) HandleConnection(conn *websocket.Conn, userID, parentID uint64) {
-	client := &Client{
-		Conn:     conn,
-		UserID:   userID,
-		ParentID: parentID,
+// ServeWS 将HTTP请求升级为WebSocket连接并处理，连接关闭后返回；client为连接的用户信息，
+// 其中CharacterID为孩子偏好的角色，voice_start未选择角色时使用
+func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request, client *Client) error {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("升级websocket连接失败: %v", err)
 	}
+	defer conn.Close()
+
+	client.Conn = conn
+	h.HandleConnection(client)
+	return nil
+}
+
+// HandleConnection 处理新的WebSocket连接
+func (h *Handler) HandleConnection(client *Client) {
+	conn := client.Conn
 
 	// 注册客户端
 	h.pool.Register(client)
-	defer h.pool.Unregister(userID)
+	defer h.pool.Unregister(client)
+
+	// 连接内的多次语音流默认属于同一个会话
+	sessionID := newRandomID()
+	var stream *voiceStream
 
 	// 开始接收消息
 	for {
-		_, message, err := conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("websocket连接异常关闭: %v", err)
@@ -61,46 +136,183 @@ func (h *Handler) HandleConnection(conn *websocket.Conn, userID, parentID uint64
 			break
 		}
 
+		// 二进制消息为语音流的音频帧
+		if messageType == websocket.BinaryMessage {
+			h.handleAudioFrame(message, client, stream)
+			continue
+		}
+
 		// 处理接收到的消息
-		h.handleMessage(message, client)
+		stream = h.handleMessage(message, client, sessionID, stream)
+		if stream != nil {
+			sessionID = stream.sessionID
+		}
+	}
+
+	// 连接断开时处理尚未结束的语音
+	if stream != nil {
+		h.flushStream(client, stream)
 	}
 }
 
-// handleMessage 处理接收到的消息
-func (h *Handler) handleMessage(data []byte, client *Client) {
-	var msg Message
+// handleMessage 处理接收到的控制消息，返回连接当前的语音流
+func (h *Handler) handleMessage(data []byte, client *Client, sessionID string, stream *voiceStream) *voiceStream {
+	var msg struct {
+		Type    MessageType     `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
 	if err := json.Unmarshal(data, &msg); err != nil {
 		log.Printf("解析消息失败: %v", err)
-		return
+		return stream
 	}
 
 	switch msg.Type {
-	case VoiceChat:
-		h.handleVoiceChat(msg.Payload, client)
+	case VoiceStart:
+		if stream != nil {
+			h.flushStream(client, stream)
+		}
+		return h.handleVoiceStart(msg.Payload, client, sessionID)
+	case VoiceEnd:
+		if stream != nil {
+			h.flushStream(client, stream)
+		}
+		return nil
 	default:
 		log.Printf("未知的消息类型: %s", msg.Type)
+		return stream
+	}
+}
+
+// handleVoiceStart 开始新的语音流
+func (h *Handler) handleVoiceStart(payload json.RawMessage, client *Client, sessionID string) *voiceStream {
+	var req VoiceStartPayload
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &req); err != nil {
+			h.sendError(client, "voice_start参数错误")
+			return nil
+		}
 	}
+
+	if req.Codec != "" && req.Codec != CodecPCM16 {
+		h.sendError(client, fmt.Sprintf("不支持的音频编码: %s", req.Codec))
+		return nil
+	}
+	if req.SampleRate == 0 {
+		req.SampleRate = defaultSampleRate
+	}
+	if req.SampleRate < 8000 || req.SampleRate > 48000 {
+		h.sendError(client, fmt.Sprintf("不支持的采样率: %d", req.SampleRate))
+		return nil
+	}
+	if req.SessionID != "" && req.SessionID != sessionID {
+		// 会话ID由客户端提供，只能继续自己的会话
+		owned, err := h.sessions.SessionOwnedBy(context.Background(), client.UserID, req.SessionID)
+		if err != nil {
+			log.Printf("查询会话归属失败: %v", err)
+			h.sendError(client, "会话校验失败，请重试")
+			return nil
+		}
+		if !owned {
+			h.sendError(client, "会话不存在")
+			return nil
+		}
+		sessionID = req.SessionID
+	}
+	if req.CharacterID == 0 {
+		req.CharacterID = client.CharacterID
+	}
+
+	vadConfig := h.vadConfig
+	vadConfig.SampleRate = req.SampleRate
+	stream := &voiceStream{
+		sessionID:   sessionID,
+		sampleRate:  req.SampleRate,
+		characterID: req.CharacterID,
+		detector:    audio.NewDetector(&vadConfig),
+	}
+
+	h.send(client, VoiceStarted, map[string]string{"session_id": sessionID})
+	return stream
 }
 
+// handleAudioFrame 处理音频帧，检测到一句话结束时交给语音处理服务
+func (h *Handler) handleAudioFrame(data []byte, client *Client, stream *voiceStream) {
+	if stream == nil {
+		h.sendError(client, "请先发送voice_start")
+		return
+	}
 
+	for _, seg := range stream.detector.Feed(data) {
+		h.processSegment(client, stream, seg)
+	}
 
-// handleVoiceChat 处理语音聊天消息
-func (h *Handler) handleVoiceChat(payload interface{}, client *Client) {
-	// 将语音消息转换为VoiceMessage结构
-	voiceData, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("序列化语音数据失败: %v", err)
+	// 一直没有检测到停顿时强制切分，避免缓存无限增长
+	if !stream.detector.InSpeech() {
+		stream.speechBytes = 0
 		return
 	}
+	stream.speechBytes += len(data)
+	if stream.speechBytes >= stream.sampleRate*2*int(maxUtterance/time.Second) {
+		h.flushStream(client, stream)
+	}
+}
 
-	// 创建语音消息对象
+// flushStream 结束语音流中正在进行的语音段
+func (h *Handler) flushStream(client *Client, stream *voiceStream) {
+	for _, seg := range stream.detector.Flush() {
+		h.processSegment(client, stream, seg)
+	}
+	stream.speechBytes = 0
+}
+
+// processSegment 将一句话的音频交给语音处理服务
+func (h *Handler) processSegment(client *Client, stream *voiceStream, seg audio.Segment) {
+	// 消息ID用于响应排序、聊天记录和提醒去重，必须全局唯一，
+	// 同一会话可以在多次voice_start和多个连接中继续
 	voiceMsg := &model.VoiceMessage{
-		UserID: client.UserID,
-		Data:   voiceData,
+		ID:          newRandomID(),
+		UserID:      strconv.FormatUint(client.UserID, 10),
+		SessionID:   stream.sessionID,
+		CharacterID: stream.characterID,
+		SampleRate:  stream.sampleRate,
+		Segmented:   true,
+		Data:        seg.Audio,
+		CreatedAt:   time.Now(),
 	}
 
 	// 调用语音处理服务
 	if err := h.voiceProcessor.ProcessVoice(context.Background(), voiceMsg); err != nil {
 		log.Printf("处理语音消息失败: %v", err)
+		h.sendError(client, "语音处理失败，请重试")
 	}
-}
\ No newline at end of file
+}
+
+// send 向客户端发送消息
+func (h *Handler) send(client *Client, msgType MessageType, payload interface{}) {
+	data, err := json.Marshal(Message{Type: msgType, Payload: payload})
+	if err != nil {
+		log.Printf("序列化消息失败: %v", err)
+		return
+	}
+
+	client.Mu.Lock()
+	defer client.Mu.Unlock()
+
+	if err := client.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Printf("发送消息失败: %v", err)
+	}
+}
+
+// sendError 向客户端发送错误消息
+func (h *Handler) sendError(client *Client, message string) {
+	h.send(client, ErrorMessage, map[string]string{"message": message})
+}
+
+// newRandomID 生成随机的会话ID和消息ID
+func newRandomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
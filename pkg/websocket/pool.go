@@ -1,6 +1,8 @@
 package websocket
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/gorilla/websocket"
@@ -8,71 +10,150 @@ import (
 
 // Client 表示一个WebSocket客户端连接
 type Client struct {
-	Conn     *websocket.Conn
-	UserID   uint64
-	ParentID uint64
-	Mu       sync.Mutex
+	Conn        *websocket.Conn
+	UserID      uint64
+	CharacterID uint64   // 孩子偏好的角色
+	GuardianIDs []uint64 // 孩子连接时为孩子的所有监护人
+	ChildIDs    []uint64 // 监护人连接时为其监护的孩子
+	Mu          sync.Mutex
 }
 
 // Pool 管理所有WebSocket连接
 type Pool struct {
-	clients map[uint64]*Client // 用户ID到客户端的映射
-	mu      sync.RWMutex
+	clients   map[uint64]map[*Client]struct{} // 用户ID到客户端集合的映射，同一用户可以有多个设备同时在线
+	guardians map[uint64]map[uint64]struct{}  // 孩子ID到监护人ID集合的映射
+	mu        sync.RWMutex
 }
 
 // NewPool 创建一个新的连接池
 func NewPool() *Pool {
 	return &Pool{
-		clients: make(map[uint64]*Client),
+		clients:   make(map[uint64]map[*Client]struct{}),
+		guardians: make(map[uint64]map[uint64]struct{}),
 	}
 }
 
-// Register 注册一个新的客户端连接
+// Register 注册一个新的客户端连接，并记录孩子与监护人的对应关系
 func (p *Pool) Register(client *Client) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.clients[client.UserID] = client
+	if p.clients[client.UserID] == nil {
+		p.clients[client.UserID] = make(map[*Client]struct{})
+	}
+	p.clients[client.UserID][client] = struct{}{}
+	if len(client.GuardianIDs) > 0 {
+		p.bindGuardians(client.UserID, client.GuardianIDs)
+	}
+	for _, childID := range client.ChildIDs {
+		p.addGuardian(childID, client.UserID)
+	}
 }
 
-// Unregister 注销一个客户端连接
-func (p *Pool) Unregister(userID uint64) {
+// BindGuardians 用guardianIDs替换孩子的监护人
+func (p *Pool) BindGuardians(childID uint64, guardianIDs []uint64) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	delete(p.clients, userID)
+	p.bindGuardians(childID, guardianIDs)
 }
 
-// GetParentClient 获取指定孩子的家长客户端
-func (p *Pool) GetParentClient(childID uint64) *Client {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
+// AddGuardian 为孩子增加一个监护人，邀请被接受后调用
+func (p *Pool) AddGuardian(childID, guardianID uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.addGuardian(childID, guardianID)
+}
+
+// RemoveGuardian 移除孩子的监护人，之后不再向其推送该孩子的消息
+func (p *Pool) RemoveGuardian(childID, guardianID uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.guardians[childID], guardianID)
+	if len(p.guardians[childID]) == 0 {
+		delete(p.guardians, childID)
+	}
+}
+
+func (p *Pool) bindGuardians(childID uint64, guardianIDs []uint64) {
+	guardians := make(map[uint64]struct{}, len(guardianIDs))
+	for _, id := range guardianIDs {
+		guardians[id] = struct{}{}
+	}
+	p.guardians[childID] = guardians
+}
 
-	parentID, exists := p.parentMap[childID]
-	if !exists {
-		return nil
+func (p *Pool) addGuardian(childID, guardianID uint64) {
+	if p.guardians[childID] == nil {
+		p.guardians[childID] = make(map[uint64]struct{})
 	}
+	p.guardians[childID][guardianID] = struct{}{}
+}
+
+// Unregister 注销一个客户端连接，同一用户的其他连接不受影响
+func (p *Pool) Unregister(client *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	return p.clients[parentID]
+	delete(p.clients[client.UserID], client)
+	if len(p.clients[client.UserID]) == 0 {
+		delete(p.clients, client.UserID)
+	}
 }
 
-// SendToParent 向指定孩子的家长发送消息
-func (p *Pool) SendToParent(childID uint64, message []byte) error {
-	parentClient := p.GetParentClient(childID)
-	if parentClient == nil {
-		return nil // 家长不在线，忽略消息
+// GetGuardianClients 获取指定孩子所有在线监护人的所有客户端连接
+func (p *Pool) GetGuardianClients(childID uint64) []*Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var clients []*Client
+	for guardianID := range p.guardians[childID] {
+		for client := range p.clients[guardianID] {
+			clients = append(clients, client)
+		}
 	}
+	return clients
+}
 
-	parentClient.Mu.Lock()
-	defer parentClient.Mu.Unlock()
+// SendToGuardians 向指定孩子的所有在线监护人的每个连接发送消息，返回成功发送的连接数；
+// 监护人都不在线时返回0
+func (p *Pool) SendToGuardians(childID uint64, message []byte) (int, error) {
+	return send(p.GetGuardianClients(childID), message)
+}
 
-	return parentClient.Conn.WriteMessage(websocket.TextMessage, message)
+// SendToClient 向指定用户的所有连接发送消息
+func (p *Pool) SendToClient(userID uint64, message []byte) error {
+	_, err := send(p.GetClients(userID), message) // 用户不在线时忽略消息
+	return err
 }
 
-// GetClient 获取指定用户的客户端连接
-func (p *Pool) GetClient(userID uint64) *Client {
+// GetClients 获取指定用户的所有客户端连接
+func (p *Pool) GetClients(userID uint64) []*Client {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	return p.clients[userID]
+	clients := make([]*Client, 0, len(p.clients[userID]))
+	for client := range p.clients[userID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// send 向每个连接发送消息，返回成功发送的连接数
+func send(clients []*Client, message []byte) (int, error) {
+	var sent int
+	var errs []error
+	for _, client := range clients {
+		client.Mu.Lock()
+		err := client.Conn.WriteMessage(websocket.TextMessage, message)
+		client.Mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %v", client.UserID, err))
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
 }